@@ -0,0 +1,113 @@
+// Package message_dispatch is the send path shared by MessageManager and the
+// Reply/React helpers on message events.
+package message_dispatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/wapikit/wapi.go/internal/request_client"
+	"github.com/wapikit/wapi.go/pkg/components"
+)
+
+// Send converts a message with the given configs and POSTs it to the given
+// endpoint suffix under the phone number id, returning the parsed response.
+func Send(ctx context.Context, requester request_client.RequestClient, phoneNumberId string, message components.BaseMessage, configs components.ApiCompatibleJsonConverterConfigs, endpointSuffix string) (*components.MessageSendResponse, error) {
+	body, err := message.ToJson(configs)
+	if err != nil {
+		return nil, fmt.Errorf("error converting message to json: %v", err)
+	}
+	return SendBody(ctx, requester, phoneNumberId, body, endpointSuffix)
+}
+
+// SendBody POSTs an already converted message body, e.g. one read back from
+// the outbox, and parses the response like Send.
+func SendBody(ctx context.Context, requester request_client.RequestClient, phoneNumberId string, body []byte, endpointSuffix string) (*components.MessageSendResponse, error) {
+	apiRequest := requester.NewApiRequest(strings.Join([]string{phoneNumberId, endpointSuffix}, "/"), http.MethodPost)
+	apiRequest.SetContext(ctx)
+	apiRequest.SetBody(string(body))
+	responseStr, execErr := apiRequest.Execute()
+
+	// Parse whatever body we got — a non-2xx response carries Meta's error
+	// envelope, which callers may want alongside the returned error.
+	var sendResponse components.MessageSendResponse
+	if responseStr != "" {
+		if err := json.Unmarshal([]byte(responseStr), &sendResponse); err != nil && execErr == nil {
+			return nil, fmt.Errorf("error unmarshalling response: %v", err)
+		}
+	}
+
+	// A non-2xx HTTP status surfaces as execErr (typed *GraphAPIError); an
+	// application-level error may also appear in the parsed envelope on a 200.
+	if execErr != nil {
+		if responseStr == "" {
+			return nil, execErr
+		}
+		return &sendResponse, execErr
+	}
+	if sendResponse.Error != nil {
+		return &sendResponse, fmt.Errorf("error sending message: %s", sendResponse.Error.Message)
+	}
+	return &sendResponse, nil
+}
+
+// SendSequence sends the parts of a split message one after another with send,
+// the first with configs and the rest without its reply context, and returns
+// one response listing the message ids of all parts in order. It stops at the
+// first part that fails and returns the response so far along with the error.
+func SendSequence(parts []components.BaseMessage, configs components.ApiCompatibleJsonConverterConfigs, send func(part components.BaseMessage, configs components.ApiCompatibleJsonConverterConfigs) (*components.MessageSendResponse, error)) (*components.MessageSendResponse, error) {
+	var merged *components.MessageSendResponse
+	for i, part := range parts {
+		response, err := send(part, configs)
+		if err != nil {
+			return merged, fmt.Errorf("error sending part %d of %d: %w", i+1, len(parts), err)
+		}
+		if merged == nil {
+			merged = response
+		} else if response != nil {
+			merged.Messages = append(merged.Messages, response.Messages...)
+		}
+		configs.ReplyToMessageId = ""
+	}
+	return merged, nil
+}
+
+// MarkRead marks a message as read, optionally showing the typing indicator
+// (dismissed after 25 seconds or when the reply is sent).
+func MarkRead(ctx context.Context, requester request_client.RequestClient, phoneNumberId, messageId string, showTyping bool) error {
+	requestBody := map[string]interface{}{
+		"messaging_product": "whatsapp",
+		"status":            "read",
+		"message_id":        messageId,
+	}
+	if showTyping {
+		requestBody["typing_indicator"] = map[string]string{
+			"type": "text",
+		}
+	}
+
+	body, err := json.Marshal(requestBody)
+	if err != nil {
+		return fmt.Errorf("error marshalling read request body: %v", err)
+	}
+
+	apiRequest := requester.NewApiRequest(strings.Join([]string{phoneNumberId, "messages"}, "/"), http.MethodPost)
+	apiRequest.SetContext(ctx)
+	apiRequest.SetBody(string(body))
+	responseStr, err := apiRequest.Execute()
+	if err != nil {
+		return fmt.Errorf("error executing read message request: %w", err)
+	}
+
+	var statusResponse components.StatusResponse
+	if err := json.Unmarshal([]byte(responseStr), &statusResponse); err != nil {
+		return fmt.Errorf("error unmarshalling read response: %v", err)
+	}
+	if statusResponse.Error != nil {
+		return fmt.Errorf("error marking message as read: %s", statusResponse.Error.Message)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package request_client
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -50,6 +51,7 @@ type RequestCloudApiParams struct {
 	Path       string
 	Method     string
 	QueryParam map[string]string
+	Context    context.Context // Context bounds the HTTP request. Defaults to context.Background().
 }
 
 func (requestClientInstance *RequestClient) request(params RequestCloudApiParams) (string, error) {
@@ -70,7 +72,11 @@ func (requestClientInstance *RequestClient) request(params RequestCloudApiParams
 	requestPath := strings.Join(
 		[]string{REQUEST_PROTOCOL, "://", requestClientInstance.baseUrl, "/", requestClientInstance.apiVersion, "/", params.Path, queryParamString}, "")
 
-	httpRequest, err := http.NewRequest(params.Method,
+	ctx := params.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, params.Method,
 		requestPath,
 		strings.NewReader(params.Body))
 	if err != nil {
@@ -123,6 +129,7 @@ type ApiRequest struct {
 	Fields      []ApiRequestQueryParamField
 	QueryParams map[string]string
 	Requester   *RequestClient
+	Context     context.Context
 }
 
 func (request *ApiRequest) AddField(field ApiRequestQueryParamField) *ApiRequestQueryParamField {
@@ -144,6 +151,12 @@ func (request *ApiRequest) SetMethod(method string) {
 	request.Method = method
 }
 
+// SetContext sets the context the request is sent with, e.g. the context of
+// the event being replied to.
+func (request *ApiRequest) SetContext(ctx context.Context) {
+	request.Context = ctx
+}
+
 // SetBody sets the body for the request.
 func (request *ApiRequest) SetBody(body string) {
 	request.Body = body
@@ -182,6 +195,7 @@ func (request *ApiRequest) Execute() (string, error) {
 		Body:       request.Body,
 		Method:     request.Method,
 		QueryParam: queryParam,
+		Context:    request.Context,
 	})
 
 	// Return the response body AND the error. A non-2xx status yields a
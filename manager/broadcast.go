@@ -0,0 +1,226 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/wapikit/wapi.go/internal/request_client"
+	"github.com/wapikit/wapi.go/pkg/components"
+)
+
+// GraphAPIError is the error of a send the Graph API answered with a non-2xx
+// status. Use errors.As on a BroadcastResult.Err (or any send error) to read
+// Meta's error code.
+type GraphAPIError = request_client.GraphAPIError
+
+// DefaultBroadcastMessagesPerSecond is Meta's default throughput for a
+// business phone number.
+const DefaultBroadcastMessagesPerSecond = 80
+
+// BroadcastOptions configures Broadcast.
+type BroadcastOptions struct {
+	// Concurrency is the number of sends in flight at once. Defaults to 10.
+	Concurrency int
+	// MessagesPerSecond paces the sends of this broadcast to the throughput of
+	// the phone number. Broadcasts running at the same time from one manager
+	// share the pace of its phone number, each send taking a slot of its own
+	// broadcast's rate. Defaults to DefaultBroadcastMessagesPerSecond; a
+	// negative value disables pacing.
+	MessagesPerSecond float64
+	// Progress is called after every recipient, from the sending goroutines
+	// one call at a time.
+	Progress func(progress BroadcastProgress)
+}
+
+// BroadcastResult is the outcome of the send to one recipient.
+type BroadcastResult struct {
+	Target    MessageTarget
+	MessageId string               // MessageId is the id Meta assigned to the message when the send succeeded.
+	Response  *MessageSendResponse // Response is the parsed API response, also for most failed sends.
+	// Err is nil when the send succeeded. It is a *GraphAPIError for sends
+	// the API refused, ErrServiceWindowClosed for sends stopped by the
+	// service window check, the context error for recipients skipped after
+	// cancellation, or the error of the message factory.
+	Err error
+}
+
+// BroadcastProgress reports the state of a running broadcast.
+type BroadcastProgress struct {
+	Total     int
+	Succeeded int
+	Failed    int
+	Last      BroadcastResult // Last is the result that triggered this report.
+}
+
+// BroadcastReport holds the result of every recipient, in the order of the
+// targets passed to Broadcast.
+type BroadcastReport struct {
+	Results   []BroadcastResult
+	Succeeded int
+	Failed    int
+}
+
+// Broadcast sends the same message to every target. See BroadcastFunc.
+func (mm *MessageManager) Broadcast(ctx context.Context, message components.BaseMessage, targets []MessageTarget, options BroadcastOptions) (*BroadcastReport, error) {
+	return mm.BroadcastFunc(ctx, func(MessageTarget) (components.BaseMessage, error) {
+		return message, nil
+	}, targets, options)
+}
+
+// BroadcastFunc sends the message built by factory to every target with
+// bounded concurrency, paced to options.MessagesPerSecond. A failed recipient
+// does not stop the broadcast. When ctx is canceled the sends in flight are
+// aborted, the remaining recipients are reported with the context error, and
+// the context error is returned along with the report.
+func (mm *MessageManager) BroadcastFunc(ctx context.Context, factory func(target MessageTarget) (components.BaseMessage, error), targets []MessageTarget, options BroadcastOptions) (*BroadcastReport, error) {
+	concurrency := options.Concurrency
+	if concurrency <= 0 {
+		concurrency = 10
+	}
+	rate := options.MessagesPerSecond
+	if rate == 0 {
+		rate = DefaultBroadcastMessagesPerSecond
+	}
+	var pacer *sendPacer
+	if rate > 0 {
+		pacer = mm.broadcastPacers.get(mm.PhoneNumberId)
+	}
+	interval := time.Duration(float64(time.Second) / rate)
+	scoped := mm.WithContext(ctx)
+
+	report := &BroadcastReport{Results: make([]BroadcastResult, len(targets))}
+	var mu sync.Mutex
+	record := func(index int, result BroadcastResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		report.Results[index] = result
+		if result.Err == nil {
+			report.Succeeded++
+		} else {
+			report.Failed++
+		}
+		if options.Progress != nil {
+			options.Progress(BroadcastProgress{
+				Total:     len(targets),
+				Succeeded: report.Succeeded,
+				Failed:    report.Failed,
+				Last:      result,
+			})
+		}
+	}
+
+	indexes := make(chan int)
+	var workers sync.WaitGroup
+	for i := 0; i < concurrency && i < len(targets); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for index := range indexes {
+				record(index, scoped.broadcastOne(ctx, pacer, interval, factory, targets[index]))
+			}
+		}()
+	}
+	for index := range targets {
+		indexes <- index
+	}
+	close(indexes)
+	workers.Wait()
+	return report, ctx.Err()
+}
+
+func (mm *MessageManager) broadcastOne(ctx context.Context, pacer *sendPacer, interval time.Duration, factory func(MessageTarget) (components.BaseMessage, error), target MessageTarget) BroadcastResult {
+	result := BroadcastResult{Target: target}
+	if err := pacer.wait(ctx, interval); err != nil {
+		result.Err = err
+		return result
+	}
+	message, err := factory(target)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Response, result.Err = mm.SendToTarget(message, target)
+	if result.Err == nil && (result.Response == nil || len(result.Response.Messages) == 0) {
+		result.Err = errors.New("send response carries no message id")
+	}
+	if result.Err == nil {
+		result.MessageId = result.Response.Messages[0].ID
+	}
+	return result
+}
+
+// sendPacer spaces the sends of the broadcasts of one phone number. Every
+// send takes a slot of the interval of its broadcast's rate. A nil pacer never
+// waits.
+type sendPacer struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+// sendPacers holds the pacer of every phone number a manager broadcasts from.
+// It is shared by the copies WithContext and WithTags make, so their
+// broadcasts share the pace too.
+type sendPacers struct {
+	mu     sync.Mutex
+	pacers map[string]*sendPacer
+}
+
+func newSendPacers() *sendPacers {
+	return &sendPacers{pacers: make(map[string]*sendPacer)}
+}
+
+// get returns the pacer of the phone number. A nil set, of a manager not made
+// by NewMessageManager, hands out an unshared pacer.
+func (pacers *sendPacers) get(phoneNumberId string) *sendPacer {
+	if pacers == nil {
+		return &sendPacer{}
+	}
+	pacers.mu.Lock()
+	defer pacers.mu.Unlock()
+	pacer, ok := pacers.pacers[phoneNumberId]
+	if !ok {
+		pacer = &sendPacer{}
+		pacers.pacers[phoneNumberId] = pacer
+	}
+	return pacer
+}
+
+// wait blocks until the next send slot or until ctx is done, and moves the
+// next slot interval later. A canceled wait hands its slot back when no later
+// send has taken one since.
+func (pacer *sendPacer) wait(ctx context.Context, interval time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if pacer == nil {
+		return nil
+	}
+	pacer.mu.Lock()
+	now := time.Now()
+	slot := pacer.next
+	if slot.Before(now) {
+		slot = now
+	}
+	pacer.next = slot.Add(interval)
+	pacer.mu.Unlock()
+
+	delay := time.Until(slot)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		pacer.mu.Lock()
+		if pacer.next.Equal(slot.Add(interval)) {
+			pacer.next = slot
+		}
+		pacer.mu.Unlock()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,170 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/wapikit/wapi.go/internal/request_client"
+	"github.com/wapikit/wapi.go/pkg/components"
+)
+
+// graphApiFunc answers a Graph API request with a status code and body.
+type graphApiFunc func(request *http.Request, body map[string]any) (int, string)
+
+func (answer graphApiFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	var body map[string]any
+	if request.Body != nil {
+		raw, _ := io.ReadAll(request.Body)
+		json.Unmarshal(raw, &body)
+	}
+	status, responseBody := answer(request, body)
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Body:       io.NopCloser(strings.NewReader(responseBody)),
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Request:    request,
+	}, nil
+}
+
+// fakeGraphApi routes every request to graph.facebook.com to answer for the
+// duration of the test. Other hosts keep the real transport.
+func fakeGraphApi(t *testing.T, answer graphApiFunc) {
+	t.Helper()
+	original := http.DefaultTransport
+	http.DefaultTransport = roundTripFunc(func(request *http.Request) (*http.Response, error) {
+		if request.URL.Host == request_client.BASE_URL {
+			return answer.RoundTrip(request)
+		}
+		return original.RoundTrip(request)
+	})
+	t.Cleanup(func() { http.DefaultTransport = original })
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+// sentTo answers a send with a message id derived from the recipient.
+func sentTo(body map[string]any) (int, string) {
+	return http.StatusOK, fmt.Sprintf(`{"messaging_product":"whatsapp","messages":[{"id":"wamid.%v"}]}`, body["to"])
+}
+
+func newTestMessageManager() *MessageManager {
+	return NewMessageManager(*request_client.NewRequestClient("token"), "pn-1")
+}
+
+func TestBroadcastReportsEveryRecipient(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			seen := maxInFlight.Load()
+			if current <= seen || maxInFlight.CompareAndSwap(seen, current) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		if body["to"] == "3" {
+			return http.StatusBadRequest, `{"error":{"message":"Recipient not on WhatsApp","code":131026}}`
+		}
+		return sentTo(body)
+	})
+
+	targets := []MessageTarget{NewPhoneTarget("1"), NewPhoneTarget("2"), NewPhoneTarget("3"), NewPhoneTarget("4")}
+	text, _ := components.NewTextMessage(components.TextMessageConfigs{Text: "hi"})
+	var progress []BroadcastProgress
+	var mu sync.Mutex
+	report, err := newTestMessageManager().Broadcast(context.Background(), text, targets, BroadcastOptions{
+		Concurrency:       2,
+		MessagesPerSecond: -1,
+		Progress: func(p BroadcastProgress) {
+			mu.Lock()
+			progress = append(progress, p)
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Succeeded != 3 || report.Failed != 1 || len(progress) != 4 || progress[3].Succeeded+progress[3].Failed != 4 {
+		t.Fatalf("report=%+v progress=%d", report, len(progress))
+	}
+	if report.Results[0].MessageId != "wamid.1" || report.Results[3].MessageId != "wamid.4" {
+		t.Fatalf("results out of order: %+v", report.Results)
+	}
+	var apiErr *GraphAPIError
+	if !errors.As(report.Results[2].Err, &apiErr) || apiErr.Code != 131026 {
+		t.Fatalf("failed recipient error=%v", report.Results[2].Err)
+	}
+	if maxInFlight.Load() > 2 {
+		t.Fatalf("%d sends in flight, concurrency is 2", maxInFlight.Load())
+	}
+}
+
+func TestBroadcastPacesAndStopsOnCancel(t *testing.T) {
+	var sends atomic.Int32
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		sends.Add(1)
+		return sentTo(body)
+	})
+
+	targets := make([]MessageTarget, 20)
+	for i := range targets {
+		targets[i] = NewPhoneTarget(fmt.Sprint(i))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Millisecond)
+	defer cancel()
+	text, _ := components.NewTextMessage(components.TextMessageConfigs{Text: "hi"})
+	report, err := newTestMessageManager().Broadcast(ctx, text, targets, BroadcastOptions{MessagesPerSecond: 20})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err=%v, want the context error", err)
+	}
+	if sent := sends.Load(); sent < 2 || sent > 4 {
+		t.Fatalf("%d sends in 120ms at 20 per second", sent)
+	}
+	if !errors.Is(report.Results[19].Err, context.DeadlineExceeded) || report.Failed+report.Succeeded != 20 {
+		t.Fatalf("report=%+v", report)
+	}
+}
+
+func TestConcurrentBroadcastsShareThePace(t *testing.T) {
+	var sends atomic.Int32
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		sends.Add(1)
+		return sentTo(body)
+	})
+
+	targets := make([]MessageTarget, 10)
+	for i := range targets {
+		targets[i] = NewPhoneTarget(fmt.Sprint(i))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Millisecond)
+	defer cancel()
+	text, _ := components.NewTextMessage(components.TextMessageConfigs{Text: "hi"})
+	mm := newTestMessageManager()
+	var wg sync.WaitGroup
+	for _, sender := range []*MessageManager{mm, mm.WithContext(ctx)} {
+		wg.Add(1)
+		go func(sender *MessageManager) {
+			defer wg.Done()
+			sender.Broadcast(ctx, text, targets, BroadcastOptions{MessagesPerSecond: 20})
+		}(sender)
+	}
+	wg.Wait()
+	if sent := sends.Load(); sent < 2 || sent > 4 {
+		t.Fatalf("%d sends in 120ms from two broadcasts at 20 per second", sent)
+	}
+}
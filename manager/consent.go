@@ -0,0 +1,434 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/components"
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+// ErrNoConsent is wrapped by the ConsentError a marketing send to a recipient
+// without marketing consent fails with.
+var ErrNoConsent = errors.New("recipient has not consented to marketing messages")
+
+// ConsentStatus is whether a user accepts marketing messages.
+type ConsentStatus string
+
+const (
+	ConsentStatusGranted ConsentStatus = "granted"
+	ConsentStatusRevoked ConsentStatus = "revoked"
+)
+
+// ConsentSource is what a consent change came from.
+type ConsentSource string
+
+const (
+	ConsentSourceWebhook ConsentSource = "webhook" // a user_preferences webhook
+	ConsentSourceKeyword ConsentSource = "keyword" // an opt-in or opt-out keyword sent by the user
+	ConsentSourceApi     ConsentSource = "api"     // a call to Grant or Revoke
+)
+
+// ConsentRecord is one change of a user's marketing consent.
+type ConsentRecord struct {
+	User      string        `json:"user"`
+	Status    ConsentStatus `json:"status"`
+	Source    ConsentSource `json:"source"`
+	Reason    string        `json:"reason,omitempty"` // Reason says why, e.g. the keyword or the webhook detail.
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// ConsentStore keeps every consent change. Implementations must be safe for
+// concurrent use.
+type ConsentStore interface {
+	// Put records a change.
+	Put(record ConsentRecord) error
+	// Get returns the current record of the user, the one with the latest
+	// UpdatedAt, and whether there is one. Of records updated at the same
+	// time the one put last is current, so a late or repeated webhook does
+	// not override a newer change.
+	Get(user string) (ConsentRecord, bool, error)
+	// History returns all changes of the user, oldest first.
+	History(user string) ([]ConsentRecord, error)
+}
+
+// MemoryConsentStore keeps consent records in memory. It is the default store
+// of a ConsentRegistry.
+type MemoryConsentStore struct {
+	mu      sync.Mutex
+	records map[string][]ConsentRecord
+}
+
+// NewMemoryConsentStore creates an empty in-memory store.
+func NewMemoryConsentStore() *MemoryConsentStore {
+	return &MemoryConsentStore{records: make(map[string][]ConsentRecord)}
+}
+
+func (store *MemoryConsentStore) Put(record ConsentRecord) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.records[record.User] = append(store.records[record.User], record)
+	return nil
+}
+
+func (store *MemoryConsentStore) Get(user string) (ConsentRecord, bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return latestConsent(store.records[user])
+}
+
+// latestConsent returns the record with the latest UpdatedAt, the last one of
+// those updated at the same time.
+func latestConsent(records []ConsentRecord) (ConsentRecord, bool, error) {
+	if len(records) == 0 {
+		return ConsentRecord{}, false, nil
+	}
+	latest := records[0]
+	for _, record := range records[1:] {
+		if !record.UpdatedAt.Before(latest.UpdatedAt) {
+			latest = record
+		}
+	}
+	return latest, true, nil
+}
+
+func (store *MemoryConsentStore) History(user string) ([]ConsentRecord, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return append([]ConsentRecord(nil), store.records[user]...), nil
+}
+
+// FileConsentStore keeps consent records in a JSON lines file so they survive
+// restarts. The file is append-only and doubles as the audit log of every
+// change.
+type FileConsentStore struct {
+	path   string
+	memory *MemoryConsentStore
+}
+
+// NewFileConsentStore opens (or creates) the store at path and loads the
+// records already in it.
+func NewFileConsentStore(path string) (*FileConsentStore, error) {
+	store := &FileConsentStore{path: path, memory: NewMemoryConsentStore()}
+	err := readJsonLines(path, func(record ConsentRecord) {
+		store.memory.records[record.User] = append(store.memory.records[record.User], record)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *FileConsentStore) Put(record ConsentRecord) error {
+	store.memory.mu.Lock()
+	defer store.memory.mu.Unlock()
+	if err := appendJsonLine(store.path, record); err != nil {
+		return err
+	}
+	store.memory.records[record.User] = append(store.memory.records[record.User], record)
+	return nil
+}
+
+func (store *FileConsentStore) Get(user string) (ConsentRecord, bool, error) {
+	return store.memory.Get(user)
+}
+
+func (store *FileConsentStore) History(user string) ([]ConsentRecord, error) {
+	return store.memory.History(user)
+}
+
+// ConsentConfig configures a ConsentRegistry.
+type ConsentConfig struct {
+	// OptOutKeywords revoke consent when a user sends one of them as the whole
+	// text of a message, ignoring case and surrounding space. Defaults to
+	// DefaultOptOutKeywords.
+	OptOutKeywords []string
+	// OptInKeywords grant consent the same way. Defaults to
+	// DefaultOptInKeywords.
+	OptInKeywords []string
+	// RequireOptIn refuses marketing sends to users without any record. By
+	// default only users who revoked consent are refused.
+	RequireOptIn bool
+}
+
+var (
+	DefaultOptOutKeywords = []string{"STOP", "UNSUBSCRIBE", "OPT OUT", "OPTOUT"}
+	DefaultOptInKeywords  = []string{"START", "SUBSCRIBE", "OPT IN", "OPTIN", "UNSTOP"}
+)
+
+// ConsentError is the error of a marketing send refused by a ConsentRegistry.
+// It wraps ErrNoConsent.
+type ConsentError struct {
+	Recipient string
+	Reason    string
+	// Record is the consent change the refusal is based on, nil when the user
+	// has none.
+	Record *ConsentRecord
+}
+
+func (e *ConsentError) Error() string {
+	return fmt.Sprintf("marketing message to %s refused: %s", e.Recipient, e.Reason)
+}
+
+func (e *ConsentError) Unwrap() error {
+	return ErrNoConsent
+}
+
+// ConsentRegistry tracks the marketing consent of users, fed by the
+// user_preferences webhooks and opt-in and opt-out keywords seen on the event
+// manager it is attached to and by Grant and Revoke. A MessageManager it is
+// set on with SetConsent refuses marketing sends to users without consent.
+//
+// A consent change is recorded under every id of the user: the phone number
+// and BSUIDs it carries, and the ids seen together with them on the inbound
+// messages of the event manager since the registry was attached.
+type ConsentRegistry struct {
+	store  ConsentStore
+	config ConsentConfig
+	optOut map[string]bool
+	optIn  map[string]bool
+
+	mu    sync.Mutex
+	links map[string][]string // links maps an id of a user to the other ids seen with it.
+}
+
+// NewConsentRegistry creates a registry keeping its records in store, or in
+// memory when store is nil.
+func NewConsentRegistry(store ConsentStore, config ConsentConfig) *ConsentRegistry {
+	if store == nil {
+		store = NewMemoryConsentStore()
+	}
+	if config.OptOutKeywords == nil {
+		config.OptOutKeywords = DefaultOptOutKeywords
+	}
+	if config.OptInKeywords == nil {
+		config.OptInKeywords = DefaultOptInKeywords
+	}
+	registry := &ConsentRegistry{store: store, config: config, optOut: map[string]bool{}, optIn: map[string]bool{}, links: map[string][]string{}}
+	for _, keyword := range config.OptOutKeywords {
+		registry.optOut[normalizeKeyword(keyword)] = true
+	}
+	for _, keyword := range config.OptInKeywords {
+		registry.optIn[normalizeKeyword(keyword)] = true
+	}
+	return registry
+}
+
+// Attach subscribes the registry to the user preference and inbound message
+// events of the event manager. Store errors are retried and dead-lettered
+// under the handler name "consent-registry". It returns the subscription id to
+// pass to Off.
+func (registry *ConsentRegistry) Attach(eventManager *EventManager) SubscriptionId {
+	preferences, messages := TypeFilter(events.UserPreferencesEventType), CategoryFilter(events.EventCategoryMessage)
+	filter := func(eventType events.EventType, data events.BaseEvent) bool {
+		return preferences(eventType, data) || messages(eventType, data)
+	}
+	return eventManager.HandleMatch(filter, registry.Observe, HandlerOptions{
+		Name:  "consent-registry",
+		Retry: RetryPolicy{MaxAttempts: 3},
+	})
+}
+
+// Observe applies the marketing preferences of a user_preferences event, and
+// the opt-in or opt-out keyword of a text message. Inbound messages link the
+// ids of their sender. Other events are ignored.
+func (registry *ConsentRegistry) Observe(event events.BaseEvent) error {
+	if e, ok := event.(*events.UserPreferencesEvent); ok {
+		var errs []error
+		for _, preference := range e.UserPreferences {
+			if preference.Category != "marketing_messages" {
+				continue
+			}
+			var status ConsentStatus
+			switch strings.ToLower(preference.Value) {
+			case "stop":
+				status = ConsentStatusRevoked
+			case "resume":
+				status = ConsentStatusGranted
+			default:
+				continue
+			}
+			at, err := preference.Time()
+			if err != nil {
+				at = time.Now()
+			}
+			reason := preference.Detail
+			if reason == "" {
+				reason = "marketing preference " + preference.Value
+			}
+			errs = append(errs, registry.put(registry.link(preference.WaId, preference.UserId, preference.ParentUserId), ConsentRecord{
+				Status:    status,
+				Source:    ConsentSourceWebhook,
+				Reason:    reason,
+				UpdatedAt: at,
+			}))
+		}
+		return errors.Join(errs...)
+	}
+
+	message, ok := events.MessageEventOf(event)
+	if !ok {
+		return nil
+	}
+	users := registry.link(message.From, message.WaId, message.FromUserId, message.UserId, message.FromParentUserId, message.ParentUserId)
+	text, ok := event.(*events.TextMessageEvent)
+	if !ok {
+		return nil
+	}
+	keyword := normalizeKeyword(text.Text)
+	var status ConsentStatus
+	switch {
+	case registry.optOut[keyword]:
+		status = ConsentStatusRevoked
+	case registry.optIn[keyword]:
+		status = ConsentStatusGranted
+	default:
+		return nil
+	}
+	return registry.put(users, ConsentRecord{
+		Status:    status,
+		Source:    ConsentSourceKeyword,
+		Reason:    fmt.Sprintf("user sent %q", keyword),
+		UpdatedAt: time.Now(),
+	})
+}
+
+// link records that the non-empty ids belong to one user and returns them
+// together with every id linked to them before.
+func (registry *ConsentRegistry) link(ids ...string) []string {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	seen := map[string]bool{}
+	var users []string
+	var add func(id string)
+	add = func(id string) {
+		if id == "" || seen[id] {
+			return
+		}
+		seen[id] = true
+		users = append(users, id)
+		for _, linked := range registry.links[id] {
+			add(linked)
+		}
+	}
+	for _, id := range ids {
+		add(id)
+	}
+	for _, id := range users {
+		registry.links[id] = users
+	}
+	return users
+}
+
+// linked returns the ids linked to the user, the user included.
+func (registry *ConsentRegistry) linked(user string) []string {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if users, ok := registry.links[user]; ok {
+		return users
+	}
+	return []string{user}
+}
+
+// current returns the latest record under any id of the user, so a change
+// recorded before an id was linked applies to it as well.
+func (registry *ConsentRegistry) current(user string) (ConsentRecord, bool, error) {
+	var records []ConsentRecord
+	for _, id := range registry.linked(user) {
+		record, found, err := registry.store.Get(id)
+		if err != nil {
+			return ConsentRecord{}, false, err
+		}
+		if found {
+			records = append(records, record)
+		}
+	}
+	return latestConsent(records)
+}
+
+// put stores the record under every id of the user.
+func (registry *ConsentRegistry) put(users []string, record ConsentRecord) error {
+	var errs []error
+	for _, user := range users {
+		record.User = user
+		errs = append(errs, registry.store.Put(record))
+	}
+	return errors.Join(errs...)
+}
+
+// normalizeKeyword upper-cases the text and collapses its spaces.
+func normalizeKeyword(text string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(text), " "))
+}
+
+// Grant records that the user consented to marketing messages, e.g. through a
+// sign-up form.
+func (registry *ConsentRegistry) Grant(user, reason string) error {
+	return registry.put(registry.link(user), ConsentRecord{Status: ConsentStatusGranted, Source: ConsentSourceApi, Reason: reason, UpdatedAt: time.Now()})
+}
+
+// Revoke records that the user withdrew consent to marketing messages.
+func (registry *ConsentRegistry) Revoke(user, reason string) error {
+	return registry.put(registry.link(user), ConsentRecord{Status: ConsentStatusRevoked, Source: ConsentSourceApi, Reason: reason, UpdatedAt: time.Now()})
+}
+
+// Status returns the current record of the user, the latest under any of its
+// ids, and whether there is one.
+func (registry *ConsentRegistry) Status(user string) (ConsentRecord, bool, error) {
+	return registry.current(user)
+}
+
+// History returns all consent changes of the user, oldest first.
+func (registry *ConsentRegistry) History(user string) ([]ConsentRecord, error) {
+	return registry.store.History(user)
+}
+
+// Check returns nil when a marketing message may be sent to the user, and a
+// *ConsentError saying why not otherwise.
+func (registry *ConsentRegistry) Check(user string) error {
+	record, found, err := registry.current(user)
+	if err != nil {
+		return fmt.Errorf("error reading consent: %w", err)
+	}
+	switch {
+	case !found && registry.config.RequireOptIn:
+		return &ConsentError{Recipient: user, Reason: "no opt-in recorded"}
+	case found && record.Status == ConsentStatusRevoked:
+		return &ConsentError{
+			Recipient: user,
+			Reason:    fmt.Sprintf("consent revoked via %s at %s: %s", record.Source, record.UpdatedAt.Format(time.RFC3339), record.Reason),
+			Record:    &record,
+		}
+	}
+	return nil
+}
+
+// SetConsent makes the manager check the registry before marketing sends:
+// SendMarketingMessage, SendMarketingMessageToTarget, and sends, enqueues and
+// schedules of templates whose Category is
+// components.TemplateCategoryMarketing. A refused send fails with a
+// *ConsentError without calling the API. Queued and scheduled sends are
+// checked again before every attempt.
+func (mm *MessageManager) SetConsent(registry *ConsentRegistry) {
+	mm.consent = registry
+}
+
+// checkConsent refuses a marketing send to a recipient without consent.
+func (mm *MessageManager) checkConsent(message components.BaseMessage, configs components.ApiCompatibleJsonConverterConfigs, endpointSuffix string) error {
+	if mm.consent == nil || (endpointSuffix != "marketing_messages" && !components.IsMarketing(message)) {
+		return nil
+	}
+	return mm.consent.Check(recipientOf(configs))
+}
+
+// recheckConsent refuses a queued marketing send to a recipient who has no
+// consent by now.
+func (mm *MessageManager) recheckConsent(recipient string, marketing bool) error {
+	if mm.consent == nil || !marketing {
+		return nil
+	}
+	return mm.consent.Check(recipient)
+}
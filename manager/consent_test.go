@@ -0,0 +1,207 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/components"
+)
+
+func TestConsentRegistryRefusesMarketingSends(t *testing.T) {
+	var mu sync.Mutex
+	var endpoints []string
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		endpoints = append(endpoints, request.URL.Path[strings.LastIndex(request.URL.Path, "/")+1:])
+		return sentTo(body)
+	})
+	store, err := NewFileConsentStore(filepath.Join(t.TempDir(), "consent.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	em := NewEventManager()
+	registry := NewConsentRegistry(store, ConsentConfig{})
+	registry.Attach(em)
+	wh := newTestWebhook(em)
+	mm := newTestMessageManager()
+	mm.SetConsent(registry)
+
+	postWebhook(t, wh, `{"object":"whatsapp_business_account","entry":[{"id":"waba-1","changes":[{"field":"user_preferences","value":{
+		"messaging_product":"whatsapp","metadata":{"display_phone_number":"1","phone_number_id":"pn-1"},
+		"user_preferences":[{"wa_id":"913","detail":"User requested to stop marketing messages","category":"marketing_messages","value":"stop","timestamp":1700000000}]}}]}]}`)
+	postWebhook(t, wh, messagesPayload(`{"from":"911","id":"wamid.in","timestamp":"1","type":"text","text":{"body":"  stop "}}`))
+	waitFor(t, func() bool {
+		return registry.Check("911") != nil && registry.Check("913") != nil
+	})
+
+	promo, _ := components.NewTemplateMessage(&components.TemplateMessageConfigs{Name: "promo", Language: "en", Category: "marketing"})
+	_, err = mm.Send(promo, "913")
+	var refused *ConsentError
+	if !errors.As(err, &refused) || !errors.Is(err, ErrNoConsent) || refused.Record.Source != ConsentSourceWebhook || !strings.Contains(refused.Reason, "stop marketing") {
+		t.Fatalf("webhook opt-out: %v", err)
+	}
+	if _, err := mm.SendMarketingMessage(promo, "911"); !errors.Is(err, ErrNoConsent) {
+		t.Fatalf("keyword opt-out: %v", err)
+	}
+	utility, _ := components.NewTemplateMessage(&components.TemplateMessageConfigs{Name: "receipt", Language: "en", Category: "UTILITY"})
+	if _, err := mm.Send(utility, "913"); err != nil {
+		t.Fatalf("utility template: %v", err)
+	}
+
+	postWebhook(t, wh, messagesPayload(`{"from":"911","id":"wamid.in2","timestamp":"2","type":"text","text":{"body":"START"}}`))
+	waitFor(t, func() bool { return registry.Check("911") == nil })
+	if _, err := mm.SendMarketingMessage(promo, "911"); err != nil {
+		t.Fatalf("after opt-in: %v", err)
+	}
+	if strings.Join(endpoints, " ") != "messages marketing_messages" {
+		t.Fatalf("sent to %v", endpoints)
+	}
+
+	reopened, err := NewFileConsentStore(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, _ := NewConsentRegistry(reopened, ConsentConfig{}).History("911")
+	if len(history) != 2 || history[0].Status != ConsentStatusRevoked || history[1].Status != ConsentStatusGranted || history[1].Source != ConsentSourceKeyword {
+		t.Fatalf("history %+v", history)
+	}
+}
+
+func TestConsentRequireOptInAppliesToQueuedSends(t *testing.T) {
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		return sentTo(body)
+	})
+	registry := NewConsentRegistry(nil, ConsentConfig{RequireOptIn: true})
+	mm := newTestMessageManager()
+	mm.SetConsent(registry)
+	scheduler, err := NewScheduler(mm, NewMemoryScheduleStore(), SchedulerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	promo, _ := components.NewTemplateMessage(&components.TemplateMessageConfigs{Name: "promo", Language: "en", Category: components.TemplateCategoryMarketing})
+
+	if _, err := scheduler.SendAfter(time.Hour, promo, NewPhoneTarget("911"), ScheduleOptions{}); !errors.Is(err, ErrNoConsent) {
+		t.Fatalf("schedule without opt-in: %v", err)
+	}
+	registry.Grant("911", "signed up on the website")
+	if _, err := scheduler.SendAt(time.Now(), promo, NewPhoneTarget("911"), ScheduleOptions{Key: "promo"}); err != nil {
+		t.Fatal(err)
+	}
+	registry.Revoke("911", "asked support to stop")
+	if err := scheduler.sendDue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	job, _ := scheduler.Get("promo")
+	if job.State != ScheduledJobStateSkipped || !strings.Contains(job.SkipReason, "asked support to stop") {
+		t.Fatalf("job %+v", job)
+	}
+}
+
+func TestConsentRegistryRecordsWebhookOptOutUnderEveryId(t *testing.T) {
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		return sentTo(body)
+	})
+	em := NewEventManager()
+	registry := NewConsentRegistry(nil, ConsentConfig{})
+	registry.Attach(em)
+	wh := newTestWebhook(em)
+
+	postWebhook(t, wh, messagesPayload(`{"from":"911","from_user_id":"IN.1","id":"wamid.in","timestamp":"1","type":"text","text":{"body":"cancel"}}`))
+	waitFor(t, func() bool {
+		registry.mu.Lock()
+		defer registry.mu.Unlock()
+		return len(registry.links["IN.1"]) == 2
+	})
+	if err := registry.Check("911"); err != nil {
+		t.Fatalf("CANCEL opted out: %v", err)
+	}
+
+	postWebhook(t, wh, `{"object":"whatsapp_business_account","entry":[{"id":"waba-1","changes":[{"field":"user_preferences","value":{
+		"messaging_product":"whatsapp","metadata":{"display_phone_number":"1","phone_number_id":"pn-1"},
+		"user_preferences":[{"user_id":"IN.1","detail":"User requested to stop marketing messages","category":"marketing_messages","value":"stop","timestamp":1700000000}]}}]}]}`)
+	waitFor(t, func() bool { return registry.Check("911") != nil })
+	if err := registry.Check("IN.1"); !errors.Is(err, ErrNoConsent) {
+		t.Fatalf("BSUID: %v", err)
+	}
+}
+
+func TestOutboxChecksConsentBeforeEveryAttempt(t *testing.T) {
+	var mu sync.Mutex
+	var sends int
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		sends++
+		return sentTo(body)
+	})
+	registry := NewConsentRegistry(nil, ConsentConfig{})
+	mm := newTestMessageManager()
+	mm.SetConsent(registry)
+	outbox := newTestOutbox(t, NewMemoryOutboxStore())
+	mm.SetOutbox(outbox)
+
+	promo, _ := components.NewTemplateMessage(&components.TemplateMessageConfigs{Name: "promo", Language: "en", Category: components.TemplateCategoryMarketing})
+	if _, err := mm.Enqueue("promo", promo, NewPhoneTarget("911")); err != nil {
+		t.Fatal(err)
+	}
+	registry.Revoke("911", "asked support to stop")
+	runOutbox(t, outbox)
+	waitFor(t, func() bool {
+		entry, _ := outbox.Get("promo")
+		return entry.State == OutboxEntryStateFailed
+	})
+	entry, _ := outbox.Get("promo")
+	mu.Lock()
+	defer mu.Unlock()
+	if sends != 0 || entry.Attempts != 1 || !strings.Contains(entry.LastError, "asked support to stop") {
+		t.Fatalf("%d sends, entry %+v", sends, entry)
+	}
+}
+
+func TestConsentRegistryAppliesEarlierChangesToLinkedIds(t *testing.T) {
+	em := NewEventManager()
+	registry := NewConsentRegistry(nil, ConsentConfig{})
+	registry.Attach(em)
+	if err := registry.Revoke("911", "asked support to stop"); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Check("IN.1"); err != nil {
+		t.Fatalf("before the link: %v", err)
+	}
+
+	postWebhook(t, newTestWebhook(em), messagesPayload(`{"from":"911","from_user_id":"IN.1","id":"wamid.in","timestamp":"1","type":"text","text":{"body":"hi"}}`))
+	waitFor(t, func() bool { return registry.Check("IN.1") != nil })
+	mm := newTestMessageManager()
+	mm.SetConsent(registry)
+	promo, _ := components.NewTemplateMessage(&components.TemplateMessageConfigs{Name: "promo", Language: "en", Category: components.TemplateCategoryMarketing})
+	if _, err := mm.SendToTarget(promo, NewBSUIDTarget("IN.1")); !errors.Is(err, ErrNoConsent) || !strings.Contains(err.Error(), "asked support to stop") {
+		t.Fatalf("send to the linked BSUID: %v", err)
+	}
+}
+
+func TestConsentRegistryIgnoresLateWebhooks(t *testing.T) {
+	em := NewEventManager()
+	registry := NewConsentRegistry(nil, ConsentConfig{})
+	registry.Attach(em)
+	wh := newTestWebhook(em)
+
+	postWebhook(t, wh, messagesPayload(`{"from":"911","id":"wamid.in","timestamp":"1","type":"text","text":{"body":"STOP"}}`))
+	waitFor(t, func() bool { return registry.Check("911") != nil })
+	postWebhook(t, wh, `{"object":"whatsapp_business_account","entry":[{"id":"waba-1","changes":[{"field":"user_preferences","value":{
+		"messaging_product":"whatsapp","metadata":{"display_phone_number":"1","phone_number_id":"pn-1"},
+		"user_preferences":[{"wa_id":"911","detail":"User requested to resume marketing messages","category":"marketing_messages","value":"resume","timestamp":1700000000}]}}]}]}`)
+	waitFor(t, func() bool {
+		history, _ := registry.History("911")
+		return len(history) == 2
+	})
+	if err := registry.Check("911"); !errors.Is(err, ErrNoConsent) {
+		t.Fatalf("a late webhook overrode the opt-out: %v", err)
+	}
+}
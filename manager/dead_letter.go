@@ -0,0 +1,209 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+// ErrDeadLetterNotFound is returned by DeadLetterStore.Get and Delete for an
+// unknown id.
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter is an event a handler kept failing on after all its retries.
+type DeadLetter struct {
+	Id           string           // Id identifies the letter in its store.
+	Subscription string           // Subscription is the HandlerOptions.Name of the handler that failed.
+	EventType    events.EventType // EventType is the type the event was published under.
+	Event        events.BaseEvent // Event is the event the handler failed on.
+	Attempts     int              // Attempts counts every call of the handler, redrives included.
+	Error        string           // Error is the last error returned by the handler.
+	FailedAt     time.Time        // FailedAt is the time of the last failure.
+}
+
+type deadLetterJson struct {
+	Id           string           `json:"id"`
+	Subscription string           `json:"subscription"`
+	EventType    events.EventType `json:"event_type"`
+	Event        json.RawMessage  `json:"event"`
+	Attempts     int              `json:"attempts"`
+	Error        string           `json:"error"`
+	FailedAt     time.Time        `json:"failed_at"`
+}
+
+// MarshalJSON stores the event in its events.Marshal envelope so it can be
+// decoded back into the same struct.
+func (letter DeadLetter) MarshalJSON() ([]byte, error) {
+	event, err := events.Marshal(letter.Event)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(deadLetterJson{
+		Id:           letter.Id,
+		Subscription: letter.Subscription,
+		EventType:    letter.EventType,
+		Event:        event,
+		Attempts:     letter.Attempts,
+		Error:        letter.Error,
+		FailedAt:     letter.FailedAt,
+	})
+}
+
+// UnmarshalJSON decodes a letter written by MarshalJSON. Message events come
+// back without a requester; Redrive rebinds them.
+func (letter *DeadLetter) UnmarshalJSON(data []byte) error {
+	var raw deadLetterJson
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	event, err := events.Unmarshal(raw.Event)
+	if err != nil {
+		return err
+	}
+	*letter = DeadLetter{
+		Id:           raw.Id,
+		Subscription: raw.Subscription,
+		EventType:    raw.EventType,
+		Event:        event,
+		Attempts:     raw.Attempts,
+		Error:        raw.Error,
+		FailedAt:     raw.FailedAt,
+	}
+	return nil
+}
+
+// DeadLetterStore keeps the events handlers gave up on, for inspection and
+// redrive. Implementations must be safe for concurrent use.
+type DeadLetterStore interface {
+	// Put adds the letter, replacing any letter with the same id.
+	Put(letter DeadLetter) error
+	// Get returns the letter with the given id or ErrDeadLetterNotFound.
+	Get(id string) (DeadLetter, error)
+	// List returns every letter, oldest first.
+	List() ([]DeadLetter, error)
+	// Delete removes the letter with the given id or returns ErrDeadLetterNotFound.
+	Delete(id string) error
+}
+
+// MemoryDeadLetterStore keeps dead letters in memory. It is the default store
+// of an EventManager.
+type MemoryDeadLetterStore struct {
+	mu      sync.Mutex
+	order   []string
+	letters map[string]DeadLetter
+}
+
+// NewMemoryDeadLetterStore creates an empty in-memory store.
+func NewMemoryDeadLetterStore() *MemoryDeadLetterStore {
+	return &MemoryDeadLetterStore{letters: make(map[string]DeadLetter)}
+}
+
+func (store *MemoryDeadLetterStore) Put(letter DeadLetter) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.put(letter)
+	return nil
+}
+
+func (store *MemoryDeadLetterStore) put(letter DeadLetter) {
+	if _, ok := store.letters[letter.Id]; !ok {
+		store.order = append(store.order, letter.Id)
+	}
+	store.letters[letter.Id] = letter
+}
+
+func (store *MemoryDeadLetterStore) Get(id string) (DeadLetter, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	letter, ok := store.letters[id]
+	if !ok {
+		return DeadLetter{}, ErrDeadLetterNotFound
+	}
+	return letter, nil
+}
+
+func (store *MemoryDeadLetterStore) List() ([]DeadLetter, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	letters := make([]DeadLetter, 0, len(store.order))
+	for _, id := range store.order {
+		letters = append(letters, store.letters[id])
+	}
+	return letters, nil
+}
+
+func (store *MemoryDeadLetterStore) Delete(id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.delete(id)
+}
+
+func (store *MemoryDeadLetterStore) delete(id string) error {
+	if _, ok := store.letters[id]; !ok {
+		return ErrDeadLetterNotFound
+	}
+	delete(store.letters, id)
+	for i, existing := range store.order {
+		if existing == id {
+			store.order = append(store.order[:i], store.order[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// FileDeadLetterStore keeps dead letters in a JSON lines file so they survive
+// restarts. Put appends a line; Delete rewrites the file without the letter.
+type FileDeadLetterStore struct {
+	path   string
+	memory *MemoryDeadLetterStore
+}
+
+// NewFileDeadLetterStore opens (or creates) the store at path and loads the
+// letters already in it. A later line replaces an earlier one with the same id.
+func NewFileDeadLetterStore(path string) (*FileDeadLetterStore, error) {
+	store := &FileDeadLetterStore{path: path, memory: NewMemoryDeadLetterStore()}
+	if err := readJsonLines(path, store.memory.put); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *FileDeadLetterStore) Put(letter DeadLetter) error {
+	store.memory.mu.Lock()
+	defer store.memory.mu.Unlock()
+	if err := appendJsonLine(store.path, letter); err != nil {
+		return err
+	}
+	store.memory.put(letter)
+	return nil
+}
+
+func (store *FileDeadLetterStore) Get(id string) (DeadLetter, error) {
+	return store.memory.Get(id)
+}
+
+func (store *FileDeadLetterStore) List() ([]DeadLetter, error) {
+	return store.memory.List()
+}
+
+func (store *FileDeadLetterStore) Delete(id string) error {
+	store.memory.mu.Lock()
+	defer store.memory.mu.Unlock()
+	if _, ok := store.memory.letters[id]; !ok {
+		return ErrDeadLetterNotFound
+	}
+	kept := make([]DeadLetter, 0, len(store.memory.order)-1)
+	for _, existing := range store.memory.order {
+		if existing != id {
+			kept = append(kept, store.memory.letters[existing])
+		}
+	}
+	if err := rewriteJsonLines(store.path, kept); err != nil {
+		return err
+	}
+	return store.memory.delete(id)
+}
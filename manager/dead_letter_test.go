@@ -0,0 +1,144 @@
+package manager
+
+import (
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHandleRetriesThenDeadLetters(t *testing.T) {
+	em := NewEventManager()
+	var calls atomic.Int32
+	em.Handle(events.TextMessageEventType, func(events.BaseEvent) error {
+		calls.Add(1)
+		return errors.New("database unavailable")
+	}, HandlerOptions{Name: "save", Retry: RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}})
+
+	em.Publish(events.TextMessageEventType, textEvent("1"))
+	waitFor(t, func() bool { return em.Stats().DeadLettered == 1 })
+
+	letters, _ := em.DeadLetters().List()
+	if len(letters) != 1 || calls.Load() != 3 {
+		t.Fatalf("letters=%d calls=%d", len(letters), calls.Load())
+	}
+	letter := letters[0]
+	if letter.Subscription != "save" || letter.Attempts != 3 || letter.Error != "database unavailable" || letter.EventType != events.TextMessageEventType {
+		t.Fatalf("unexpected letter %+v", letter)
+	}
+	if stats := em.Stats(); stats.HandlerRetries != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestShardedHandleRetriesKeepPublishOrder(t *testing.T) {
+	em := NewEventManagerWithConfig(EventManagerConfig{DispatchMode: DispatchModeSharded, Workers: 1})
+	var failed atomic.Bool
+	handled := make(chan string, 2)
+	em.Handle(events.TextMessageEventType, func(e events.BaseEvent) error {
+		id := e.(*events.TextMessageEvent).MessageId
+		if id == "1" && failed.CompareAndSwap(false, true) {
+			return errors.New("database unavailable")
+		}
+		handled <- id
+		return nil
+	}, HandlerOptions{Retry: RetryPolicy{MaxAttempts: 2, InitialBackoff: 20 * time.Millisecond}})
+
+	em.Publish(events.TextMessageEventType, textEvent("1"))
+	em.Publish(events.TextMessageEventType, textEvent("2"))
+	if first, second := <-handled, <-handled; first != "1" || second != "2" {
+		t.Fatalf("handled %s before %s", first, second)
+	}
+}
+
+func TestOffDeadLettersPendingRetries(t *testing.T) {
+	em := NewEventManager()
+	var calls atomic.Int32
+	id := em.Handle(events.TextMessageEventType, func(events.BaseEvent) error {
+		calls.Add(1)
+		return errors.New("database unavailable")
+	}, HandlerOptions{Name: "save", Retry: RetryPolicy{MaxAttempts: 3, InitialBackoff: 50 * time.Millisecond}})
+
+	em.Publish(events.TextMessageEventType, textEvent("1"))
+	waitFor(t, func() bool { return calls.Load() == 1 })
+	em.Off(id)
+	waitFor(t, func() bool { return em.Stats().DeadLettered == 1 })
+	time.Sleep(100 * time.Millisecond)
+	letters, _ := em.DeadLetters().List()
+	if calls.Load() != 1 || len(letters) != 1 || letters[0].Attempts != 1 {
+		t.Fatalf("calls=%d letters=%+v", calls.Load(), letters)
+	}
+}
+
+func TestRedriveDeletesLetterOnSuccess(t *testing.T) {
+	em := NewEventManager()
+	var healthy atomic.Bool
+	handled := make(chan string, 1)
+	em.Handle(events.TextMessageEventType, func(e events.BaseEvent) error {
+		if !healthy.Load() {
+			return errors.New("down")
+		}
+		handled <- e.(*events.TextMessageEvent).MessageId
+		return nil
+	}, HandlerOptions{Name: "save"})
+
+	em.Publish(events.TextMessageEventType, textEvent("1"))
+	waitFor(t, func() bool { return em.Stats().DeadLettered == 1 })
+
+	healthy.Store(true)
+	redriven, err := em.RedriveAll()
+	if err != nil || redriven != 1 {
+		t.Fatalf("redriven=%d err=%v", redriven, err)
+	}
+	if got := <-handled; got != "1" {
+		t.Fatalf("handled %s", got)
+	}
+	if letters, _ := em.DeadLetters().List(); len(letters) != 0 {
+		t.Fatalf("letter not deleted: %+v", letters)
+	}
+}
+
+func TestFileDeadLetterStoreSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.jsonl")
+	store, err := NewFileDeadLetterStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b"} {
+		if err := store.Put(DeadLetter{Id: id, EventType: events.TextMessageEventType, Event: textEvent(id), Attempts: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewFileDeadLetterStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	letters, _ := reopened.List()
+	if len(letters) != 1 || letters[0].Id != "b" {
+		t.Fatalf("unexpected letters %+v", letters)
+	}
+	if text, ok := letters[0].Event.(*events.TextMessageEvent); !ok || text.MessageId != "b" {
+		t.Fatalf("event not decoded: %#v", letters[0].Event)
+	}
+	if _, err := reopened.Get("a"); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Fatalf("deleted letter still present: %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+// ErrDeliveryNotFound is returned by DeliveryStore.Get for a message the
+// tracker has never seen.
+var ErrDeliveryNotFound = errors.New("delivery not found")
+
+// Delivery is the tracked state of one outbound message.
+type Delivery struct {
+	MessageId string                `json:"message_id"`
+	Recipient string                `json:"recipient,omitempty"`
+	Status    events.DeliveryStatus `json:"status"`
+	// Timestamps holds the time of every status seen for the message, also
+	// of statuses that arrived after a later one and did not change Status.
+	Timestamps map[events.DeliveryStatus]time.Time `json:"timestamps"`
+	Errors     []events.StatusError                `json:"errors,omitempty"` // Errors are set when the message failed.
+	UpdatedAt  time.Time                           `json:"updated_at"`
+}
+
+// At returns the time the message reached the status.
+func (delivery Delivery) At(status events.DeliveryStatus) (time.Time, bool) {
+	at, ok := delivery.Timestamps[status]
+	return at, ok
+}
+
+// DeliveryStore keeps the state of tracked messages. Implementations must be
+// safe for concurrent use.
+type DeliveryStore interface {
+	// Get returns the delivery of the message or ErrDeliveryNotFound.
+	Get(messageId string) (Delivery, error)
+	// Put adds the delivery, replacing any delivery of the same message.
+	Put(delivery Delivery) error
+}
+
+// MemoryDeliveryStore keeps deliveries in memory. It is the default store of
+// a DeliveryTracker.
+type MemoryDeliveryStore struct {
+	mu         sync.Mutex
+	deliveries map[string]Delivery
+}
+
+// NewMemoryDeliveryStore creates an empty in-memory store.
+func NewMemoryDeliveryStore() *MemoryDeliveryStore {
+	return &MemoryDeliveryStore{deliveries: make(map[string]Delivery)}
+}
+
+func (store *MemoryDeliveryStore) Get(messageId string) (Delivery, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delivery, ok := store.deliveries[messageId]
+	if !ok {
+		return Delivery{}, ErrDeliveryNotFound
+	}
+	delivery.Timestamps = maps.Clone(delivery.Timestamps)
+	return delivery, nil
+}
+
+func (store *MemoryDeliveryStore) Put(delivery Delivery) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delivery.Timestamps = maps.Clone(delivery.Timestamps)
+	store.deliveries[delivery.MessageId] = delivery
+	return nil
+}
+
+// deliveryRanks orders the statuses a message moves through. Failed is not
+// ranked; see advances.
+var deliveryRanks = map[events.DeliveryStatus]int{
+	events.DeliveryStatusAccepted:  1,
+	events.DeliveryStatusSent:      2,
+	events.DeliveryStatusDelivered: 3,
+	events.DeliveryStatusRead:      4,
+}
+
+// advances reports whether moving from current to next is a forward
+// transition. Failed is final and can be reached from any status but read.
+func advances(current, next events.DeliveryStatus) bool {
+	switch {
+	case current == "":
+		return true
+	case current == events.DeliveryStatusFailed:
+		return false
+	case next == events.DeliveryStatusFailed:
+		return current != events.DeliveryStatusRead
+	}
+	return deliveryRanks[next] > deliveryRanks[current]
+}
+
+// DeliveryTracker answers "what is the current status of message X" from the
+// status events, which Meta may send out of order or more than once. Every
+// message only ever moves forward (accepted, sent, delivered, read, or failed)
+// and each forward move publishes a MessageStatusChangedEvent.
+type DeliveryTracker struct {
+	mu           sync.Mutex
+	store        DeliveryStore
+	eventManager *EventManager
+}
+
+// NewDeliveryTracker creates a tracker publishing on eventManager and keeping
+// its state in store, or in memory when store is nil. Call Attach to feed it
+// the status events of the same event manager.
+func NewDeliveryTracker(eventManager *EventManager, store DeliveryStore) *DeliveryTracker {
+	if store == nil {
+		store = NewMemoryDeliveryStore()
+	}
+	return &DeliveryTracker{store: store, eventManager: eventManager}
+}
+
+// deliveryStatusEvents are the events the tracker consumes.
+var deliveryStatusEvents = []events.EventType{
+	events.MessageSentEventType,
+	events.MessageDeliveredEventType,
+	events.MessageReadEventType,
+	events.MessageFailedEventType,
+	events.MessageUndeliveredEventType,
+}
+
+// Attach subscribes the tracker to the status events of its event manager.
+// Store errors are retried and dead-lettered like any handler error, under
+// the handler name "delivery-tracker". It returns the subscription id to pass
+// to Off.
+func (tracker *DeliveryTracker) Attach() SubscriptionId {
+	return tracker.eventManager.HandleMatch(TypeFilter(deliveryStatusEvents...), tracker.Observe, HandlerOptions{
+		Name:  "delivery-tracker",
+		Retry: RetryPolicy{MaxAttempts: 3},
+	})
+}
+
+// Accepted records a message the API accepted, before any status update.
+func (tracker *DeliveryTracker) Accepted(messageId, recipient string) error {
+	changed, err := tracker.update(messageId, recipient, events.DeliveryStatusAccepted, time.Now(), nil)
+	if changed != nil {
+		tracker.publish(changed)
+	}
+	return err
+}
+
+// TrackResponse records every message of a send response as accepted.
+func (tracker *DeliveryTracker) TrackResponse(response *MessageSendResponse) error {
+	var errs []error
+	for i, message := range response.Messages {
+		var recipient string
+		if i < len(response.Contacts) {
+			recipient = response.Contacts[i].WaID
+			if recipient == "" {
+				recipient = response.Contacts[i].UserId
+			}
+		}
+		if err := tracker.Accepted(message.ID, recipient); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Observe feeds one event to the tracker. Events other than message status
+// updates are ignored.
+func (tracker *DeliveryTracker) Observe(event events.BaseEvent) error {
+	var messageId string
+	var status events.DeliveryStatus
+	var base events.BaseSystemEvent
+	var statusErrors []events.StatusError
+	switch e := event.(type) {
+	case *events.MessageSentEvent:
+		messageId, status, base = e.MessageId, events.DeliveryStatusSent, e.BaseSystemEvent
+	case *events.MessageDeliveredEvent:
+		messageId, status, base = e.MessageId, events.DeliveryStatusDelivered, e.BaseSystemEvent
+	case *events.MessageReadEvent:
+		messageId, status, base = e.MessageId, events.DeliveryStatusRead, e.BaseSystemEvent
+	case *events.MessageFailedEvent:
+		messageId, status, base, statusErrors = e.MessageId, events.DeliveryStatusFailed, e.BaseSystemEvent, e.Errors
+	case *events.MessageUndeliveredEvent:
+		messageId, status, base, statusErrors = e.MessageId, events.DeliveryStatusFailed, e.BaseSystemEvent, e.Errors
+	default:
+		return nil
+	}
+
+	at, err := base.Time()
+	if err != nil {
+		at = time.Now()
+	}
+	changed, err := tracker.update(messageId, events.ConversationKeyOf(event), status, at, statusErrors)
+	if changed != nil {
+		events.WithContext(changed, events.ContextOf(event))
+		tracker.publish(changed)
+	}
+	return err
+}
+
+// update applies a status and returns the event to publish, or nil when the
+// status did not move the message forward.
+func (tracker *DeliveryTracker) update(messageId, recipient string, status events.DeliveryStatus, at time.Time, statusErrors []events.StatusError) (*events.MessageStatusChangedEvent, error) {
+	if messageId == "" {
+		return nil, fmt.Errorf("cannot track a %s status without a message id", status)
+	}
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	delivery, err := tracker.store.Get(messageId)
+	if errors.Is(err, ErrDeliveryNotFound) {
+		delivery = Delivery{MessageId: messageId, Timestamps: make(map[events.DeliveryStatus]time.Time)}
+	} else if err != nil {
+		return nil, err
+	}
+	if delivery.Timestamps == nil {
+		delivery.Timestamps = make(map[events.DeliveryStatus]time.Time)
+	}
+
+	_, seen := delivery.Timestamps[status]
+	forward := advances(delivery.Status, status)
+	if seen && !forward {
+		return nil, nil
+	}
+	if !seen {
+		delivery.Timestamps[status] = at
+	}
+	if delivery.Recipient == "" {
+		delivery.Recipient = recipient
+	}
+	previous := delivery.Status
+	if forward {
+		delivery.Status = status
+		if status == events.DeliveryStatusFailed {
+			delivery.Errors = statusErrors
+		}
+	}
+	delivery.UpdatedAt = time.Now()
+	if err := tracker.store.Put(delivery); err != nil {
+		return nil, err
+	}
+	if !forward {
+		return nil, nil
+	}
+
+	changed := events.NewMessageStatusChangedEvent(events.BaseSystemEvent{
+		Timestamp: fmt.Sprint(at.Unix()),
+	}, messageId, delivery.Recipient, previous, status)
+	changed.Errors = delivery.Errors
+	return changed, nil
+}
+
+func (tracker *DeliveryTracker) publish(changed *events.MessageStatusChangedEvent) {
+	if err := tracker.eventManager.Publish(events.MessageStatusChangedEventType, changed); err != nil {
+		fmt.Println("Error publishing status change:", err)
+	}
+}
+
+// Get returns the tracked state of a message or ErrDeliveryNotFound.
+func (tracker *DeliveryTracker) Get(messageId string) (Delivery, error) {
+	return tracker.store.Get(messageId)
+}
+
+// Status returns the current status of a message or ErrDeliveryNotFound.
+func (tracker *DeliveryTracker) Status(messageId string) (events.DeliveryStatus, error) {
+	delivery, err := tracker.store.Get(messageId)
+	if err != nil {
+		return "", err
+	}
+	return delivery.Status, nil
+}
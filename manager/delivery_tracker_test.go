@@ -0,0 +1,93 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+func statusBase(timestamp string) events.BaseSystemEvent {
+	return events.BaseSystemEvent{Timestamp: timestamp}
+}
+
+func TestDeliveryTrackerOnlyMovesForward(t *testing.T) {
+	em := NewEventManager()
+	changes := make(chan *events.MessageStatusChangedEvent, 8)
+	em.On(events.MessageStatusChangedEventType, func(e events.BaseEvent) {
+		changes <- e.(*events.MessageStatusChangedEvent)
+	})
+	tracker := NewDeliveryTracker(em, nil)
+
+	if err := tracker.Accepted("wamid.1", "911"); err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range []events.BaseEvent{
+		events.NewMessageReadEvent(statusBase("30"), "wamid.1", "911"),
+		events.NewMessageDeliveredEvent(statusBase("20"), "wamid.1", "911"),
+		events.NewMessageSentEvent(statusBase("10"), "wamid.1", "911"),
+		events.NewMessageReadEvent(statusBase("30"), "wamid.1", "911"),
+	} {
+		if err := tracker.Observe(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []events.DeliveryStatus
+	for len(got) < 2 {
+		select {
+		case change := <-changes:
+			got = append(got, change.Status)
+		case <-time.After(time.Second):
+			t.Fatalf("got changes %v, want accepted and read", got)
+		}
+	}
+	select {
+	case change := <-changes:
+		t.Fatalf("unexpected change to %s", change.Status)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if got[0] != events.DeliveryStatusAccepted || got[1] != events.DeliveryStatusRead {
+		t.Fatalf("changes=%v", got)
+	}
+
+	delivery, err := tracker.Get("wamid.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Status != events.DeliveryStatusRead || delivery.Recipient != "911" {
+		t.Fatalf("delivery=%+v", delivery)
+	}
+	if at, ok := delivery.At(events.DeliveryStatusDelivered); !ok || at.Unix() != 20 {
+		t.Fatalf("late delivered timestamp=%v, %v", at, ok)
+	}
+}
+
+func TestDeliveryTrackerFailedIsFinal(t *testing.T) {
+	tracker := NewDeliveryTracker(NewEventManager(), nil)
+	failed := events.NewMessageFailedEvent(statusBase("10"), "wamid.1", "911", "Re-engagement message", 131047, "")
+	failed.Errors = []events.StatusError{{Code: 131047, Title: "Re-engagement message"}}
+	tracker.Observe(failed)
+	tracker.Observe(events.NewMessageDeliveredEvent(statusBase("20"), "wamid.1", "911"))
+
+	delivery, _ := tracker.Get("wamid.1")
+	if delivery.Status != events.DeliveryStatusFailed || len(delivery.Errors) != 1 {
+		t.Fatalf("delivery=%+v", delivery)
+	}
+	if _, err := tracker.Status("wamid.unknown"); !errors.Is(err, ErrDeliveryNotFound) {
+		t.Fatalf("expected ErrDeliveryNotFound, got %v", err)
+	}
+}
+
+func TestDeliveryTrackerAttachConsumesWebhookStatuses(t *testing.T) {
+	em := NewEventManager()
+	tracker := NewDeliveryTracker(em, nil)
+	tracker.Attach()
+	postWebhook(t, newTestWebhook(em), statusesPayload(`{"id":"wamid.1","status":"delivered","timestamp":"1","recipient_id":"911"}`))
+
+	waitFor(t, func() bool {
+		status, _ := tracker.Status("wamid.1")
+		return status == events.DeliveryStatusDelivered
+	})
+}
@@ -0,0 +1,80 @@
+package manager
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+// shardedHandler is a handler registered while the manager runs in
+// DispatchModeSharded. eventType is set for handlers registered with On so
+// Unsubscribe can remove them.
+type shardedHandler struct {
+	id        SubscriptionId
+	eventType events.EventType
+	filter    EventFilter
+	handler   func(ChannelEvent)
+}
+
+func (em *EventManager) sharded() bool {
+	return em.config.DispatchMode == DispatchModeSharded
+}
+
+// addShardedHandler registers a handler with the workers, starting them on
+// first use.
+func (em *EventManager) addShardedHandler(eventType events.EventType, filter EventFilter, handler func(ChannelEvent)) SubscriptionId {
+	em.Lock()
+	defer em.Unlock()
+	em.nextSubscriptionId++
+	id := em.nextSubscriptionId
+	// handlers is copy-on-write so workers can iterate a snapshot without
+	// holding the lock while handlers run.
+	handlers := make([]*shardedHandler, 0, len(em.handlers)+1)
+	handlers = append(handlers, em.handlers...)
+	em.handlers = append(handlers, &shardedHandler{id: id, eventType: eventType, filter: filter, handler: handler})
+	if em.shards == nil {
+		em.shards = make([]*eventQueue, em.config.Workers)
+		for i := range em.shards {
+			em.shards[i] = newEventQueue(em, fmt.Sprintf("worker-%d", i))
+			go em.runWorker(em.shards[i])
+		}
+	}
+	return id
+}
+
+// removeShardedHandlers drops every handler matching remove. It is called with
+// the write lock held.
+func (em *EventManager) removeShardedHandlers(remove func(*shardedHandler) bool) {
+	handlers := make([]*shardedHandler, 0, len(em.handlers))
+	for _, h := range em.handlers {
+		if !remove(h) {
+			handlers = append(handlers, h)
+		}
+	}
+	em.handlers = handlers
+}
+
+// shardFor picks the worker queue of the user the event belongs to. Events
+// without a user all land on the same worker.
+func (em *EventManager) shardFor(data events.BaseEvent) *eventQueue {
+	hash := fnv.New32a()
+	hash.Write([]byte(events.ConversationKeyOf(data)))
+	return em.shards[hash.Sum32()%uint32(len(em.shards))]
+}
+
+// runWorker calls the matching handlers for each event of its shard, one event
+// at a time and in registration order. Handle handlers retry on the worker,
+// holding up the events behind the one they retry.
+func (em *EventManager) runWorker(queue *eventQueue) {
+	for event := range queue.ch {
+		em.RLock()
+		handlers := em.handlers
+		em.RUnlock()
+		for _, h := range handlers {
+			if h.filter(event.Type, event.Data) {
+				h.handler(event)
+			}
+		}
+	}
+}
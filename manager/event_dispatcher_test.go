@@ -0,0 +1,75 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/components"
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+func messageFrom(from, id string) events.BaseMessageEvent {
+	return events.NewBaseMessageEvent(events.BaseMessageEventParams{From: from, MessageId: id})
+}
+
+func TestShardedDispatchOrdersEventsPerUser(t *testing.T) {
+	em := NewEventManagerWithConfig(EventManagerConfig{DispatchMode: DispatchModeSharded, Workers: 4})
+	handled := make(chan string, 16)
+	em.On(events.TextMessageEventType, func(e events.BaseEvent) {
+		// A slow first handler must not let the image below overtake it.
+		time.Sleep(20 * time.Millisecond)
+		handled <- e.(*events.TextMessageEvent).MessageId
+	})
+	em.On(events.ImageMessageEventType, func(e events.BaseEvent) {
+		handled <- e.(*events.ImageMessageEvent).MessageId
+	})
+
+	em.Publish(events.TextMessageEventType, events.NewTextMessageEvent(messageFrom("911", "text"), "hi"))
+	em.Publish(events.ImageMessageEventType, events.NewImageMessageEvent(messageFrom("911", "image"), components.ImageMessage{}, "", "", ""))
+
+	for _, want := range []string{"text", "image"} {
+		select {
+		case got := <-handled:
+			if got != want {
+				t.Fatalf("handled %s, want %s", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s not handled", want)
+		}
+	}
+}
+
+func TestShardedDispatchRunsUsersInParallel(t *testing.T) {
+	em := NewEventManagerWithConfig(EventManagerConfig{DispatchMode: DispatchModeSharded, Workers: 64})
+	release := make(chan struct{})
+	handled := make(chan string, 4)
+	em.On(events.TextMessageEventType, func(e events.BaseEvent) {
+		text := e.(*events.TextMessageEvent)
+		if text.From == "slow" {
+			<-release
+		}
+		handled <- text.From
+	})
+	defer close(release)
+
+	slow := events.NewTextMessageEvent(messageFrom("slow", "1"), "hi")
+	em.Publish(events.TextMessageEventType, slow)
+	// Pick a user on another shard than the blocked one.
+	fast := ""
+	for i := 0; fast == ""; i++ {
+		candidate := events.NewTextMessageEvent(messageFrom(string(rune('a'+i)), "2"), "hi")
+		if em.shardFor(candidate) != em.shardFor(slow) {
+			fast = candidate.From
+			em.Publish(events.TextMessageEventType, candidate)
+		}
+	}
+
+	select {
+	case got := <-handled:
+		if got != fast {
+			t.Fatalf("handled %s first, want %s", got, fast)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("a slow user blocked another user")
+	}
+}
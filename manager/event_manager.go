@@ -1,8 +1,11 @@
 package manager
 
 import (
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/wapikit/wapi.go/pkg/events"
 )
@@ -13,60 +16,197 @@ type ChannelEvent struct {
 	Data events.BaseEvent // Data is the data associated with the event.
 }
 
+// EventFilter reports whether a published event should be delivered to a
+// filtered subscription.
+type EventFilter func(eventType events.EventType, data events.BaseEvent) bool
+
+// SubscriptionId identifies a filtered subscription so it can be removed with Off.
+type SubscriptionId uint64
+
+// filteredSubscriber is a subscription matched by an EventFilter instead of a
+// single event type.
+type filteredSubscriber struct {
+	filter EventFilter
+	queue  *eventQueue
+}
+
 // EventManager is responsible for managing events and their subscribers.
 type EventManager struct {
-	subscribers  map[events.EventType]chan ChannelEvent // subscribers is a map of event types to channels of ChannelEvent.
-	sync.RWMutex                                        // RWMutex is used to synchronize access to the subscribers map.
+	subscribers        map[events.EventType]*eventQueue       // subscribers is a map of event types to their subscriber queue.
+	filtered           map[SubscriptionId]*filteredSubscriber // filtered holds the category, wildcard and predicate subscriptions.
+	nextSubscriptionId SubscriptionId                         // nextSubscriptionId is the id handed out to the next filtered subscription.
+	config             EventManagerConfig                     // config sizes the queues and picks the overflow policy.
+	counters           eventCounters                          // counters back Stats.
+	binder             func(events.BaseEvent)                 // binder restores what serialization drops (the requester) on spilled events.
+	handlers           []*shardedHandler                      // handlers are the On* handlers in DispatchModeSharded.
+	shards             []*eventQueue                          // shards are the worker queues in DispatchModeSharded, started with the first handler.
+	retrying           map[string]*retryingHandler            // retrying maps HandlerOptions.Name to the Handle handlers, for Redrive.
+	handlerNames       atomic.Uint64                          // handlerNames numbers the generated handler names.
+	deadLetterIds      atomic.Uint64                          // deadLetterIds keeps dead letter ids unique within a nanosecond.
+	sync.RWMutex                                              // RWMutex is used to synchronize access to the subscribers map.
 }
 
 // NewEventManager creates a new instance of EventManger.
 func NewEventManager() *EventManager {
+	return NewEventManagerWithConfig(EventManagerConfig{})
+}
+
+// NewEventManagerWithConfig creates an EventManager with custom queue sizes
+// and overflow policy.
+func NewEventManagerWithConfig(config EventManagerConfig) *EventManager {
 	return &EventManager{
-		subscribers: make(map[events.EventType]chan ChannelEvent),
+		subscribers: make(map[events.EventType]*eventQueue),
+		filtered:    make(map[SubscriptionId]*filteredSubscriber),
+		retrying:    make(map[string]*retryingHandler),
+		config:      config.withDefaults(),
 	}
 }
 
+// Stats returns a snapshot of the publish, enqueue and overflow counters.
+func (em *EventManager) Stats() EventManagerStats {
+	return em.counters.snapshot()
+}
+
 // Subscribe adds a new subscriber to the specified event type.
 // The subscriber will be notified when the event is published.
 func (em *EventManager) Subscribe(eventName events.EventType) (chan ChannelEvent, error) {
 	em.Lock()
 	defer em.Unlock()
-	if ch, ok := em.subscribers[eventName]; ok {
-		return ch, nil
+	if queue, ok := em.subscribers[eventName]; ok {
+		return queue.ch, nil
 	}
-	em.subscribers[eventName] = make(chan ChannelEvent, 100)
-	return em.subscribers[eventName], nil
+	em.subscribers[eventName] = newEventQueue(em, string(eventName))
+	return em.subscribers[eventName].ch, nil
 }
 
-// Unsubscribe removes a subscriber from the specified event type.
+// Unsubscribe removes a subscriber from the specified event type, including
+// the handlers registered for it with On.
 func (em *EventManager) Unsubscribe(id events.EventType) {
 	em.Lock()
 	defer em.Unlock()
-	delete(em.subscribers, id)
+	em.removeShardedHandlers(func(h *shardedHandler) bool { return h.eventType == id })
+	if queue, ok := em.subscribers[id]; ok {
+		delete(em.subscribers, id)
+		queue.stop(false)
+	}
 }
 
-// Publish publishes an event to the event system and notifies all the subscribers.
-func (em *EventManager) Publish(event events.EventType, data events.BaseEvent) error {
+// SubscribeFunc adds a subscriber that receives every published event for
+// which filter returns true. The channel is closed when the subscription is
+// removed with Off.
+func (em *EventManager) SubscribeFunc(filter EventFilter) (SubscriptionId, chan ChannelEvent) {
+	em.Lock()
+	defer em.Unlock()
+	em.nextSubscriptionId++
+	id := em.nextSubscriptionId
+	subscriber := &filteredSubscriber{
+		filter: filter,
+		queue:  newEventQueue(em, fmt.Sprintf("subscription-%d", id)),
+	}
+	em.filtered[id] = subscriber
+	return id, subscriber.queue.ch
+}
+
+// Off removes a filtered subscription and closes its channel. Pending retries
+// of a Handle handler or sink are dropped and their events dead-lettered.
+func (em *EventManager) Off(id SubscriptionId) {
+	var stopped []*retryingHandler
+	defer func() {
+		for _, retrying := range stopped {
+			retrying.stop()
+		}
+	}()
 	em.Lock()
 	defer em.Unlock()
+	em.removeShardedHandlers(func(h *shardedHandler) bool { return h.id == id })
+	for name, retrying := range em.retrying {
+		if retrying.id == id {
+			delete(em.retrying, name)
+			stopped = append(stopped, retrying)
+		}
+	}
+	if subscriber, ok := em.filtered[id]; ok {
+		delete(em.filtered, id)
+		subscriber.queue.stop(true)
+	}
+}
+
+// Publish publishes an event to the event system and notifies all the subscribers.
+// When a subscriber queue is full the configured OverflowPolicy applies; the
+// returned error lists every subscriber the event was lost for.
+func (em *EventManager) Publish(event events.EventType, data events.BaseEvent) error {
+	em.counters.published.Add(1)
+
+	channelEvent := ChannelEvent{
+		Type: event,
+		Data: data,
+	}
+
+	// The queues are pushed to outside the lock: under OverflowPolicyBlock a
+	// push can wait for BlockTimeout, and a writer queued behind it would
+	// stall every worker taking the read lock.
+	var errs []error
+	for _, queue := range em.queuesFor(event, data) {
+		if err := queue.push(channelEvent); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
 
-	if ch, ok := em.subscribers[event]; ok {
-		select {
-		case ch <- ChannelEvent{
-			Type: event,
-			Data: data,
-		}:
-		default:
-			return fmt.Errorf("event queue full for type: %s", event)
+// queuesFor returns the queues an event is delivered to.
+func (em *EventManager) queuesFor(event events.EventType, data events.BaseEvent) []*eventQueue {
+	em.RLock()
+	defer em.RUnlock()
+	var queues []*eventQueue
+	if queue, ok := em.subscribers[event]; ok {
+		queues = append(queues, queue)
+	}
+	for _, subscriber := range em.filtered {
+		if subscriber.filter(event, data) {
+			queues = append(queues, subscriber.queue)
 		}
 	}
-	return nil
+	if em.shards != nil {
+		queues = append(queues, em.shardFor(data))
+	}
+	return queues
+}
+
+// notifyOverflow publishes an EventQueueOverflowEvent on a best-effort basis:
+// it never blocks and never applies an overflow policy, so a full queue cannot
+// recurse into further overflows.
+func (em *EventManager) notifyOverflow(queue *eventQueue, eventType events.EventType, action events.EventQueueOverflowAction) {
+	if eventType == events.EventQueueOverflowEventType {
+		return
+	}
+	notice := ChannelEvent{
+		Type: events.EventQueueOverflowEventType,
+		Data: events.NewEventQueueOverflowEvent(events.BaseSystemEvent{
+			Timestamp: fmt.Sprint(time.Now().Unix()),
+		}, queue.name, eventType, string(queue.config.OverflowPolicy), action, queue.config.QueueSize),
+	}
+	for _, target := range em.queuesFor(notice.Type, notice.Data) {
+		target.offer(notice)
+	}
+}
+
+// bind restores the state serialization drops from events read back from a
+// spill file.
+func (em *EventManager) bind(event events.BaseEvent) {
+	if em.binder != nil {
+		em.binder(event)
+	}
 }
 
 // On registers a handler function for the specified event type.
 // The handler function will be called whenever the event is published.
 // It returns the event type that the handler is registered for.
 func (em *EventManager) On(eventName events.EventType, handler func(events.BaseEvent)) events.EventType {
+	if em.sharded() {
+		em.addShardedHandler(eventName, TypeFilter(eventName), func(event ChannelEvent) { handler(event.Data) })
+		return eventName
+	}
 	ch, _ := em.Subscribe(eventName)
 	go func() {
 		for {
@@ -78,3 +218,60 @@ func (em *EventManager) On(eventName events.EventType, handler func(events.BaseE
 	}()
 	return eventName
 }
+
+// OnMatch registers a handler that is called for every published event the
+// predicate accepts. It returns the subscription id to pass to Off.
+func (em *EventManager) OnMatch(predicate EventFilter, handler func(events.BaseEvent)) SubscriptionId {
+	return em.onMatch("", predicate, func(event ChannelEvent) { handler(event.Data) })
+}
+
+// onMatch starts a handler for a filtered subscription in the configured
+// dispatch mode. eventType is only recorded for sharded handlers.
+func (em *EventManager) onMatch(eventType events.EventType, predicate EventFilter, handler func(ChannelEvent)) SubscriptionId {
+	if em.sharded() {
+		return em.addShardedHandler(eventType, predicate, handler)
+	}
+	id, ch := em.SubscribeFunc(predicate)
+	go func() {
+		for event := range ch {
+			handler(event)
+		}
+	}()
+	return id
+}
+
+// OnCategory registers a handler for every event of the given category, e.g.
+// all inbound messages or all status updates.
+func (em *EventManager) OnCategory(category events.EventCategory, handler func(events.BaseEvent)) SubscriptionId {
+	return em.OnMatch(CategoryFilter(category), handler)
+}
+
+// OnAll registers a handler for every published event.
+func (em *EventManager) OnAll(handler func(events.BaseEvent)) SubscriptionId {
+	return em.OnMatch(func(events.EventType, events.BaseEvent) bool { return true }, handler)
+}
+
+// CategoryFilter matches events whose category is one of the given categories.
+func CategoryFilter(categories ...events.EventCategory) EventFilter {
+	return func(_ events.EventType, data events.BaseEvent) bool {
+		category := events.CategoryOf(data)
+		for _, c := range categories {
+			if c == category {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// TypeFilter matches events published under one of the given event types.
+func TypeFilter(eventTypes ...events.EventType) EventFilter {
+	return func(eventType events.EventType, _ events.BaseEvent) bool {
+		for _, t := range eventTypes {
+			if t == eventType {
+				return true
+			}
+		}
+		return false
+	}
+}
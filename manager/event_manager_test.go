@@ -0,0 +1,66 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+// Categories come from the embedded base event; status events override the
+// system category of BaseSystemEvent.
+func TestEventCategoryFromEmbedding(t *testing.T) {
+	base := events.NewBaseMessageEvent(events.BaseMessageEventParams{MessageId: "wamid.1"})
+	cases := map[events.EventCategory]events.BaseEvent{
+		events.EventCategoryMessage:         events.NewTextMessageEvent(base, "hi"),
+		events.EventCategoryStatus:          events.NewMessageReadEvent(events.BaseSystemEvent{}, "wamid.1", "91"),
+		events.EventCategorySystem:          events.NewReadyEvent(),
+		events.EventCategoryBusinessAccount: events.NewAccountReviewUpdateEvent(&events.BaseBusinessAccountEvent{}, "APPROVED"),
+	}
+	for want, event := range cases {
+		if got := events.CategoryOf(event); got != want {
+			t.Fatalf("%T: category=%q, want %q", event, got, want)
+		}
+	}
+}
+
+func TestOnCategoryReceivesOnlyMatchingEvents(t *testing.T) {
+	em := NewEventManager()
+	received := make(chan events.BaseEvent, 4)
+	em.OnCategory(events.EventCategoryStatus, func(e events.BaseEvent) { received <- e })
+
+	base := events.NewBaseMessageEvent(events.BaseMessageEventParams{MessageId: "wamid.1"})
+	if err := em.Publish(events.TextMessageEventType, events.NewTextMessageEvent(base, "hi")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if err := em.Publish(events.MessageSentEventType, events.NewMessageSentEvent(events.BaseSystemEvent{}, "wamid.2", "91")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	select {
+	case e := <-received:
+		if _, ok := e.(*events.MessageSentEvent); !ok {
+			t.Fatalf("unexpected event %T", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("status event not delivered")
+	}
+	select {
+	case e := <-received:
+		t.Fatalf("unexpected extra event %T", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestOffClosesFilteredSubscription(t *testing.T) {
+	em := NewEventManager()
+	id, ch := em.SubscribeFunc(TypeFilter(events.ReadyEventType))
+	em.Off(id)
+	if _, open := <-ch; open {
+		t.Fatal("channel should be closed after Off")
+	}
+	// Publishing after Off must not panic on the closed channel.
+	if err := em.Publish(events.ReadyEventType, events.NewReadyEvent()); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+}
@@ -0,0 +1,463 @@
+package manager
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+// OverflowPolicy decides what Publish does when a subscriber queue is full.
+type OverflowPolicy string
+
+const (
+	// OverflowPolicyDropNewest drops the event being published (historical behavior).
+	OverflowPolicyDropNewest OverflowPolicy = "drop_newest"
+	// OverflowPolicyDropOldest evicts the oldest queued event to make room.
+	OverflowPolicyDropOldest OverflowPolicy = "drop_oldest"
+	// OverflowPolicyBlock waits up to BlockTimeout for a free slot, then drops the event.
+	OverflowPolicyBlock OverflowPolicy = "block"
+	// OverflowPolicySpillToDisk appends overflowing events to a file and feeds
+	// them back into the queue, in order, as the subscriber catches up.
+	OverflowPolicySpillToDisk OverflowPolicy = "spill_to_disk"
+)
+
+// DispatchMode decides how handlers registered with On, OnMatch, OnCategory
+// and OnAll are scheduled.
+type DispatchMode string
+
+const (
+	// DispatchModePerEventType runs one goroutine per subscription (historical
+	// behavior). Events of different types may be handled out of order.
+	DispatchModePerEventType DispatchMode = "per_event_type"
+	// DispatchModeSharded routes every event to one of Workers goroutines by
+	// the user it belongs to (see events.ConversationKeyOf). Events of one user
+	// are handled strictly in publish order, across all event types, while
+	// different users are handled in parallel.
+	DispatchModeSharded DispatchMode = "sharded"
+)
+
+const (
+	defaultEventQueueSize    = 100
+	defaultEventBlockTimeout = 5 * time.Second
+)
+
+// EventManagerConfig configures the subscriber queues of an EventManager. The
+// zero value keeps the historical behavior: 100 slots, drop the newest event,
+// one goroutine per subscription.
+type EventManagerConfig struct {
+	QueueSize      int            // QueueSize is the number of buffered events per subscriber. Defaults to 100.
+	OverflowPolicy OverflowPolicy // OverflowPolicy applies when a queue is full. Defaults to OverflowPolicyDropNewest.
+	BlockTimeout   time.Duration  // BlockTimeout bounds OverflowPolicyBlock. Defaults to 5 seconds.
+	SpillDirectory string         // SpillDirectory holds OverflowPolicySpillToDisk files. Defaults to os.TempDir().
+	DispatchMode   DispatchMode   // DispatchMode schedules handlers. Defaults to DispatchModePerEventType.
+	Workers        int            // Workers is the number of shards for DispatchModeSharded. Defaults to GOMAXPROCS.
+
+	// DeadLetters receives the events Handle handlers failed on after their
+	// retries. Defaults to a MemoryDeadLetterStore.
+	DeadLetters DeadLetterStore
+}
+
+func (config EventManagerConfig) withDefaults() EventManagerConfig {
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultEventQueueSize
+	}
+	if config.OverflowPolicy == "" {
+		config.OverflowPolicy = OverflowPolicyDropNewest
+	}
+	if config.BlockTimeout <= 0 {
+		config.BlockTimeout = defaultEventBlockTimeout
+	}
+	if config.SpillDirectory == "" {
+		config.SpillDirectory = os.TempDir()
+	}
+	if config.DispatchMode == "" {
+		config.DispatchMode = DispatchModePerEventType
+	}
+	if config.Workers <= 0 {
+		config.Workers = runtime.GOMAXPROCS(0)
+	}
+	if config.DeadLetters == nil {
+		config.DeadLetters = NewMemoryDeadLetterStore()
+	}
+	return config
+}
+
+// EventManagerStats is a snapshot of the EventManager counters.
+type EventManagerStats struct {
+	Published     uint64 // Published counts calls to Publish.
+	Enqueued      uint64 // Enqueued counts events handed to a subscriber queue (one per matching subscriber).
+	DroppedNewest uint64 // DroppedNewest counts events dropped because their queue was full.
+	DroppedOldest uint64 // DroppedOldest counts queued events evicted to make room.
+	BlockTimeouts uint64 // BlockTimeouts counts events dropped after waiting BlockTimeout.
+	Spilled       uint64 // Spilled counts events written to a spill file.
+	SpillErrors   uint64 // SpillErrors counts events lost because the spill file failed.
+
+	HandlerRetries   uint64 // HandlerRetries counts retries of failed Handle handlers.
+	DeadLettered     uint64 // DeadLettered counts events written to the dead-letter store.
+	DeadLetterErrors uint64 // DeadLetterErrors counts events lost because the dead-letter store failed.
+}
+
+type eventCounters struct {
+	published, enqueued, droppedNewest, droppedOldest, blockTimeouts, spilled, spillErrors atomic.Uint64
+	handlerRetries, deadLettered, deadLetterErrors                                         atomic.Uint64
+}
+
+func (c *eventCounters) snapshot() EventManagerStats {
+	return EventManagerStats{
+		Published:     c.published.Load(),
+		Enqueued:      c.enqueued.Load(),
+		DroppedNewest: c.droppedNewest.Load(),
+		DroppedOldest: c.droppedOldest.Load(),
+		BlockTimeouts: c.blockTimeouts.Load(),
+		Spilled:       c.spilled.Load(),
+		SpillErrors:   c.spillErrors.Load(),
+
+		HandlerRetries:   c.handlerRetries.Load(),
+		DeadLettered:     c.deadLettered.Load(),
+		DeadLetterErrors: c.deadLetterErrors.Load(),
+	}
+}
+
+// eventQueue is a subscriber queue: a buffered channel plus the overflow
+// policy applied when the channel is full.
+type eventQueue struct {
+	name    string
+	ch      chan ChannelEvent
+	config  EventManagerConfig
+	manager *EventManager
+
+	// closed is closed by stop. Pushes happen outside the manager lock, so a
+	// push can race the removal of its queue; closeMu keeps the channel open
+	// until the pushes in flight have returned.
+	closeMu sync.RWMutex
+	closed  chan struct{}
+
+	// spill state, only used by OverflowPolicySpillToDisk.
+	spillMu   sync.Mutex
+	spill     *spillFile
+	refilling bool
+	wake      chan struct{}
+	done      chan struct{}
+	stopped   sync.WaitGroup
+}
+
+func newEventQueue(em *EventManager, name string) *eventQueue {
+	q := &eventQueue{
+		name:    name,
+		ch:      make(chan ChannelEvent, em.config.QueueSize),
+		config:  em.config,
+		manager: em,
+		closed:  make(chan struct{}),
+	}
+	if q.config.OverflowPolicy == OverflowPolicySpillToDisk {
+		q.wake = make(chan struct{}, 1)
+		q.done = make(chan struct{})
+		q.stopped.Add(1)
+		go q.refill()
+	}
+	return q
+}
+
+// push hands the event to the queue, applying the overflow policy when the
+// queue is full. A non-nil error means the event was lost for this queue.
+// Events pushed to a stopped queue are discarded.
+func (q *eventQueue) push(event ChannelEvent) error {
+	// Overflow notices are offered once closeMu is released, since offering
+	// takes the manager lock that Off holds while it stops a queue.
+	var overflows []queueOverflow
+	defer func() {
+		for _, overflow := range overflows {
+			q.manager.notifyOverflow(q, overflow.eventType, overflow.action)
+		}
+	}()
+	q.closeMu.RLock()
+	defer q.closeMu.RUnlock()
+	select {
+	case <-q.closed:
+		return nil
+	default:
+	}
+	counters := &q.manager.counters
+	switch q.config.OverflowPolicy {
+	case OverflowPolicyDropOldest:
+		for {
+			select {
+			case q.ch <- event:
+				counters.enqueued.Add(1)
+				return nil
+			default:
+			}
+			select {
+			case evicted := <-q.ch:
+				counters.droppedOldest.Add(1)
+				overflows = append(overflows, queueOverflow{evicted.Type, events.EventQueueOverflowActionDroppedOldest})
+			default:
+			}
+		}
+	case OverflowPolicyBlock:
+		select {
+		case q.ch <- event:
+			counters.enqueued.Add(1)
+			return nil
+		default:
+		}
+		timer := time.NewTimer(q.config.BlockTimeout)
+		defer timer.Stop()
+		select {
+		case q.ch <- event:
+			counters.enqueued.Add(1)
+			return nil
+		case <-q.closed:
+			return nil
+		case <-timer.C:
+			counters.blockTimeouts.Add(1)
+			overflows = append(overflows, queueOverflow{event.Type, events.EventQueueOverflowActionBlockTimeout})
+			return fmt.Errorf("event queue full for %s: timed out after %s", q.name, q.config.BlockTimeout)
+		}
+	case OverflowPolicySpillToDisk:
+		spilled, err := q.pushOrSpill(event)
+		if spilled {
+			overflows = append(overflows, queueOverflow{event.Type, events.EventQueueOverflowActionSpilled})
+		}
+		return err
+	default:
+		select {
+		case q.ch <- event:
+			counters.enqueued.Add(1)
+			return nil
+		default:
+			counters.droppedNewest.Add(1)
+			overflows = append(overflows, queueOverflow{event.Type, events.EventQueueOverflowActionDroppedNewest})
+			return fmt.Errorf("event queue full for %s", q.name)
+		}
+	}
+}
+
+// offer delivers without applying the overflow policy. It is used for
+// overflow notices so a full queue can never recurse into another overflow.
+func (q *eventQueue) offer(event ChannelEvent) bool {
+	q.closeMu.RLock()
+	defer q.closeMu.RUnlock()
+	select {
+	case <-q.closed:
+		return false
+	default:
+	}
+	select {
+	case q.ch <- event:
+		q.manager.counters.enqueued.Add(1)
+		return true
+	default:
+		return false
+	}
+}
+
+// pushOrSpill keeps the queue in order: once anything is spilled, every new
+// event goes to the spill file until the refill goroutine has drained it.
+// spilled reports whether the event went to the spill file.
+func (q *eventQueue) pushOrSpill(event ChannelEvent) (spilled bool, err error) {
+	counters := &q.manager.counters
+	q.spillMu.Lock()
+	defer q.spillMu.Unlock()
+
+	if (q.spill == nil || q.spill.len() == 0) && !q.refilling {
+		select {
+		case q.ch <- event:
+			counters.enqueued.Add(1)
+			return false, nil
+		default:
+		}
+	}
+
+	if q.spill == nil {
+		spill, err := newSpillFile(q.config.SpillDirectory, q.name)
+		if err != nil {
+			counters.spillErrors.Add(1)
+			return false, fmt.Errorf("event queue full for %s and spill failed: %w", q.name, err)
+		}
+		q.spill = spill
+	}
+	if err := q.spill.push(event); err != nil {
+		counters.spillErrors.Add(1)
+		return false, fmt.Errorf("event queue full for %s and spill failed: %w", q.name, err)
+	}
+	counters.spilled.Add(1)
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return true, nil
+}
+
+// refill moves spilled events back into the channel as room frees up.
+func (q *eventQueue) refill() {
+	defer q.stopped.Done()
+	for {
+		select {
+		case <-q.done:
+			return
+		case <-q.wake:
+		}
+		for {
+			q.spillMu.Lock()
+			if q.spill == nil || q.spill.len() == 0 {
+				q.spillMu.Unlock()
+				break
+			}
+			event, err := q.spill.pop()
+			q.refilling = err == nil
+			q.spillMu.Unlock()
+			if err != nil {
+				q.manager.counters.spillErrors.Add(1)
+				continue
+			}
+			q.manager.bind(event.Data)
+
+			select {
+			case q.ch <- event:
+				q.manager.counters.enqueued.Add(1)
+			case <-q.done:
+				return
+			}
+			q.spillMu.Lock()
+			q.refilling = false
+			q.spillMu.Unlock()
+		}
+	}
+}
+
+// stop ends the refill goroutine and removes the spill file. The channel is
+// closed only when closeChannel is set, since handlers started with On never
+// expect their channel to close.
+func (q *eventQueue) stop(closeChannel bool) {
+	close(q.closed)
+	q.closeMu.Lock()
+	defer q.closeMu.Unlock()
+	if q.done != nil {
+		close(q.done)
+		q.stopped.Wait()
+		q.spillMu.Lock()
+		if q.spill != nil {
+			q.spill.remove()
+			q.spill = nil
+		}
+		q.spillMu.Unlock()
+	}
+	if closeChannel {
+		close(q.ch)
+	}
+}
+
+// queueOverflow is an overflow notice held back until a push returns.
+type queueOverflow struct {
+	eventType events.EventType
+	action    events.EventQueueOverflowAction
+}
+
+// eventRecord is the serialized form of a ChannelEvent, used for spill files
+// and sinks. EventType is the type the event was published under, which is not
+// always the canonical type in the envelope.
+type eventRecord struct {
+	EventType events.EventType `json:"event_type"`
+	Event     json.RawMessage  `json:"event"`
+}
+
+func marshalEventRecord(event ChannelEvent) ([]byte, error) {
+	data, err := events.Marshal(event.Data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(eventRecord{EventType: event.Type, Event: data})
+}
+
+// spillFile is an append-only JSON lines file read from the front. It is
+// truncated whenever it is fully drained.
+type spillFile struct {
+	file       *os.File
+	readOffset int64
+	count      int
+}
+
+func newSpillFile(directory, name string) (*spillFile, error) {
+	file, err := os.CreateTemp(directory, fmt.Sprintf("wapi-events-%s-*.jsonl", sanitizeFileName(name)))
+	if err != nil {
+		return nil, err
+	}
+	return &spillFile{file: file}, nil
+}
+
+func (s *spillFile) len() int {
+	return s.count
+}
+
+func (s *spillFile) push(event ChannelEvent) error {
+	line, err := marshalEventRecord(event)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.count++
+	return nil
+}
+
+func (s *spillFile) pop() (ChannelEvent, error) {
+	if _, err := s.file.Seek(s.readOffset, io.SeekStart); err != nil {
+		return ChannelEvent{}, err
+	}
+	line, err := bufio.NewReader(s.file).ReadBytes('\n')
+	if err != nil {
+		// The file is unreadable from here on; drop what is left rather than
+		// retrying the same read forever.
+		s.count = 0
+		s.readOffset = 0
+		s.file.Truncate(0)
+		return ChannelEvent{}, err
+	}
+	s.readOffset += int64(len(line))
+	s.count--
+	if s.count == 0 {
+		s.readOffset = 0
+		if err := s.file.Truncate(0); err != nil {
+			return ChannelEvent{}, err
+		}
+	}
+
+	var record eventRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		return ChannelEvent{}, err
+	}
+	data, err := events.Unmarshal(record.Event)
+	if err != nil {
+		return ChannelEvent{}, err
+	}
+	return ChannelEvent{Type: record.EventType, Data: data}, nil
+}
+
+func (s *spillFile) remove() {
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
+
+func sanitizeFileName(name string) string {
+	safe := []byte(name)
+	for i, c := range safe {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			safe[i] = '_'
+		}
+	}
+	return string(safe)
+}
@@ -0,0 +1,110 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+func textEvent(id string) *events.TextMessageEvent {
+	return events.NewTextMessageEvent(events.NewBaseMessageEvent(events.BaseMessageEventParams{MessageId: id}), id)
+}
+
+func messageIdOf(t *testing.T, event ChannelEvent) string {
+	t.Helper()
+	text, ok := event.Data.(*events.TextMessageEvent)
+	if !ok {
+		t.Fatalf("unexpected event %T", event.Data)
+	}
+	return text.MessageId
+}
+
+func TestDropOldestKeepsNewestEvents(t *testing.T) {
+	em := NewEventManagerWithConfig(EventManagerConfig{QueueSize: 2, OverflowPolicy: OverflowPolicyDropOldest})
+	ch, _ := em.Subscribe(events.TextMessageEventType)
+	for _, id := range []string{"1", "2", "3"} {
+		if err := em.Publish(events.TextMessageEventType, textEvent(id)); err != nil {
+			t.Fatalf("publish %s: %v", id, err)
+		}
+	}
+	if got := messageIdOf(t, <-ch); got != "2" {
+		t.Fatalf("first event=%s, want 2", got)
+	}
+	if got := messageIdOf(t, <-ch); got != "3" {
+		t.Fatalf("second event=%s, want 3", got)
+	}
+	if stats := em.Stats(); stats.DroppedOldest != 1 || stats.Published != 3 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestBlockTimesOutAndNotifies(t *testing.T) {
+	em := NewEventManagerWithConfig(EventManagerConfig{QueueSize: 1, OverflowPolicy: OverflowPolicyBlock, BlockTimeout: 10 * time.Millisecond})
+	ch, _ := em.Subscribe(events.TextMessageEventType)
+	overflow, _ := em.Subscribe(events.EventQueueOverflowEventType)
+
+	if err := em.Publish(events.TextMessageEventType, textEvent("1")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if err := em.Publish(events.TextMessageEventType, textEvent("2")); err == nil {
+		t.Fatal("expected a timeout error")
+	}
+	notice, ok := (<-overflow).Data.(*events.EventQueueOverflowEvent)
+	if !ok || notice.Action != events.EventQueueOverflowActionBlockTimeout || notice.EventType != events.TextMessageEventType {
+		t.Fatalf("unexpected overflow notice %+v", notice)
+	}
+	if got := messageIdOf(t, <-ch); got != "1" {
+		t.Fatalf("event=%s, want 1", got)
+	}
+	if stats := em.Stats(); stats.BlockTimeouts != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestBlockedPublishDoesNotHoldTheManagerLock(t *testing.T) {
+	em := NewEventManagerWithConfig(EventManagerConfig{QueueSize: 1, OverflowPolicy: OverflowPolicyBlock, BlockTimeout: time.Second})
+	id, _ := em.SubscribeFunc(TypeFilter(events.TextMessageEventType))
+	if err := em.Publish(events.TextMessageEventType, textEvent("1")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	published := make(chan error, 1)
+	go func() { published <- em.Publish(events.TextMessageEventType, textEvent("2")) }()
+	time.Sleep(10 * time.Millisecond)
+
+	start := time.Now()
+	em.Subscribe(events.ReactionMessageEventType)
+	em.Off(id)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("subscribing and Off waited %s for the blocked publish", elapsed)
+	}
+	if err := <-published; err != nil {
+		t.Fatalf("publish to a removed subscription: %v", err)
+	}
+}
+
+func TestSpillToDiskPreservesOrder(t *testing.T) {
+	em := NewEventManagerWithConfig(EventManagerConfig{QueueSize: 2, OverflowPolicy: OverflowPolicySpillToDisk, SpillDirectory: t.TempDir()})
+	ch, _ := em.Subscribe(events.TextMessageEventType)
+	defer em.Unsubscribe(events.TextMessageEventType)
+
+	want := []string{"1", "2", "3", "4", "5", "6"}
+	for _, id := range want {
+		if err := em.Publish(events.TextMessageEventType, textEvent(id)); err != nil {
+			t.Fatalf("publish %s: %v", id, err)
+		}
+	}
+	for _, id := range want {
+		select {
+		case event := <-ch:
+			if got := messageIdOf(t, event); got != id {
+				t.Fatalf("event=%s, want %s", got, id)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %s not delivered", id)
+		}
+	}
+	if stats := em.Stats(); stats.Spilled != 4 || stats.SpillErrors != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,284 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+// EventHandler is a handler that can report failure. A non-nil error makes the
+// event manager retry the event and, once retries are exhausted, move it to the
+// dead-letter store.
+type EventHandler func(event events.BaseEvent) error
+
+// RetryPolicy configures how often a failing EventHandler is retried. The
+// zero value calls the handler once and dead-letters the event on failure.
+type RetryPolicy struct {
+	MaxAttempts    int           // MaxAttempts is the number of calls, the first one included.
+	InitialBackoff time.Duration // InitialBackoff is the wait before the first retry. Defaults to 200ms.
+	MaxBackoff     time.Duration // MaxBackoff caps the wait between retries. Defaults to 30 seconds.
+	Multiplier     float64       // Multiplier grows the wait after every retry. Defaults to 2.
+}
+
+func (policy RetryPolicy) withDefaults() RetryPolicy {
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 1
+	}
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = 200 * time.Millisecond
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = 30 * time.Second
+	}
+	if policy.Multiplier < 1 {
+		policy.Multiplier = 2
+	}
+	return policy
+}
+
+// backoff returns the wait after the given failed attempt (1-based).
+func (policy RetryPolicy) backoff(attempt int) time.Duration {
+	wait := float64(policy.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		wait *= policy.Multiplier
+		if wait >= float64(policy.MaxBackoff) {
+			return policy.MaxBackoff
+		}
+	}
+	return time.Duration(wait)
+}
+
+// HandlerOptions configures a handler registered with Handle or HandleMatch.
+type HandlerOptions struct {
+	// Name identifies the handler on its dead letters and is how Redrive finds
+	// it again, so it should stay the same across restarts. Registering a
+	// second handler under the same name replaces the first for redrives.
+	// Defaults to a generated name.
+	Name  string
+	Retry RetryPolicy // Retry is applied to every event the handler fails on.
+}
+
+// retryingHandler is an EventHandler or EventSink registered with its retry
+// policy. inline handlers wait out their retries in the goroutine delivering
+// their events; the others retry on timers, which Off stops.
+type retryingHandler struct {
+	id      SubscriptionId
+	name    string
+	handler func(ChannelEvent) error
+	retry   RetryPolicy
+	inline  bool
+
+	mu      sync.Mutex
+	timers  map[*time.Timer]func() // timers maps the pending retries to the dead-lettering of their event.
+	stopped bool
+}
+
+// Handle registers an error-returning handler for the specified event type.
+// Failed events are retried with backoff and dead-lettered when retries run
+// out. In DispatchModeSharded the retries run on the worker, so the events of
+// a user stay in publish order and wait for them. Otherwise they run on a
+// timer, so later events of the subscription do not wait and may be handled
+// before the retry. Retries still pending when the subscription is removed
+// with Off are dead-lettered. It returns the subscription id to pass to Off.
+func (em *EventManager) Handle(eventName events.EventType, handler EventHandler, options HandlerOptions) SubscriptionId {
+	return em.handleMatch(eventName, TypeFilter(eventName), handler, options)
+}
+
+// HandleMatch is Handle for every published event the predicate accepts.
+func (em *EventManager) HandleMatch(predicate EventFilter, handler EventHandler, options HandlerOptions) SubscriptionId {
+	return em.handleMatch("", predicate, handler, options)
+}
+
+func (em *EventManager) handleMatch(eventType events.EventType, predicate EventFilter, handler EventHandler, options HandlerOptions) SubscriptionId {
+	retrying := em.newRetryingHandler("handler", options, em.sharded(), func(event ChannelEvent) error {
+		return handler(event.Data)
+	})
+	id := em.onMatch(eventType, predicate, retrying.deliver(em))
+	em.setRetryingId(retrying, id)
+	return id
+}
+
+// newRetryingHandler registers handler under its name so Redrive can find it.
+func (em *EventManager) newRetryingHandler(kind string, options HandlerOptions, inline bool, handler func(ChannelEvent) error) *retryingHandler {
+	retrying := &retryingHandler{
+		name:    options.Name,
+		handler: handler,
+		retry:   options.Retry.withDefaults(),
+		inline:  inline,
+		timers:  make(map[*time.Timer]func()),
+	}
+	if retrying.name == "" {
+		retrying.name = fmt.Sprintf("%s-%d", kind, em.handlerNames.Add(1))
+	}
+	em.Lock()
+	em.retrying[retrying.name] = retrying
+	em.Unlock()
+	return retrying
+}
+
+func (em *EventManager) setRetryingId(retrying *retryingHandler, id SubscriptionId) {
+	em.Lock()
+	retrying.id = id
+	em.Unlock()
+}
+
+// deliver returns the subscription callback: retry, then dead-letter.
+func (retrying *retryingHandler) deliver(em *EventManager) func(ChannelEvent) {
+	return func(event ChannelEvent) {
+		if !retrying.inline {
+			retrying.try(em, event, 1)
+			return
+		}
+		if attempts, err := em.attempt(retrying, event); err != nil {
+			retrying.deadLetter(em, event, attempts, err)
+		}
+	}
+}
+
+// try makes the given attempt (1-based). When it fails with attempts left the
+// next one is scheduled on a timer instead of slept for, which would stall the
+// goroutine delivering the subscription's events.
+func (retrying *retryingHandler) try(em *EventManager, event ChannelEvent, attempt int) {
+	err := callHandler(retrying.handler, event)
+	if err == nil {
+		return
+	}
+	retrying.mu.Lock()
+	if attempt >= retrying.retry.MaxAttempts || retrying.stopped {
+		retrying.mu.Unlock()
+		retrying.deadLetter(em, event, attempt, err)
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(retrying.retry.backoff(attempt), func() {
+		retrying.mu.Lock()
+		delete(retrying.timers, timer)
+		retrying.mu.Unlock()
+		em.counters.handlerRetries.Add(1)
+		retrying.try(em, event, attempt+1)
+	})
+	retrying.timers[timer] = func() { retrying.deadLetter(em, event, attempt, err) }
+	retrying.mu.Unlock()
+}
+
+// stop cancels the pending retries, dead-lettering their events, and makes
+// the retries in progress the last. It is called by Off.
+func (retrying *retryingHandler) stop() {
+	retrying.mu.Lock()
+	retrying.stopped = true
+	timers := retrying.timers
+	retrying.timers = make(map[*time.Timer]func())
+	retrying.mu.Unlock()
+	for timer, deadLetter := range timers {
+		if timer.Stop() {
+			deadLetter()
+		}
+	}
+}
+
+func (retrying *retryingHandler) isStopped() bool {
+	retrying.mu.Lock()
+	defer retrying.mu.Unlock()
+	return retrying.stopped
+}
+
+func (retrying *retryingHandler) deadLetter(em *EventManager, event ChannelEvent, attempts int, err error) {
+	em.deadLetter(DeadLetter{
+		Subscription: retrying.name,
+		EventType:    event.Type,
+		Event:        event.Data,
+		Attempts:     attempts,
+	}, err)
+}
+
+// attempt calls the handler until it succeeds, the retry policy runs out or
+// the handler is removed with Off, waiting out the backoff in the caller's
+// goroutine. It is used by inline handlers and by Redrive, whose caller waits
+// for the outcome. It returns the number of calls made and the last error.
+func (em *EventManager) attempt(retrying *retryingHandler, event ChannelEvent) (int, error) {
+	for attempt := 1; ; attempt++ {
+		err := callHandler(retrying.handler, event)
+		if err == nil || attempt >= retrying.retry.MaxAttempts || retrying.isStopped() {
+			return attempt, err
+		}
+		em.counters.handlerRetries.Add(1)
+		time.Sleep(retrying.retry.backoff(attempt))
+	}
+}
+
+// callHandler turns a handler panic into an error so one bad event cannot take
+// down the subscription goroutine.
+func callHandler(handler func(ChannelEvent) error, event ChannelEvent) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("handler panicked: %v", recovered)
+		}
+	}()
+	return handler(event)
+}
+
+func (em *EventManager) deadLetter(letter DeadLetter, err error) {
+	if letter.Id == "" {
+		letter.Id = fmt.Sprintf("%d-%d", time.Now().UnixNano(), em.deadLetterIds.Add(1))
+	}
+	letter.Error = err.Error()
+	letter.FailedAt = time.Now()
+	if putErr := em.config.DeadLetters.Put(letter); putErr != nil {
+		em.counters.deadLetterErrors.Add(1)
+		fmt.Println("Error storing dead letter:", putErr)
+		return
+	}
+	em.counters.deadLettered.Add(1)
+}
+
+// DeadLetters returns the store failed events are written to.
+func (em *EventManager) DeadLetters() DeadLetterStore {
+	return em.config.DeadLetters
+}
+
+// Redrive hands a dead-lettered event back to the handler named on it, with the
+// handler's retry policy. The letter is deleted when the handler succeeds and
+// updated with the new attempt count and error otherwise.
+func (em *EventManager) Redrive(id string) error {
+	store := em.config.DeadLetters
+	letter, err := store.Get(id)
+	if err != nil {
+		return err
+	}
+	em.RLock()
+	retrying, ok := em.retrying[letter.Subscription]
+	em.RUnlock()
+	if !ok {
+		return fmt.Errorf("no handler named %q is registered", letter.Subscription)
+	}
+
+	em.bind(letter.Event)
+	attempts, err := em.attempt(retrying, ChannelEvent{Type: letter.EventType, Data: letter.Event})
+	if err == nil {
+		return store.Delete(id)
+	}
+	letter.Attempts += attempts
+	em.deadLetter(letter, err)
+	return err
+}
+
+// RedriveAll redrives every dead letter and returns how many succeeded.
+func (em *EventManager) RedriveAll() (int, error) {
+	letters, err := em.config.DeadLetters.List()
+	if err != nil {
+		return 0, err
+	}
+	redriven := 0
+	var errs []error
+	for _, letter := range letters {
+		if err := em.Redrive(letter.Id); err != nil {
+			errs = append(errs, fmt.Errorf("dead letter %s: %w", letter.Id, err))
+			continue
+		}
+		redriven++
+	}
+	return redriven, errors.Join(errs...)
+}
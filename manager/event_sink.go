@@ -0,0 +1,163 @@
+package manager
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+// EventSink receives every published event, e.g. to forward it to a broker or
+// write it to storage. Deliver is called from one goroutine per sink, in publish
+// order. A non-nil error makes the event manager retry the event before the
+// ones after it, so Deliver must tolerate receiving an event more than once.
+type EventSink interface {
+	Deliver(event ChannelEvent) error
+}
+
+const defaultSinkAttempts = 5
+
+// EventSinkFunc adapts a function to EventSink.
+type EventSinkFunc func(event ChannelEvent) error
+
+func (f EventSinkFunc) Deliver(event ChannelEvent) error {
+	return f(event)
+}
+
+// AddSink delivers every published event to the sink, at least once: failed
+// deliveries are retried as configured in options and then dead-lettered, from
+// where Redrive delivers them again. Sinks always get their own queue, also in
+// DispatchModeSharded, so a slow broker never stalls the handlers. It returns
+// the subscription id to pass to Off. Unlike handlers, sinks default to 5
+// attempts per event.
+func (em *EventManager) AddSink(sink EventSink, options HandlerOptions) SubscriptionId {
+	if options.Retry.MaxAttempts == 0 {
+		options.Retry.MaxAttempts = defaultSinkAttempts
+	}
+	retrying := em.newRetryingHandler("sink", options, true, sink.Deliver)
+	id, ch := em.SubscribeFunc(func(events.EventType, events.BaseEvent) bool { return true })
+	go func() {
+		deliver := retrying.deliver(em)
+		for event := range ch {
+			deliver(event)
+		}
+	}()
+	em.setRetryingId(retrying, id)
+	return id
+}
+
+// FileEventSink appends every event to a JSON lines file, one record with the
+// published type and the events.Marshal envelope per line.
+type FileEventSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileEventSink opens (or creates) the file at path for appending.
+func NewFileEventSink(path string) (*FileEventSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileEventSink{file: file}, nil
+}
+
+func (sink *FileEventSink) Deliver(event ChannelEvent) error {
+	line, err := marshalEventRecord(event)
+	if err != nil {
+		return err
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	_, err = sink.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the underlying file.
+func (sink *FileEventSink) Close() error {
+	return sink.file.Close()
+}
+
+// HttpEventSinkSignatureHeader carries the hex HMAC-SHA256 of the request body,
+// prefixed with "sha256=", when HttpEventSink has a secret.
+const HttpEventSinkSignatureHeader = "X-Wapi-Signature-256"
+
+// HttpEventSink POSTs every event as JSON to a URL. Any response other than
+// 2xx is a failed delivery.
+type HttpEventSink struct {
+	Url     string            // Url receives the POST requests.
+	Secret  string            // Secret signs the body with HMAC-SHA256 when set.
+	Headers map[string]string // Headers are added to every request.
+	Client  *http.Client      // Client sends the requests. Defaults to a client with a 10 second timeout.
+}
+
+// NewHttpEventSink creates a sink posting to url, signing bodies with secret.
+func NewHttpEventSink(url, secret string) *HttpEventSink {
+	return &HttpEventSink{Url: url, Secret: secret}
+}
+
+func (sink *HttpEventSink) Deliver(event ChannelEvent) error {
+	body, err := marshalEventRecord(event)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, sink.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range sink.Headers {
+		request.Header.Set(key, value)
+	}
+	if sink.Secret != "" {
+		request.Header.Set(HttpEventSinkSignatureHeader, SignEventSinkBody(sink.Secret, body))
+	}
+
+	client := sink.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("event sink %s responded %s", sink.Url, response.Status)
+	}
+	return nil
+}
+
+// SignEventSinkBody returns the HttpEventSinkSignatureHeader value for body,
+// for receivers that verify the signature.
+func SignEventSinkBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ErrEventSinkFull is returned by ChannelEventSink when its channel is full;
+// the event is retried like any failed delivery.
+var ErrEventSinkFull = errors.New("event sink channel full")
+
+// ChannelEventSink hands every event to an in-process channel.
+type ChannelEventSink chan ChannelEvent
+
+func (sink ChannelEventSink) Deliver(event ChannelEvent) error {
+	select {
+	case sink <- event:
+		return nil
+	default:
+		return ErrEventSinkFull
+	}
+}
@@ -0,0 +1,91 @@
+package manager
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+func TestHttpEventSinkSignsAndRetries(t *testing.T) {
+	var calls atomic.Int32
+	received := make(chan eventRecord, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(HttpEventSinkSignatureHeader) != SignEventSinkBody("secret", body) {
+			t.Errorf("bad signature %q", r.Header.Get(HttpEventSinkSignatureHeader))
+		}
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var record eventRecord
+		json.Unmarshal(body, &record)
+		received <- record
+	}))
+	defer server.Close()
+
+	em := NewEventManager()
+	em.AddSink(NewHttpEventSink(server.URL, "secret"), HandlerOptions{Retry: RetryPolicy{InitialBackoff: time.Millisecond}})
+	em.Publish(events.TextMessageEventType, textEvent("1"))
+
+	select {
+	case record := <-received:
+		event, err := events.Unmarshal(record.Event)
+		if err != nil || record.EventType != events.TextMessageEventType {
+			t.Fatalf("record=%+v err=%v", record, err)
+		}
+		if text, ok := event.(*events.TextMessageEvent); !ok || text.MessageId != "1" {
+			t.Fatalf("unexpected event %#v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event not delivered")
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("calls=%d, want 2", calls.Load())
+	}
+}
+
+func TestChannelEventSinkReceivesEveryEvent(t *testing.T) {
+	em := NewEventManagerWithConfig(EventManagerConfig{DispatchMode: DispatchModeSharded})
+	sink := make(ChannelEventSink, 2)
+	em.AddSink(sink, HandlerOptions{})
+	em.Publish(events.ReadyEventType, events.NewReadyEvent())
+	em.Publish(events.TextMessageEventType, textEvent("1"))
+
+	for _, want := range []events.EventType{events.ReadyEventType, events.TextMessageEventType} {
+		select {
+		case event := <-sink:
+			if event.Type != want {
+				t.Fatalf("got %s, want %s", event.Type, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s not delivered", want)
+		}
+	}
+}
+
+func TestEventSinkRetriesBeforeTheNextEvent(t *testing.T) {
+	var failed atomic.Bool
+	delivered := make(chan string, 2)
+	em := NewEventManager()
+	em.AddSink(EventSinkFunc(func(event ChannelEvent) error {
+		id := event.Data.(*events.TextMessageEvent).MessageId
+		if id == "1" && failed.CompareAndSwap(false, true) {
+			return io.ErrUnexpectedEOF
+		}
+		delivered <- id
+		return nil
+	}), HandlerOptions{Retry: RetryPolicy{InitialBackoff: 20 * time.Millisecond}})
+
+	em.Publish(events.TextMessageEventType, textEvent("1"))
+	em.Publish(events.TextMessageEventType, textEvent("2"))
+	if first, second := <-delivered, <-delivered; first != "1" || second != "2" {
+		t.Fatalf("delivered %s before %s", first, second)
+	}
+}
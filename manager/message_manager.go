@@ -1,11 +1,11 @@
 package manager
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"net/http"
-	"strings"
 
+	"github.com/wapikit/wapi.go/internal/message_dispatch"
 	"github.com/wapikit/wapi.go/internal/request_client"
 	"github.com/wapikit/wapi.go/pkg/components"
 )
@@ -14,103 +14,181 @@ import (
 type MessageManager struct {
 	requester     request_client.RequestClient
 	PhoneNumberId string
+	ctx           context.Context
+	serviceWindow *ServiceWindowConfig
+	outbox        *Outbox
+	reengagement  *reengagement
+	// splitLongMessages sends texts and captions over the API limits as a
+	// sequence of messages; see SetSplitLongMessages.
+	splitLongMessages bool
+	transcript        *Transcript
+	consent           *ConsentRegistry
+	messagingLimits   *MessagingLimitTracker
+	pairPacer         *PairPacer
+	broadcastPacers   *sendPacers       // broadcastPacers pace Broadcast per phone number id.
+	tags              map[string]string // tags are recorded with sent messages; see WithTags.
 }
 
 // NewMessageManager creates a new instance of MessageManager.
 func NewMessageManager(requester request_client.RequestClient, phoneNumberId string) *MessageManager {
 	return &MessageManager{
-		requester:     requester,
-		PhoneNumberId: phoneNumberId,
+		requester:       requester,
+		PhoneNumberId:   phoneNumberId,
+		broadcastPacers: newSendPacers(),
 	}
 }
 
-// MessageSendResponse represents the structured API response for sending a message.
-type MessageSendResponse struct {
-	MessagingProduct string `json:"messaging_product"`
-	Contacts         []struct {
-		Input string `json:"input"`
-		WaID  string `json:"wa_id"`
-		// UserId is the recipient BSUID when the send targeted a BSUID
-		// (identity rollout). Empty for phone-targeted sends — do not treat
-		// its absence as a parse failure.
-		UserId string `json:"user_id,omitempty"`
-	} `json:"contacts"`
-	Messages []struct {
-		ID string `json:"id"`
-	} `json:"messages"`
-	Error *MessageSendError `json:"error,omitempty"`
-}
-
-// MessageSendError represents the error object in an API response.
-type MessageSendError struct {
-	Message   string `json:"message"` // Error description.
-	Type      string `json:"type"`    // Error type (e.g., OAuthException).
-	Code      int    `json:"code"`    // Error code.
-	ErrorData struct {
-		MessagingProduct string `json:"messaging_product"`
-		Details          string `json:"details"`
-	} `json:"error_data"` // Additional error details.
-	ErrorSubcode int    `json:"error_subcode"`
-	FbtraceID    string `json:"fbtrace_id"`
-}
-
-// StatusResponse represents the API response for status updates (read receipts).
-type StatusResponse struct {
-	Success bool              `json:"success"`
-	Error   *MessageSendError `json:"error,omitempty"`
+// WithContext returns a copy of the manager that sends its requests with ctx,
+// e.g. the EventContext of the event being handled, so outbound calls share the
+// inbound event's deadline and trace.
+func (mm *MessageManager) WithContext(ctx context.Context) *MessageManager {
+	scoped := *mm
+	scoped.ctx = ctx
+	return &scoped
+}
+
+// MessageSendResponse, MessageSendError and StatusResponse live in
+// components so events can return them from Reply without importing manager.
+type (
+	MessageSendResponse = components.MessageSendResponse
+	MessageSendError    = components.MessageSendError
+	StatusResponse      = components.StatusResponse
+)
+
+// SetSplitLongMessages makes Send, Reply and their target variants send a text
+// over components.MaxTextBodyLength, or a caption over
+// components.MaxCaptionLength, as a sequence of messages split by
+// components.SplitMessage. The first part replies to the original message when
+// replying; the response lists the ids of all parts in order. Queued sends
+// (Enqueue, Scheduler) are not split.
+func (mm *MessageManager) SetSplitLongMessages(split bool) {
+	mm.splitLongMessages = split
 }
 
 // dispatch converts a message with the given configs and POSTs it to the given
 // endpoint suffix under the phone number id, returning the parsed response. It
 // is the shared core of all Send/Reply/SendMarketing paths (phone and target).
+// The consent and service window checks are made once for the whole message,
+// before it is split, so a closed window sends one fallback template.
 func (mm *MessageManager) dispatch(message components.BaseMessage, configs components.ApiCompatibleJsonConverterConfigs, endpointSuffix string) (*MessageSendResponse, error) {
-	body, err := message.ToJson(configs)
-	if err != nil {
-		return nil, fmt.Errorf("error converting message to json: %v", err)
+	if err := mm.checkConsent(message, configs, endpointSuffix); err != nil {
+		return nil, err
 	}
+	parts := []components.BaseMessage{message}
+	if mm.splitLongMessages {
+		if split := components.SplitMessage(message); len(split) > 1 {
+			parts = split
+		}
+	}
+	if mm.serviceWindow != nil && endpointSuffix == "messages" {
+		checked, err := mm.serviceWindow.checkServiceWindow(message, configs)
+		if mm.reengagement != nil && errors.Is(err, ErrServiceWindowClosed) {
+			return mm.reengage(parts, configs, 0)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, fallback := checked.(*components.TemplateMessage); fallback {
+			parts = []components.BaseMessage{checked}
+		}
+	}
+	if len(parts) == 1 {
+		response, _, err := mm.dispatchOne(parts, configs, endpointSuffix)
+		return response, err
+	}
+	// Once a part is refused for a closed window, it and the parts after it
+	// are queued together behind one fallback template.
+	next, queued := 0, false
+	return message_dispatch.SendSequence(parts, configs, func(part components.BaseMessage, configs components.ApiCompatibleJsonConverterConfigs) (*MessageSendResponse, error) {
+		if queued {
+			return nil, nil
+		}
+		remaining := parts[next:]
+		next++
+		response, reengaged, err := mm.dispatchOne(remaining, configs, endpointSuffix)
+		queued = reengaged
+		return response, err
+	})
+}
 
-	apiRequest := mm.requester.NewApiRequest(strings.Join([]string{mm.PhoneNumberId, endpointSuffix}, "/"), http.MethodPost)
-	apiRequest.SetBody(string(body))
-	responseStr, execErr := apiRequest.Execute()
-
-	// Parse whatever body we got — a non-2xx response carries Meta's error
-	// envelope, which callers may want alongside the returned error.
-	var sendResponse MessageSendResponse
-	if responseStr != "" {
-		if err := json.Unmarshal([]byte(responseStr), &sendResponse); err != nil && execErr == nil {
-			return nil, fmt.Errorf("error unmarshalling response: %v", err)
+// dispatchOne sends the first of parts, handing all of them to the
+// reengagement policy, with reengaged set, when the API refuses it because
+// the customer service window is closed.
+func (mm *MessageManager) dispatchOne(parts []components.BaseMessage, configs components.ApiCompatibleJsonConverterConfigs, endpointSuffix string) (response *MessageSendResponse, reengaged bool, err error) {
+	message := parts[0]
+	release := func(bool) {}
+	_, isTemplate := message.(*components.TemplateMessage)
+	if isTemplate {
+		if release, err = mm.reserveMessagingLimit(recipientOf(configs)); err != nil {
+			return nil, false, err
 		}
 	}
+	response, err = mm.send(message, configs, endpointSuffix)
+	release(err == nil)
+	if mm.reengagement != nil && endpointSuffix == "messages" && !isTemplate && isReengagementError(err) {
+		response, err = mm.reengage(parts, configs, ReengagementErrorCode)
+		return response, true, err
+	}
+	if err == nil {
+		mm.recordOutbound(message, configs, response)
+	}
+	return response, false, err
+}
 
-	// A non-2xx HTTP status surfaces as execErr (typed *GraphAPIError); an
-	// application-level error may also appear in the parsed envelope on a 200.
-	if execErr != nil {
-		if responseStr == "" {
-			return nil, execErr
+// send POSTs the message once its turn with the pair pacer, if any, has come.
+func (mm *MessageManager) send(message components.BaseMessage, configs components.ApiCompatibleJsonConverterConfigs, endpointSuffix string) (*MessageSendResponse, error) {
+	release, err := mm.acquirePair(mm.ctx, recipientOf(configs))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return message_dispatch.Send(mm.ctx, mm.requester, mm.PhoneNumberId, message, configs, endpointSuffix)
+}
+
+// sendBody sends a message converted ahead of time, as the outbox and the
+// scheduler keep them, through the same steps as dispatchOne: the messaging
+// limit for templates, the pair pacer and the transcript. Consent and the
+// service window are left to the callers, which decide what a refusal does to
+// their entry.
+func (mm *MessageManager) sendBody(ctx context.Context, recipient string, body []byte, template bool, endpointSuffix string) (*MessageSendResponse, error) {
+	reserved := func(bool) {}
+	if template {
+		var err error
+		if reserved, err = mm.reserveMessagingLimit(recipient); err != nil {
+			return nil, err
 		}
-		return &sendResponse, execErr
 	}
-	if sendResponse.Error != nil {
-		return &sendResponse, fmt.Errorf("error sending message: %s", sendResponse.Error.Message)
+	release, err := mm.acquirePair(ctx, recipient)
+	if err != nil {
+		reserved(false)
+		return nil, err
 	}
-	return &sendResponse, nil
+	response, err := message_dispatch.SendBody(ctx, mm.requester, mm.PhoneNumberId, body, endpointSuffix)
+	release()
+	reserved(err == nil)
+	if err == nil && mm.transcript != nil {
+		if err := mm.transcript.recordOutbound(mm.PhoneNumberId, recipient, body, response, mm.tags); err != nil {
+			fmt.Println("Error recording sent message:", err)
+		}
+	}
+	return response, err
 }
 
 // SendToTarget sends a message to any MessageTarget (phone or BSUID/parent
 // BSUID). Phone targets serialize as `to`, BSUID/parent as `recipient`.
 func (mm *MessageManager) SendToTarget(message components.BaseMessage, target MessageTarget) (*MessageSendResponse, error) {
-	return mm.dispatch(message, target.configs(""), "messages")
+	return mm.dispatch(message, target.Configs(""), "messages")
 }
 
 // ReplyToTarget sends a reply (in-reply-to replyTo) to any MessageTarget.
 func (mm *MessageManager) ReplyToTarget(message components.BaseMessage, target MessageTarget, replyTo string) (*MessageSendResponse, error) {
-	return mm.dispatch(message, target.configs(replyTo), "messages")
+	return mm.dispatch(message, target.Configs(replyTo), "messages")
 }
 
 // SendMarketingMessageToTarget sends via the MM Lite API to any MessageTarget.
 // Only works if the WABA is eligible and onboarded on the MM Lite API.
 func (mm *MessageManager) SendMarketingMessageToTarget(message components.BaseMessage, target MessageTarget) (*MessageSendResponse, error) {
-	return mm.dispatch(message, target.configs(""), "marketing_messages")
+	return mm.dispatch(message, target.Configs(""), "marketing_messages")
 }
 
 // Reply sends a reply message to a phone number. Backward-compatible wrapper
@@ -135,45 +213,7 @@ func (mm *MessageManager) SendMarketingMessage(message components.BaseMessage, p
 // messageId: The ID of the message to mark as read
 // showTyping: Whether to show typing indicator (will auto-dismiss after 25 seconds or when you respond)
 func (mm *MessageManager) readMessage(messageId string, showTyping bool) error {
-	// Create the request body for marking message as read
-	requestBody := map[string]interface{}{
-		"messaging_product": "whatsapp",
-		"status":            "read",
-		"message_id":        messageId,
-	}
-
-	// Add typing indicator if requested
-	if showTyping {
-		requestBody["typing_indicator"] = map[string]string{
-			"type": "text",
-		}
-	}
-
-	body, err := json.Marshal(requestBody)
-	if err != nil {
-		return fmt.Errorf("error marshalling read request body: %v", err)
-	}
-
-	// Build the API request
-	apiRequest := mm.requester.NewApiRequest(strings.Join([]string{mm.PhoneNumberId, "messages"}, "/"), http.MethodPost)
-	apiRequest.SetBody(string(body))
-	responseStr, err := apiRequest.Execute()
-	if err != nil {
-		return fmt.Errorf("error executing read message request: %v", err)
-	}
-
-	// Parse the response to check for errors
-	var statusResponse StatusResponse
-	err = json.Unmarshal([]byte(responseStr), &statusResponse)
-	if err != nil {
-		return fmt.Errorf("error unmarshalling read response: %v", err)
-	}
-
-	if statusResponse.Error != nil {
-		return fmt.Errorf("error marking message as read: %s", statusResponse.Error.Message)
-	}
-
-	return nil
+	return message_dispatch.MarkRead(mm.ctx, mm.requester, mm.PhoneNumberId, messageId, showTyping)
 }
 
 // ReadMessageWithTyping marks a message as read and shows typing indicator.
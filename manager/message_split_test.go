@@ -0,0 +1,131 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/wapikit/wapi.go/pkg/components"
+)
+
+func TestSendSplitsLongTextAsThread(t *testing.T) {
+	var mu sync.Mutex
+	var sent []map[string]any
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, body)
+		return http.StatusOK, fmt.Sprintf(`{"messages":[{"id":"wamid.part%d"}]}`, len(sent))
+	})
+	paragraph := strings.Repeat("Lorem ipsum dolor sit amet. ", 50)
+	text := strings.TrimSpace(strings.Repeat(paragraph+"\n\n", 6))
+	message, _ := components.NewTextMessage(components.TextMessageConfigs{Text: text})
+	mm := newTestMessageManager()
+	mm.SetSplitLongMessages(true)
+
+	response, err := mm.Reply(message, "911", "wamid.question")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) < 3 || len(response.Messages) != len(sent) || response.Messages[len(sent)-1].ID != fmt.Sprintf("wamid.part%d", len(sent)) {
+		t.Fatalf("%d parts sent, response %+v", len(sent), response)
+	}
+	var bodies []string
+	for i, part := range sent {
+		body := part["text"].(map[string]any)["body"].(string)
+		if utf8.RuneCountInString(body) > components.MaxTextBodyLength || !strings.HasSuffix(body, ".") {
+			t.Fatalf("part %d is %d characters, ends %q", i, utf8.RuneCountInString(body), body[len(body)-5:])
+		}
+		if _, quotes := part["context"]; quotes != (i == 0) {
+			t.Fatalf("part %d context: %v", i, part["context"])
+		}
+		bodies = append(bodies, body)
+	}
+	if strings.Join(strings.Fields(strings.Join(bodies, " ")), " ") != strings.Join(strings.Fields(text), " ") {
+		t.Fatal("parts do not add up to the text")
+	}
+}
+
+func TestSendSplitsLongCaption(t *testing.T) {
+	var mu sync.Mutex
+	var types []any
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		types = append(types, body["type"])
+		return sentTo(body)
+	})
+	image, _ := components.NewImageMessage(components.ImageMessageConfigs{Id: "media-1", Caption: strings.Repeat("word ", 300)})
+	mm := newTestMessageManager()
+	if _, err := mm.Send(image, "911"); err != nil {
+		t.Fatal(err)
+	}
+	mm.SetSplitLongMessages(true)
+	if _, err := mm.Send(image, "911"); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(types) != "[image image text]" {
+		t.Fatalf("sent %v", types)
+	}
+}
+
+func TestSplitTextChecksTheServiceWindowOnce(t *testing.T) {
+	var mu sync.Mutex
+	var types []any
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		types = append(types, body["type"])
+		return sentTo(body)
+	})
+	tracker := NewServiceWindowTracker(nil)
+	tracker.store.Put("911", time.Now().Add(-time.Minute))
+	template, _ := components.NewTemplateMessage(&components.TemplateMessageConfigs{Name: "reopen", Language: "en_US"})
+	limits := NewMessagingLimitTracker(nil, MessagingLimitConfig{})
+	text, _ := components.NewTextMessage(components.TextMessageConfigs{Text: strings.Repeat("Lorem ipsum dolor sit amet. ", 400)})
+
+	mm := newTestMessageManager()
+	mm.SetSplitLongMessages(true)
+	mm.SetMessagingLimits(limits)
+	mm.SetServiceWindow(ServiceWindowConfig{Tracker: tracker, FallbackTemplate: template})
+	if _, err := mm.Send(text, "911"); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(types) != "[template]" {
+		t.Fatalf("fallback: sent %v", types)
+	}
+
+	store := NewMemoryReengagementStore()
+	mm = newTestMessageManager()
+	mm.SetSplitLongMessages(true)
+	mm.SetServiceWindow(ServiceWindowConfig{Tracker: tracker})
+	mm.SetReengagementPolicy(NewEventManager(), ReengagementPolicy{FallbackTemplate: template, Store: store})
+	if _, err := mm.Reply(text, "911", "wamid.old"); err != nil {
+		t.Fatal(err)
+	}
+	queued, _ := store.Take("911")
+	if fmt.Sprint(types) != "[template template]" || len(queued) < 3 {
+		t.Fatalf("reengagement: sent %v, queued %d", types, len(queued))
+	}
+	var texts []string
+	for i, message := range queued {
+		if (message.Configs.ReplyToMessageId != "") != (i == 0) {
+			t.Fatalf("part %d replies to %q", i, message.Configs.ReplyToMessageId)
+		}
+		var body struct {
+			Text struct {
+				Body string `json:"body"`
+			} `json:"text"`
+		}
+		json.Unmarshal(message.Body, &body)
+		texts = append(texts, body.Text.Body)
+	}
+	if strings.Join(strings.Fields(strings.Join(texts, " ")), " ") != strings.TrimSpace(text.Text) {
+		t.Fatal("queued parts do not add up to the text in order")
+	}
+}
@@ -0,0 +1,459 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+var (
+	// ErrMessagingLimitReached is wrapped by the error of a template send that a
+	// blocking MessagingLimitTracker refused because it would open a
+	// conversation with one more unique recipient than the tier allows.
+	ErrMessagingLimitReached = errors.New("messaging limit reached")
+	// ErrNoPhoneNumberManager is returned by RefreshTier when the tracker has
+	// no PhoneNumberManager to fetch tiers with.
+	ErrNoPhoneNumberManager = errors.New("no phone number manager configured")
+)
+
+// DefaultMessagingLimitWindow is the rolling window the messaging limit
+// counts unique recipients in.
+const DefaultMessagingLimitWindow = 24 * time.Hour
+
+// MessagingLimitOf returns the number of unique recipients a tier allows in
+// the rolling window, or 0 for TIER_UNLIMITED and unknown tiers.
+func MessagingLimitOf(tier WhatsappBusinessAccountPhoneNumberMessagingLimitTier) int {
+	switch tier {
+	case WhatsappBusinessAccountPhoneNumberMessagingLimitTierTier50:
+		return 50
+	case WhatsappBusinessAccountPhoneNumberMessagingLimitTierTier250:
+		return 250
+	case WhatsappBusinessAccountPhoneNumberMessagingLimitTierTier1K:
+		return 1000
+	case WhatsappBusinessAccountPhoneNumberMessagingLimitTierTier10K:
+		return 10000
+	case WhatsappBusinessAccountPhoneNumberMessagingLimitTierTier100K:
+		return 100000
+	}
+	return 0
+}
+
+// MessagingLimitSend is the latest business-initiated send to a recipient.
+type MessagingLimitSend struct {
+	PhoneNumberId string    `json:"phone_number_id"`
+	Recipient     string    `json:"recipient"`
+	SentAt        time.Time `json:"sent_at"`
+}
+
+// MessagingLimitStore keeps the latest business-initiated send to every
+// recipient of a phone number. Implementations must be safe for concurrent
+// use.
+type MessagingLimitStore interface {
+	// Record notes a send, replacing the recipient's earlier one.
+	Record(send MessagingLimitSend) error
+	// LastSent returns the time of the latest send to the recipient, or the
+	// zero time when there is none.
+	LastSent(phoneNumberId, recipient string) (time.Time, error)
+	// Count returns the number of recipients sent to at or after since. Sends
+	// before since may be dropped.
+	Count(phoneNumberId string, since time.Time) (int, error)
+}
+
+// MemoryMessagingLimitStore keeps sends in memory. It is the default store of
+// a MessagingLimitTracker.
+type MemoryMessagingLimitStore struct {
+	mu    sync.Mutex
+	sends map[string]map[string]time.Time // sends maps phone number id to recipient to time.
+}
+
+// NewMemoryMessagingLimitStore creates an empty in-memory store.
+func NewMemoryMessagingLimitStore() *MemoryMessagingLimitStore {
+	return &MemoryMessagingLimitStore{sends: make(map[string]map[string]time.Time)}
+}
+
+func (store *MemoryMessagingLimitStore) Record(send MessagingLimitSend) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.record(send)
+	return nil
+}
+
+func (store *MemoryMessagingLimitStore) record(send MessagingLimitSend) {
+	recipients, ok := store.sends[send.PhoneNumberId]
+	if !ok {
+		recipients = make(map[string]time.Time)
+		store.sends[send.PhoneNumberId] = recipients
+	}
+	if send.SentAt.After(recipients[send.Recipient]) {
+		recipients[send.Recipient] = send.SentAt
+	}
+}
+
+func (store *MemoryMessagingLimitStore) LastSent(phoneNumberId, recipient string) (time.Time, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.sends[phoneNumberId][recipient], nil
+}
+
+func (store *MemoryMessagingLimitStore) Count(phoneNumberId string, since time.Time) (int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	recipients := store.sends[phoneNumberId]
+	for recipient, sentAt := range recipients {
+		if sentAt.Before(since) {
+			delete(recipients, recipient)
+		}
+	}
+	return len(recipients), nil
+}
+
+// FileMessagingLimitStore keeps sends in a JSON lines file so the count
+// survives restarts. Every send appends a line; Compact rewrites the file with
+// the latest send per recipient.
+type FileMessagingLimitStore struct {
+	path   string
+	memory *MemoryMessagingLimitStore
+}
+
+// NewFileMessagingLimitStore opens (or creates) the store at path and loads
+// the sends already in it.
+func NewFileMessagingLimitStore(path string) (*FileMessagingLimitStore, error) {
+	store := &FileMessagingLimitStore{path: path, memory: NewMemoryMessagingLimitStore()}
+	err := readJsonLines(path, func(send MessagingLimitSend) {
+		store.memory.record(send)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *FileMessagingLimitStore) Record(send MessagingLimitSend) error {
+	store.memory.mu.Lock()
+	defer store.memory.mu.Unlock()
+	if err := appendJsonLine(store.path, send); err != nil {
+		return err
+	}
+	store.memory.record(send)
+	return nil
+}
+
+func (store *FileMessagingLimitStore) LastSent(phoneNumberId, recipient string) (time.Time, error) {
+	return store.memory.LastSent(phoneNumberId, recipient)
+}
+
+func (store *FileMessagingLimitStore) Count(phoneNumberId string, since time.Time) (int, error) {
+	return store.memory.Count(phoneNumberId, since)
+}
+
+// Compact rewrites the file with the latest send to every recipient.
+func (store *FileMessagingLimitStore) Compact() error {
+	store.memory.mu.Lock()
+	defer store.memory.mu.Unlock()
+	var sends []MessagingLimitSend
+	for phoneNumberId, recipients := range store.memory.sends {
+		for recipient, sentAt := range recipients {
+			sends = append(sends, MessagingLimitSend{PhoneNumberId: phoneNumberId, Recipient: recipient, SentAt: sentAt})
+		}
+	}
+	sort.Slice(sends, func(i, j int) bool { return sends[i].SentAt.Before(sends[j].SentAt) })
+	return rewriteJsonLines(store.path, sends)
+}
+
+// MessagingLimitConfig configures a MessagingLimitTracker.
+type MessagingLimitConfig struct {
+	// Thresholds are the fractions of the limit at which a
+	// MessagingLimitWarningEvent is published. Defaults to 0.8, 0.9 and 1.
+	Thresholds []float64
+	// Block refuses template sends that would exceed the limit with an error
+	// wrapping ErrMessagingLimitReached. By default they are only counted.
+	Block bool
+	// Window is the rolling window unique recipients are counted in. Defaults
+	// to DefaultMessagingLimitWindow.
+	Window time.Duration
+	// PhoneNumbers fetches the tiers for RefreshTier, on the first send of a
+	// phone number without a tier and on phone number quality updates.
+	PhoneNumbers *PhoneNumberManager
+	// TierRetryInterval is how long sends of a phone number whose tier could
+	// not be fetched wait before fetching it again; the ones in between go
+	// out with the tier unknown. Defaults to 1 minute.
+	TierRetryInterval time.Duration
+}
+
+// MessagingLimitUsage is the state of a phone number's messaging limit.
+type MessagingLimitUsage struct {
+	PhoneNumberId string
+	Tier          WhatsappBusinessAccountPhoneNumberMessagingLimitTier
+	Limit         int // Limit is 0 when the tier is unknown or unlimited.
+	Used          int // Used is the number of unique recipients in the window.
+}
+
+// MessagingLimitTracker counts the unique recipients of the template sends of
+// every phone number over a rolling window and compares them against the
+// messaging limit tier of the number. Template sends are fed to it by the
+// MessageManagers it is set on with SetMessagingLimits.
+type MessagingLimitTracker struct {
+	mu           sync.Mutex
+	store        MessagingLimitStore
+	config       MessagingLimitConfig
+	tiers        map[string]WhatsappBusinessAccountPhoneNumberMessagingLimitTier
+	pending      map[string]map[string]int // pending counts the sends in flight per phone number and recipient.
+	fetchedAt    map[string]time.Time      // fetchedAt holds the time of the last tier fetch per phone number, until one succeeds.
+	eventManager *EventManager
+}
+
+// NewMessagingLimitTracker creates a tracker keeping its sends in store, or in
+// memory when store is nil. Set the tiers with SetTier or RefreshTier, or
+// configure PhoneNumbers to fetch them on the first send of a phone number;
+// phone numbers without a tier are counted but never warned about or blocked.
+func NewMessagingLimitTracker(store MessagingLimitStore, config MessagingLimitConfig) *MessagingLimitTracker {
+	if store == nil {
+		store = NewMemoryMessagingLimitStore()
+	}
+	if config.Thresholds == nil {
+		config.Thresholds = []float64{0.8, 0.9, 1}
+	}
+	if config.Window <= 0 {
+		config.Window = DefaultMessagingLimitWindow
+	}
+	if config.TierRetryInterval <= 0 {
+		config.TierRetryInterval = time.Minute
+	}
+	return &MessagingLimitTracker{
+		store:     store,
+		config:    config,
+		tiers:     make(map[string]WhatsappBusinessAccountPhoneNumberMessagingLimitTier),
+		pending:   make(map[string]map[string]int),
+		fetchedAt: make(map[string]time.Time),
+	}
+}
+
+// SetTier sets the messaging limit tier of the phone number.
+func (tracker *MessagingLimitTracker) SetTier(phoneNumberId string, tier WhatsappBusinessAccountPhoneNumberMessagingLimitTier) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	tracker.tiers[phoneNumberId] = tier
+}
+
+// RefreshTier fetches the messaging limit tier of the phone number with the
+// configured PhoneNumberManager.
+func (tracker *MessagingLimitTracker) RefreshTier(phoneNumberId string) error {
+	if tracker.config.PhoneNumbers == nil {
+		return ErrNoPhoneNumberManager
+	}
+	phoneNumber, err := tracker.config.PhoneNumbers.Fetch(phoneNumberId)
+	if err != nil {
+		return fmt.Errorf("error fetching messaging limit tier: %w", err)
+	}
+	tracker.SetTier(phoneNumberId, phoneNumber.MessagingLimitTier)
+	return nil
+}
+
+// Attach makes the tracker publish its warnings on the event manager and
+// refresh the tiers of the phone numbers it knows on every phone number
+// quality update, when it has a PhoneNumberManager. Failed refreshes are
+// retried and dead-lettered under the handler name "messaging-limits". It
+// returns the subscription id to pass to Off.
+func (tracker *MessagingLimitTracker) Attach(eventManager *EventManager) SubscriptionId {
+	tracker.mu.Lock()
+	tracker.eventManager = eventManager
+	tracker.mu.Unlock()
+	isQualityUpdate := func(_ events.EventType, data events.BaseEvent) bool {
+		_, ok := data.(*events.PhoneNumberQualityUpdateEvent)
+		return ok
+	}
+	return eventManager.HandleMatch(isQualityUpdate, tracker.Observe, HandlerOptions{
+		Name:  "messaging-limits",
+		Retry: RetryPolicy{MaxAttempts: 3},
+	})
+}
+
+// Observe refreshes every known tier on a phone number quality update, which
+// is how tier upgrades and downgrades are announced. Other events are ignored.
+func (tracker *MessagingLimitTracker) Observe(event events.BaseEvent) error {
+	if _, ok := event.(*events.PhoneNumberQualityUpdateEvent); !ok || tracker.config.PhoneNumbers == nil {
+		return nil
+	}
+	tracker.mu.Lock()
+	phoneNumberIds := make([]string, 0, len(tracker.tiers))
+	for phoneNumberId := range tracker.tiers {
+		phoneNumberIds = append(phoneNumberIds, phoneNumberId)
+	}
+	tracker.mu.Unlock()
+	var errs []error
+	for _, phoneNumberId := range phoneNumberIds {
+		errs = append(errs, tracker.RefreshTier(phoneNumberId))
+	}
+	return errors.Join(errs...)
+}
+
+// Usage returns the tier, limit and unique recipients in the window of the
+// phone number.
+func (tracker *MessagingLimitTracker) Usage(phoneNumberId string) (MessagingLimitUsage, error) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	used, err := tracker.store.Count(phoneNumberId, time.Now().Add(-tracker.config.Window))
+	if err != nil {
+		return MessagingLimitUsage{}, err
+	}
+	tier := tracker.tiers[phoneNumberId]
+	return MessagingLimitUsage{PhoneNumberId: phoneNumberId, Tier: tier, Limit: MessagingLimitOf(tier), Used: used}, nil
+}
+
+// Record counts a template send to the recipient made outside a
+// MessageManager and publishes the warnings it crosses.
+func (tracker *MessagingLimitTracker) Record(phoneNumberId, recipient string) error {
+	release, err := tracker.reserve(phoneNumberId, recipient, false)
+	if err != nil {
+		return err
+	}
+	return release(true)
+}
+
+// reserve counts a send to the recipient as in flight, refusing it when block
+// is set and it would exceed the limit with the new recipients already in
+// flight. The returned release ends the send; a sent one is recorded. Sends to
+// recipients already in the window, or in flight, do not count again.
+func (tracker *MessagingLimitTracker) reserve(phoneNumberId, recipient string, block bool) (func(sent bool) error, error) {
+	tracker.fetchTier(phoneNumberId)
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	since := time.Now().Add(-tracker.config.Window)
+	if limit := MessagingLimitOf(tracker.tiers[phoneNumberId]); block && limit > 0 && tracker.pending[phoneNumberId][recipient] == 0 {
+		isNew, err := tracker.isNew(phoneNumberId, recipient, since)
+		if err != nil {
+			return nil, err
+		}
+		used, err := tracker.store.Count(phoneNumberId, since)
+		if err != nil {
+			return nil, err
+		}
+		for inFlight := range tracker.pending[phoneNumberId] {
+			if newInFlight, err := tracker.isNew(phoneNumberId, inFlight, since); err != nil {
+				return nil, err
+			} else if newInFlight {
+				used++
+			}
+		}
+		if isNew && used >= limit {
+			return nil, fmt.Errorf("%w: %d of %d unique recipients of %s in the last %s", ErrMessagingLimitReached, used, limit, tracker.tiers[phoneNumberId], tracker.config.Window)
+		}
+	}
+	if tracker.pending[phoneNumberId] == nil {
+		tracker.pending[phoneNumberId] = make(map[string]int)
+	}
+	tracker.pending[phoneNumberId][recipient]++
+	return func(sent bool) error {
+		tracker.mu.Lock()
+		if tracker.pending[phoneNumberId][recipient]--; tracker.pending[phoneNumberId][recipient] == 0 {
+			delete(tracker.pending[phoneNumberId], recipient)
+		}
+		if !sent {
+			tracker.mu.Unlock()
+			return nil
+		}
+		warnings, err := tracker.record(phoneNumberId, recipient)
+		eventManager := tracker.eventManager
+		tracker.mu.Unlock()
+		for _, warning := range warnings {
+			if err := eventManager.Publish(events.MessagingLimitWarningEventType, warning); err != nil {
+				fmt.Println("Error publishing messaging limit warning:", err)
+			}
+		}
+		return err
+	}, nil
+}
+
+// fetchTier fetches the tier of a phone number the tracker has none for, when
+// it has a PhoneNumberManager. Only one send fetches at a time, and after a
+// failed fetch the sends of the next TierRetryInterval do not try again.
+func (tracker *MessagingLimitTracker) fetchTier(phoneNumberId string) {
+	if tracker.config.PhoneNumbers == nil {
+		return
+	}
+	tracker.mu.Lock()
+	_, known := tracker.tiers[phoneNumberId]
+	fetchedAt, fetched := tracker.fetchedAt[phoneNumberId]
+	if known || (fetched && time.Since(fetchedAt) < tracker.config.TierRetryInterval) {
+		tracker.mu.Unlock()
+		return
+	}
+	tracker.fetchedAt[phoneNumberId] = time.Now()
+	tracker.mu.Unlock()
+
+	if err := tracker.RefreshTier(phoneNumberId); err != nil {
+		fmt.Println("Error fetching messaging limit tier:", err)
+		return
+	}
+	tracker.mu.Lock()
+	delete(tracker.fetchedAt, phoneNumberId)
+	tracker.mu.Unlock()
+}
+
+// isNew reports whether the recipient was not sent to since the given time.
+func (tracker *MessagingLimitTracker) isNew(phoneNumberId, recipient string, since time.Time) (bool, error) {
+	lastSent, err := tracker.store.LastSent(phoneNumberId, recipient)
+	return lastSent.Before(since), err
+}
+
+// record stores a send and returns the warnings of the thresholds it crosses,
+// to be published once the lock is released. It is called with the lock held.
+func (tracker *MessagingLimitTracker) record(phoneNumberId, recipient string) ([]*events.MessagingLimitWarningEvent, error) {
+	now := time.Now()
+	since := now.Add(-tracker.config.Window)
+	isNew, err := tracker.isNew(phoneNumberId, recipient, since)
+	if err != nil {
+		return nil, err
+	}
+	if err := tracker.store.Record(MessagingLimitSend{PhoneNumberId: phoneNumberId, Recipient: recipient, SentAt: now}); err != nil {
+		return nil, err
+	}
+	tier := tracker.tiers[phoneNumberId]
+	limit := MessagingLimitOf(tier)
+	if !isNew || limit == 0 || tracker.eventManager == nil {
+		return nil, nil
+	}
+	used, err := tracker.store.Count(phoneNumberId, since)
+	if err != nil {
+		return nil, err
+	}
+	var warnings []*events.MessagingLimitWarningEvent
+	for _, threshold := range tracker.config.Thresholds {
+		if boundary := int(math.Ceil(threshold * float64(limit))); used-1 < boundary && boundary <= used {
+			warnings = append(warnings, events.NewMessagingLimitWarningEvent(events.BaseSystemEvent{
+				Timestamp: fmt.Sprint(now.Unix()),
+			}, phoneNumberId, string(tier), limit, used, threshold))
+		}
+	}
+	return warnings, nil
+}
+
+// SetMessagingLimits makes the manager count the recipients of its template
+// sends, including fallback templates, in the tracker, and refuse those that
+// would exceed the limit when the tracker blocks. Sends the outbox and the
+// scheduler make through the manager are counted as well.
+func (mm *MessageManager) SetMessagingLimits(tracker *MessagingLimitTracker) {
+	mm.messagingLimits = tracker
+}
+
+// reserveMessagingLimit reserves a template send to the recipient, returning a
+// release that does nothing when no tracker is set.
+func (mm *MessageManager) reserveMessagingLimit(recipient string) (func(sent bool), error) {
+	if mm.messagingLimits == nil {
+		return func(bool) {}, nil
+	}
+	release, err := mm.messagingLimits.reserve(mm.PhoneNumberId, recipient, mm.messagingLimits.config.Block)
+	if err != nil {
+		return nil, err
+	}
+	return func(sent bool) {
+		if err := release(sent); err != nil {
+			fmt.Println("Error recording messaging limit:", err)
+		}
+	}, nil
+}
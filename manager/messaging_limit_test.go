@@ -0,0 +1,181 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/wapikit/wapi.go/internal/request_client"
+	"github.com/wapikit/wapi.go/pkg/components"
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+func TestMessagingLimitWarnsAndBlocksNewRecipients(t *testing.T) {
+	var tier atomic.Value
+	tier.Store("TIER_50")
+	var sends atomic.Int32
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		if request.Method == http.MethodGet {
+			return http.StatusOK, fmt.Sprintf(`{"id":"pn-1","messaging_limit_tier":"%s"}`, tier.Load())
+		}
+		sends.Add(1)
+		return sentTo(body)
+	})
+	store, err := NewFileMessagingLimitStore(filepath.Join(t.TempDir(), "limits.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	em := NewEventManager()
+	var mu sync.Mutex
+	var warnings []*events.MessagingLimitWarningEvent
+	em.On(events.MessagingLimitWarningEventType, func(e events.BaseEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		warnings = append(warnings, e.(*events.MessagingLimitWarningEvent))
+	})
+	requester := request_client.NewRequestClient("token")
+	tracker := NewMessagingLimitTracker(store, MessagingLimitConfig{
+		Block:        true,
+		PhoneNumbers: NewPhoneNumberManager(&PhoneNumberManagerConfig{Requester: requester}),
+	})
+	tracker.Attach(em)
+	if err := tracker.RefreshTier("pn-1"); err != nil {
+		t.Fatal(err)
+	}
+	mm := newTestMessageManager()
+	mm.SetMessagingLimits(tracker)
+
+	template, _ := components.NewTemplateMessage(&components.TemplateMessageConfigs{Name: "order_update", Language: "en"})
+	for i := 0; i < 50; i++ {
+		if _, err := mm.Send(template, fmt.Sprint(1000+i)); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+	if _, err := mm.Send(template, "1000"); err != nil {
+		t.Fatalf("repeat recipient: %v", err)
+	}
+	text, _ := components.NewTextMessage(components.TextMessageConfigs{Text: "hi"})
+	if _, err := mm.Send(text, "2000"); err != nil {
+		t.Fatalf("free-form send: %v", err)
+	}
+	if _, err := mm.Send(template, "2001"); !errors.Is(err, ErrMessagingLimitReached) {
+		t.Fatalf("over the limit: %v", err)
+	}
+	if sends.Load() != 52 {
+		t.Fatalf("%d sends reached the API", sends.Load())
+	}
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(warnings) == 3
+	})
+	if warnings[0].Used != 40 || warnings[1].Used != 45 || warnings[2].Used != 50 || warnings[2].Threshold != 1 || warnings[2].Tier != "TIER_50" {
+		t.Fatalf("warnings %+v %+v %+v", warnings[0], warnings[1], warnings[2])
+	}
+
+	tier.Store("TIER_250")
+	postWebhook(t, newTestWebhook(em), `{"object":"whatsapp_business_account","entry":[{"id":"waba-1","time":1,"changes":[{"field":"phone_number_quality",
+		"value":{"display_phone_number":"1","event":"UPGRADE","current_limit":"TIER_250"}}]}]}`)
+	waitFor(t, func() bool {
+		usage, _ := tracker.Usage("pn-1")
+		return usage.Limit == 250
+	})
+	if _, err := mm.Send(template, "2001"); err != nil {
+		t.Fatalf("after the upgrade: %v", err)
+	}
+
+	reopened, err := NewFileMessagingLimitStore(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used, _ := reopened.Count("pn-1", time.Now().Add(-time.Hour)); used != 51 {
+		t.Fatalf("reopened store counts %d recipients", used)
+	}
+}
+
+func TestMessagingLimitFetchesTheTierOnTheFirstSend(t *testing.T) {
+	var fetches atomic.Int32
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		if request.Method == http.MethodGet {
+			fetches.Add(1)
+			return http.StatusOK, `{"id":"pn-1","messaging_limit_tier":"TIER_50"}`
+		}
+		return sentTo(body)
+	})
+	em := NewEventManager()
+	tracker := NewMessagingLimitTracker(nil, MessagingLimitConfig{
+		Thresholds:   []float64{0.02},
+		PhoneNumbers: NewPhoneNumberManager(&PhoneNumberManagerConfig{Requester: request_client.NewRequestClient("token")}),
+	})
+	tracker.Attach(em)
+	usages := make(chan MessagingLimitUsage, 1)
+	em.On(events.MessagingLimitWarningEventType, func(e events.BaseEvent) {
+		usage, _ := tracker.Usage("pn-1")
+		usages <- usage
+	})
+	mm := newTestMessageManager()
+	mm.SetMessagingLimits(tracker)
+
+	template, _ := components.NewTemplateMessage(&components.TemplateMessageConfigs{Name: "order_update", Language: "en"})
+	for _, recipient := range []string{"1000", "1001"} {
+		if _, err := mm.Send(template, recipient); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case usage := <-usages:
+		if usage.Limit != 50 {
+			t.Fatalf("usage %+v", usage)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no warning")
+	}
+	if fetches.Load() != 1 {
+		t.Fatalf("tier fetched %d times", fetches.Load())
+	}
+}
+
+func TestMessagingLimitBacksOffFailedTierFetches(t *testing.T) {
+	var fetches atomic.Int32
+	var down atomic.Bool
+	down.Store(true)
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		if request.Method == http.MethodGet {
+			fetches.Add(1)
+			if down.Load() {
+				return http.StatusServiceUnavailable, `{"error":{"message":"down","code":2}}`
+			}
+			return http.StatusOK, `{"id":"pn-1","messaging_limit_tier":"TIER_250"}`
+		}
+		return sentTo(body)
+	})
+	tracker := NewMessagingLimitTracker(nil, MessagingLimitConfig{
+		PhoneNumbers:      NewPhoneNumberManager(&PhoneNumberManagerConfig{Requester: request_client.NewRequestClient("token")}),
+		TierRetryInterval: 50 * time.Millisecond,
+	})
+	mm := newTestMessageManager()
+	mm.SetMessagingLimits(tracker)
+
+	template, _ := components.NewTemplateMessage(&components.TemplateMessageConfigs{Name: "order_update", Language: "en"})
+	for i := 0; i < 3; i++ {
+		if _, err := mm.Send(template, fmt.Sprint(1000+i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fetches.Load() != 1 {
+		t.Fatalf("tier fetched %d times during the outage", fetches.Load())
+	}
+	down.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	if _, err := mm.Send(template, "2000"); err != nil {
+		t.Fatal(err)
+	}
+	if usage, _ := tracker.Usage("pn-1"); fetches.Load() != 2 || usage.Limit != 250 {
+		t.Fatalf("%d fetches, usage %+v", fetches.Load(), usage)
+	}
+}
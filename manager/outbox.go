@@ -0,0 +1,528 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/wapikit/wapi.go/internal/request_client"
+	"github.com/wapikit/wapi.go/pkg/components"
+)
+
+// ErrOutboxEntryNotFound is returned by OutboxStore.Get for an unknown key.
+var ErrOutboxEntryNotFound = errors.New("outbox entry not found")
+
+// ErrNoOutbox is returned by MessageManager.Enqueue when no outbox is set.
+var ErrNoOutbox = errors.New("no outbox configured")
+
+// DefaultOutboxPath is the file NewOutbox stores entries in when it is given
+// neither a store nor OutboxConfig.Path.
+const DefaultOutboxPath = "wapi-outbox.jsonl"
+
+// OutboxEntryState is the state of a queued send.
+type OutboxEntryState string
+
+const (
+	OutboxEntryStatePending OutboxEntryState = "pending" // OutboxEntryStatePending entries are sent, or retried, by Run.
+	OutboxEntryStateSent    OutboxEntryState = "sent"    // OutboxEntryStateSent entries were accepted by the API; MessageId is set.
+	OutboxEntryStateFailed  OutboxEntryState = "failed"  // OutboxEntryStateFailed entries were refused or ran out of retries.
+)
+
+// OutboxEntry is one queued send. The message is stored already converted to
+// its API body, next to the configs it was converted with.
+type OutboxEntry struct {
+	Key           string                                       `json:"key"` // Key is the caller's idempotency key.
+	PhoneNumberId string                                       `json:"phone_number_id"`
+	Endpoint      string                                       `json:"endpoint"`
+	Configs       components.ApiCompatibleJsonConverterConfigs `json:"configs"`
+	Body          json.RawMessage                              `json:"body"`
+	Template      bool                                         `json:"template,omitempty"`  // Template is set for template messages, which count against the messaging limit.
+	Marketing     bool                                         `json:"marketing,omitempty"` // Marketing is set for marketing templates, which need consent.
+	State         OutboxEntryState                             `json:"state"`
+	Attempts      int                                          `json:"attempts"`
+	LastError     string                                       `json:"last_error,omitempty"`
+	MessageId     string                                       `json:"message_id,omitempty"` // MessageId is the id Meta assigned to the sent message.
+	CreatedAt     time.Time                                    `json:"created_at"`
+	UpdatedAt     time.Time                                    `json:"updated_at"`
+	NextAttemptAt time.Time                                    `json:"next_attempt_at"`
+}
+
+// OutboxStore keeps outbox entries. Implementations must be safe for
+// concurrent use.
+type OutboxStore interface {
+	// Add stores a new entry. When an entry with the same key exists, it is
+	// returned unchanged with false and the new entry is dropped.
+	Add(entry OutboxEntry) (OutboxEntry, bool, error)
+	// Put replaces the entry with the same key.
+	Put(entry OutboxEntry) error
+	// Get returns the entry with the key or ErrOutboxEntryNotFound.
+	Get(key string) (OutboxEntry, error)
+	// Pending returns every pending entry, oldest first.
+	Pending() ([]OutboxEntry, error)
+}
+
+// MemoryOutboxStore keeps outbox entries in memory, e.g. for tests. Entries
+// do not survive a restart.
+type MemoryOutboxStore struct {
+	mu      sync.Mutex
+	order   []string
+	entries map[string]OutboxEntry
+}
+
+// NewMemoryOutboxStore creates an empty in-memory store.
+func NewMemoryOutboxStore() *MemoryOutboxStore {
+	return &MemoryOutboxStore{entries: make(map[string]OutboxEntry)}
+}
+
+func (store *MemoryOutboxStore) Add(entry OutboxEntry) (OutboxEntry, bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if existing, ok := store.entries[entry.Key]; ok {
+		return existing, false, nil
+	}
+	store.put(entry)
+	return entry, true, nil
+}
+
+func (store *MemoryOutboxStore) Put(entry OutboxEntry) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.put(entry)
+	return nil
+}
+
+func (store *MemoryOutboxStore) put(entry OutboxEntry) {
+	if _, ok := store.entries[entry.Key]; !ok {
+		store.order = append(store.order, entry.Key)
+	}
+	store.entries[entry.Key] = entry
+}
+
+func (store *MemoryOutboxStore) Get(key string) (OutboxEntry, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	entry, ok := store.entries[key]
+	if !ok {
+		return OutboxEntry{}, ErrOutboxEntryNotFound
+	}
+	return entry, nil
+}
+
+func (store *MemoryOutboxStore) Pending() ([]OutboxEntry, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	var pending []OutboxEntry
+	for _, key := range store.order {
+		if entry := store.entries[key]; entry.State == OutboxEntryStatePending {
+			pending = append(pending, entry)
+		}
+	}
+	return pending, nil
+}
+
+// FileOutboxStore keeps outbox entries in a JSON lines file so queued sends
+// survive restarts. Every change appends the whole entry, so the file grows
+// until it is compacted: Compact rewrites it with one line per entry, and
+// CompactBefore also drops the entries that finished long ago. An Outbox with
+// a Retention calls CompactBefore itself.
+type FileOutboxStore struct {
+	path   string
+	memory *MemoryOutboxStore
+}
+
+// NewFileOutboxStore opens (or creates) the store at path and loads the
+// entries already in it. A later line replaces an earlier one with the same
+// key.
+func NewFileOutboxStore(path string) (*FileOutboxStore, error) {
+	store := &FileOutboxStore{path: path, memory: NewMemoryOutboxStore()}
+	if err := readJsonLines(path, store.memory.put); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *FileOutboxStore) Add(entry OutboxEntry) (OutboxEntry, bool, error) {
+	store.memory.mu.Lock()
+	defer store.memory.mu.Unlock()
+	if existing, ok := store.memory.entries[entry.Key]; ok {
+		return existing, false, nil
+	}
+	if err := appendJsonLine(store.path, entry); err != nil {
+		return OutboxEntry{}, false, err
+	}
+	store.memory.put(entry)
+	return entry, true, nil
+}
+
+func (store *FileOutboxStore) Put(entry OutboxEntry) error {
+	store.memory.mu.Lock()
+	defer store.memory.mu.Unlock()
+	if err := appendJsonLine(store.path, entry); err != nil {
+		return err
+	}
+	store.memory.put(entry)
+	return nil
+}
+
+func (store *FileOutboxStore) Get(key string) (OutboxEntry, error) {
+	return store.memory.Get(key)
+}
+
+func (store *FileOutboxStore) Pending() ([]OutboxEntry, error) {
+	return store.memory.Pending()
+}
+
+// Compact rewrites the file with the latest state of every entry.
+func (store *FileOutboxStore) Compact() error {
+	store.memory.mu.Lock()
+	defer store.memory.mu.Unlock()
+	entries := make([]OutboxEntry, 0, len(store.memory.order))
+	for _, key := range store.memory.order {
+		entries = append(entries, store.memory.entries[key])
+	}
+	return rewriteJsonLines(store.path, entries)
+}
+
+// CompactBefore rewrites the file with the pending entries and the entries
+// sent or failed at or after before, and forgets the others, so their keys
+// can be enqueued again.
+func (store *FileOutboxStore) CompactBefore(before time.Time) error {
+	store.memory.mu.Lock()
+	defer store.memory.mu.Unlock()
+	var kept []OutboxEntry
+	for _, key := range store.memory.order {
+		if entry := store.memory.entries[key]; entry.State == OutboxEntryStatePending || !entry.UpdatedAt.Before(before) {
+			kept = append(kept, entry)
+		}
+	}
+	if err := rewriteJsonLines(store.path, kept); err != nil {
+		return err
+	}
+	store.memory.order = nil
+	store.memory.entries = make(map[string]OutboxEntry)
+	for _, entry := range kept {
+		store.memory.put(entry)
+	}
+	return nil
+}
+
+// OutboxConfig configures an Outbox.
+type OutboxConfig struct {
+	Path         string        // Path is the file of the default FileOutboxStore. Defaults to DefaultOutboxPath.
+	Workers      int           // Workers is the number of sends in flight at once. Defaults to 4.
+	Retry        RetryPolicy   // Retry applies to network errors, 429s and 5xx responses. MaxAttempts defaults to 5.
+	PollInterval time.Duration // PollInterval is how often Run looks for due retries. Defaults to 1 second.
+	// Retention is how long sent and failed entries are kept, and their keys
+	// stay idempotent, when the store has a CompactBefore method like
+	// FileOutboxStore. Run compacts the store hourly, or every Retention when
+	// shorter. By default entries are kept until the store is compacted.
+	Retention time.Duration
+	// OnResult is called when an entry is sent or has failed for good.
+	OnResult func(entry OutboxEntry)
+}
+
+// Outbox persists sends before they are made and drains them in Run, so sends
+// queued when the process stops or the Graph API is down are made later.
+// Entries are sent through the MessageManager of their phone number that was
+// given the outbox with SetOutbox, so they are paced, counted against the
+// messaging limit and recorded like its direct sends.
+//
+// Entries to the same recipient of a phone number are sent one at a time, in
+// the order they were enqueued: an entry waiting for a retry holds up the
+// ones enqueued after it. Entries to other recipients are sent in parallel by
+// the workers.
+//
+// Each entry has a caller-supplied idempotency key: enqueueing a key again
+// returns the existing entry instead of sending twice, also after the entry
+// was sent, until it is dropped by compaction (see OutboxConfig.Retention). A
+// process that dies between the API accepting a message and the store
+// recording it will send that entry once more on restart.
+type Outbox struct {
+	requester request_client.RequestClient
+	store     OutboxStore
+	config    OutboxConfig
+	wake      chan struct{}
+
+	mu       sync.Mutex
+	inFlight map[string]bool
+	managers map[string]*MessageManager // managers send the entries of their phone number id; see SetOutbox.
+}
+
+// NewOutbox creates an outbox sending with requester and persisting to store,
+// or to a FileOutboxStore at config.Path when store is nil.
+func NewOutbox(requester request_client.RequestClient, store OutboxStore, config OutboxConfig) (*Outbox, error) {
+	if store == nil {
+		path := config.Path
+		if path == "" {
+			path = DefaultOutboxPath
+		}
+		fileStore, err := NewFileOutboxStore(path)
+		if err != nil {
+			return nil, err
+		}
+		store = fileStore
+	}
+	if config.Workers <= 0 {
+		config.Workers = 4
+	}
+	if config.Retry.MaxAttempts == 0 {
+		config.Retry.MaxAttempts = 5
+	}
+	config.Retry = config.Retry.withDefaults()
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Second
+	}
+	return &Outbox{
+		requester: requester,
+		store:     store,
+		config:    config,
+		wake:      make(chan struct{}, 1),
+		inFlight:  make(map[string]bool),
+		managers:  make(map[string]*MessageManager),
+	}, nil
+}
+
+// Store returns the store the outbox persists to.
+func (outbox *Outbox) Store() OutboxStore {
+	return outbox.store
+}
+
+// Get returns the entry with the key or ErrOutboxEntryNotFound.
+func (outbox *Outbox) Get(key string) (OutboxEntry, error) {
+	return outbox.store.Get(key)
+}
+
+// enqueue converts the message and persists it under key.
+func (outbox *Outbox) enqueue(key, phoneNumberId string, message components.BaseMessage, configs components.ApiCompatibleJsonConverterConfigs, endpoint string) (OutboxEntry, error) {
+	if key == "" {
+		return OutboxEntry{}, errors.New("outbox entries need an idempotency key")
+	}
+	if existing, err := outbox.store.Get(key); err == nil {
+		return existing, nil
+	}
+	body, err := message.ToJson(configs)
+	if err != nil {
+		return OutboxEntry{}, fmt.Errorf("error converting message to json: %v", err)
+	}
+	_, template := message.(*components.TemplateMessage)
+	return outbox.enqueueBody(key, phoneNumberId, body, template, components.IsMarketing(message), configs, endpoint)
+}
+
+// enqueueBody persists an already converted message under key.
+func (outbox *Outbox) enqueueBody(key, phoneNumberId string, body []byte, template, marketing bool, configs components.ApiCompatibleJsonConverterConfigs, endpoint string) (OutboxEntry, error) {
+	now := time.Now()
+	entry, added, err := outbox.store.Add(OutboxEntry{
+		Key:           key,
+		PhoneNumberId: phoneNumberId,
+		Endpoint:      endpoint,
+		Configs:       configs,
+		Body:          body,
+		Template:      template,
+		Marketing:     marketing,
+		State:         OutboxEntryStatePending,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		NextAttemptAt: now,
+	})
+	if err != nil {
+		return OutboxEntry{}, err
+	}
+	if added {
+		select {
+		case outbox.wake <- struct{}{}:
+		default:
+		}
+	}
+	return entry, nil
+}
+
+// Run sends pending entries until ctx is done and returns the context error.
+// Entries left pending by a previous process are sent first.
+func (outbox *Outbox) Run(ctx context.Context) error {
+	work := make(chan OutboxEntry)
+	var workers sync.WaitGroup
+	for i := 0; i < outbox.config.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for entry := range work {
+				outbox.deliver(ctx, entry)
+				outbox.mu.Lock()
+				delete(outbox.inFlight, entry.Key)
+				outbox.mu.Unlock()
+			}
+		}()
+	}
+	defer workers.Wait()
+	defer close(work)
+
+	ticker := time.NewTicker(outbox.config.PollInterval)
+	defer ticker.Stop()
+	var compact <-chan time.Time
+	compactor, ok := outbox.store.(interface{ CompactBefore(time.Time) error })
+	if ok && outbox.config.Retention > 0 {
+		compactTicker := time.NewTicker(min(outbox.config.Retention, time.Hour))
+		defer compactTicker.Stop()
+		compact = compactTicker.C
+	}
+	for {
+		if err := outbox.dispatchDue(ctx, work); err != nil {
+			fmt.Println("Error reading outbox:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		case <-outbox.wake:
+		case <-compact:
+			if err := compactor.CompactBefore(time.Now().Add(-outbox.config.Retention)); err != nil {
+				fmt.Println("Error compacting outbox:", err)
+			}
+		}
+	}
+}
+
+// dispatchDue hands every due pending entry that is not in flight to a
+// worker, unless an entry to the same recipient enqueued before it is still
+// pending.
+func (outbox *Outbox) dispatchDue(ctx context.Context, work chan<- OutboxEntry) error {
+	pending, err := outbox.store.Pending()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	waiting := make(map[pairKey]bool)
+	for _, entry := range pending {
+		pair := pairKey{phoneNumberId: entry.PhoneNumberId, recipient: recipientOf(entry.Configs)}
+		if waiting[pair] {
+			continue
+		}
+		waiting[pair] = true
+		if entry.NextAttemptAt.After(now) {
+			continue
+		}
+		outbox.mu.Lock()
+		busy := outbox.inFlight[entry.Key]
+		outbox.inFlight[entry.Key] = true
+		outbox.mu.Unlock()
+		if busy {
+			continue
+		}
+		select {
+		case work <- entry:
+		case <-ctx.Done():
+			outbox.mu.Lock()
+			delete(outbox.inFlight, entry.Key)
+			outbox.mu.Unlock()
+			return nil
+		}
+	}
+	return nil
+}
+
+// managerFor returns the manager that sends the entries of the phone number,
+// or a bare one with the outbox's requester when none was set up.
+func (outbox *Outbox) managerFor(phoneNumberId string) *MessageManager {
+	outbox.mu.Lock()
+	defer outbox.mu.Unlock()
+	if manager, ok := outbox.managers[phoneNumberId]; ok {
+		return manager
+	}
+	return NewMessageManager(outbox.requester, phoneNumberId)
+}
+
+// deliver sends one entry and records the outcome.
+func (outbox *Outbox) deliver(ctx context.Context, entry OutboxEntry) {
+	manager := outbox.managerFor(entry.PhoneNumberId)
+	recipient := recipientOf(entry.Configs)
+	var response *MessageSendResponse
+	err := manager.recheckConsent(recipient, entry.Marketing || entry.Endpoint == "marketing_messages")
+	if err == nil {
+		response, err = manager.sendBody(ctx, recipient, entry.Body, entry.Template, entry.Endpoint)
+	}
+	if err != nil && ctx.Err() != nil {
+		// Shutting down: the attempt was cut short, not refused.
+		return
+	}
+
+	entry.Attempts++
+	entry.UpdatedAt = time.Now()
+	switch {
+	case err == nil && (response == nil || len(response.Messages) == 0):
+		err = errors.New("send response carries no message id")
+		fallthrough
+	case err != nil:
+		entry.LastError = err.Error()
+		if isRetryableSend(err) && entry.Attempts < outbox.config.Retry.MaxAttempts {
+			entry.NextAttemptAt = entry.UpdatedAt.Add(outbox.config.Retry.backoff(entry.Attempts))
+		} else {
+			entry.State = OutboxEntryStateFailed
+		}
+	default:
+		entry.State = OutboxEntryStateSent
+		entry.MessageId = response.Messages[0].ID
+		entry.LastError = ""
+	}
+
+	if err := outbox.store.Put(entry); err != nil {
+		fmt.Println("Error updating outbox entry:", err)
+		return
+	}
+	if entry.State != OutboxEntryStatePending && outbox.config.OnResult != nil {
+		outbox.config.OnResult(entry)
+	}
+}
+
+// isRetryableSend reports whether a failed send may succeed when made again:
+// network errors, 429s and 5xx responses, but not refusals by the API, the
+// messaging limit or the consent registry.
+func isRetryableSend(err error) bool {
+	if errors.Is(err, ErrMessagingLimitReached) || errors.Is(err, ErrNoConsent) {
+		return false
+	}
+	var apiErr *GraphAPIError
+	return !errors.As(err, &apiErr) || apiErr.IsRetryable()
+}
+
+// SetOutbox makes Enqueue and EnqueueReply persist sends to the outbox, and
+// makes the outbox send the entries of the manager's phone number through the
+// manager.
+func (mm *MessageManager) SetOutbox(outbox *Outbox) {
+	mm.outbox = outbox
+	outbox.mu.Lock()
+	outbox.managers[mm.PhoneNumberId] = mm
+	outbox.mu.Unlock()
+}
+
+// Enqueue persists a send to the outbox under the idempotency key and returns
+// the entry; the outbox's Run makes the send. Enqueueing a key again returns
+// the existing entry. The consent and service window checks, when set, apply
+// now.
+func (mm *MessageManager) Enqueue(key string, message components.BaseMessage, target MessageTarget) (OutboxEntry, error) {
+	return mm.enqueue(key, message, target.Configs(""))
+}
+
+// EnqueueReply is Enqueue for a reply to the message replyTo.
+func (mm *MessageManager) EnqueueReply(key string, message components.BaseMessage, target MessageTarget, replyTo string) (OutboxEntry, error) {
+	return mm.enqueue(key, message, target.Configs(replyTo))
+}
+
+func (mm *MessageManager) enqueue(key string, message components.BaseMessage, configs components.ApiCompatibleJsonConverterConfigs) (OutboxEntry, error) {
+	if mm.outbox == nil {
+		return OutboxEntry{}, ErrNoOutbox
+	}
+	if err := mm.checkConsent(message, configs, "messages"); err != nil {
+		return OutboxEntry{}, err
+	}
+	if mm.serviceWindow != nil {
+		var err error
+		message, err = mm.serviceWindow.checkServiceWindow(message, configs)
+		if err != nil {
+			return OutboxEntry{}, err
+		}
+	}
+	return mm.outbox.enqueue(key, mm.PhoneNumberId, message, configs, "messages")
+}
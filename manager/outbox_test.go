@@ -0,0 +1,208 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/wapikit/wapi.go/internal/request_client"
+	"github.com/wapikit/wapi.go/pkg/components"
+)
+
+func newTestOutbox(t *testing.T, store OutboxStore) *Outbox {
+	t.Helper()
+	outbox, err := NewOutbox(*request_client.NewRequestClient("token"), store, OutboxConfig{
+		Retry:        RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+		PollInterval: 5 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return outbox
+}
+
+func runOutbox(t *testing.T, outbox *Outbox) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		outbox.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestOutboxSurvivesRestartAndSendsOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	store, err := NewFileOutboxStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mm := newTestMessageManager()
+	mm.SetOutbox(newTestOutbox(t, store))
+	text, _ := components.NewTextMessage(components.TextMessageConfigs{Text: "hi"})
+	if _, err := mm.Enqueue("order-1", text, NewPhoneTarget("911")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new process opens the same file and drains it.
+	var calls atomic.Int32
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		if calls.Add(1) == 1 {
+			return http.StatusServiceUnavailable, `{"error":{"message":"down","code":2}}`
+		}
+		return sentTo(body)
+	})
+	reopened, err := NewFileOutboxStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox := newTestOutbox(t, reopened)
+	mm.SetOutbox(outbox)
+	runOutbox(t, outbox)
+	waitFor(t, func() bool {
+		entry, _ := outbox.Get("order-1")
+		return entry.State == OutboxEntryStateSent
+	})
+
+	entry, _ := mm.Enqueue("order-1", text, NewPhoneTarget("911"))
+	if entry.MessageId != "wamid.911" || entry.Attempts != 2 {
+		t.Fatalf("entry=%+v", entry)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if calls.Load() != 2 {
+		t.Fatalf("%d API calls, want 2", calls.Load())
+	}
+}
+
+func TestOutboxDoesNotRetryRefusedSends(t *testing.T) {
+	var calls atomic.Int32
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		calls.Add(1)
+		return http.StatusBadRequest, `{"error":{"message":"Invalid parameter","code":100}}`
+	})
+	results := make(chan OutboxEntry, 1)
+	outbox, _ := NewOutbox(*request_client.NewRequestClient("token"), NewMemoryOutboxStore(), OutboxConfig{
+		PollInterval: 5 * time.Millisecond,
+		OnResult:     func(entry OutboxEntry) { results <- entry },
+	})
+	mm := newTestMessageManager()
+	mm.SetOutbox(outbox)
+	runOutbox(t, outbox)
+	text, _ := components.NewTextMessage(components.TextMessageConfigs{Text: "hi"})
+	mm.Enqueue("order-2", text, NewPhoneTarget("911"))
+
+	select {
+	case entry := <-results:
+		if entry.State != OutboxEntryStateFailed || entry.Attempts != 1 || calls.Load() != 1 {
+			t.Fatalf("entry=%+v calls=%d", entry, calls.Load())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no result")
+	}
+}
+
+func TestOutboxSendsThroughTheManager(t *testing.T) {
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		return sentTo(body)
+	})
+	transcript := NewTranscript(nil)
+	limits := NewMessagingLimitTracker(nil, MessagingLimitConfig{})
+	limits.SetTier("pn-1", "TIER_50")
+	outbox := newTestOutbox(t, NewMemoryOutboxStore())
+	mm := newTestMessageManager()
+	mm.SetTranscript(transcript)
+	mm.SetMessagingLimits(limits)
+	mm.SetOutbox(outbox)
+	runOutbox(t, outbox)
+
+	template, _ := components.NewTemplateMessage(&components.TemplateMessageConfigs{Name: "order_update", Language: "en"})
+	if _, err := mm.Enqueue("order-3", template, NewPhoneTarget("911")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		entry, _ := outbox.Get("order-3")
+		return entry.State == OutboxEntryStateSent
+	})
+	if _, err := transcript.Get("wamid.911"); err != nil {
+		t.Fatalf("outbox send not in the transcript: %v", err)
+	}
+	if usage, _ := limits.Usage("pn-1"); usage.Used != 1 {
+		t.Fatalf("usage %+v", usage)
+	}
+}
+
+func TestOutboxKeepsTheOrderOfOneRecipient(t *testing.T) {
+	var mu sync.Mutex
+	var sent []string
+	var failed atomic.Bool
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		text := body["text"].(map[string]any)["body"].(string)
+		if text == "1" && failed.CompareAndSwap(false, true) {
+			return http.StatusServiceUnavailable, `{"error":{"message":"down","code":2}}`
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, fmt.Sprint(body["to"], ":", text))
+		return sentTo(body)
+	})
+	outbox := newTestOutbox(t, NewMemoryOutboxStore())
+	mm := newTestMessageManager()
+	mm.SetOutbox(outbox)
+	for i, to := range []string{"911", "911", "912", "911"} {
+		text, _ := components.NewTextMessage(components.TextMessageConfigs{Text: fmt.Sprint(i + 1)})
+		if _, err := mm.Enqueue(fmt.Sprint("order-", i+1), text, NewPhoneTarget(to)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runOutbox(t, outbox)
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(sent) == 4
+	})
+	var first []string
+	for _, send := range sent {
+		if strings.HasPrefix(send, "911:") {
+			first = append(first, send)
+		}
+	}
+	if fmt.Sprint(first) != "[911:1 911:2 911:4]" {
+		t.Fatalf("sent %v", sent)
+	}
+}
+
+func TestFileOutboxStoreCompactBeforeDropsOldFinishedEntries(t *testing.T) {
+	store, err := NewFileOutboxStore(filepath.Join(t.TempDir(), "outbox.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	store.Put(OutboxEntry{Key: "old", State: OutboxEntryStateSent, UpdatedAt: now.Add(-2 * time.Hour)})
+	store.Put(OutboxEntry{Key: "recent", State: OutboxEntryStateFailed, UpdatedAt: now})
+	store.Put(OutboxEntry{Key: "pending", State: OutboxEntryStatePending, UpdatedAt: now.Add(-2 * time.Hour)})
+	if err := store.CompactBefore(now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewFileOutboxStore(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.Get("old"); !errors.Is(err, ErrOutboxEntryNotFound) {
+		t.Fatalf("old entry kept: %v", err)
+	}
+	for _, key := range []string{"recent", "pending"} {
+		if _, err := reopened.Get(key); err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+	}
+}
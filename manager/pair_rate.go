@@ -0,0 +1,167 @@
+package manager
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+// PairRateErrorCode is the Graph API error code of a send refused because the
+// business number messaged the same user too fast.
+const PairRateErrorCode = 131056
+
+// DefaultPairRateInterval is the pace Meta allows between messages from one
+// business number to the same user.
+const DefaultPairRateInterval = 6 * time.Second
+
+// PairRateConfig configures a PairPacer.
+type PairRateConfig struct {
+	// MinInterval is the time between sends to the same user once the burst
+	// is used up. Defaults to DefaultPairRateInterval.
+	MinInterval time.Duration
+	// Burst is the number of sends to the same user that go out back to back
+	// after a quiet period. Defaults to 1.
+	Burst int
+}
+
+// PairPacer paces sends per pair of phone number id and recipient: messages
+// to the same user wait for their turn, in the order they were sent, while
+// messages to other users pass through. It is safe for concurrent use and
+// can be shared by several MessageManagers and a WebhookManager, so their
+// sends to one user share the pace.
+type PairPacer struct {
+	mu      sync.Mutex
+	config  PairRateConfig
+	pairs   map[pairKey]*pairState
+	sweepAt int // sweepAt is the number of pairs at which idle ones are dropped.
+}
+
+type pairKey struct {
+	phoneNumberId string
+	recipient     string
+}
+
+// pairState holds the pace of one pair as the theoretical arrival time of
+// the next send, and the done channel of the last send queued for it.
+type pairState struct {
+	next    time.Time
+	last    chan struct{}
+	waiting int
+}
+
+var _ events.SendPacer = (*PairPacer)(nil)
+
+// NewPairPacer creates a pacer with the given interval and burst.
+func NewPairPacer(config PairRateConfig) *PairPacer {
+	if config.MinInterval <= 0 {
+		config.MinInterval = DefaultPairRateInterval
+	}
+	if config.Burst <= 0 {
+		config.Burst = 1
+	}
+	return &PairPacer{config: config, pairs: make(map[pairKey]*pairState), sweepAt: 1024}
+}
+
+// Acquire blocks until the send to the recipient may go out: after the sends
+// to the same recipient acquired before it have released, and after its slot
+// in the pace. Call the returned release once the send is done. It returns
+// the context error when ctx is done first; the send then gives up its turn.
+func (pacer *PairPacer) Acquire(ctx context.Context, phoneNumberId, recipient string) (func(), error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	key := pairKey{phoneNumberId: phoneNumberId, recipient: recipient}
+	pacer.mu.Lock()
+	state, ok := pacer.pairs[key]
+	if !ok {
+		if len(pacer.pairs) >= pacer.sweepAt {
+			pacer.sweep()
+		}
+		state = &pairState{}
+		pacer.pairs[key] = state
+	}
+	previous, done := state.last, make(chan struct{})
+	state.last = done
+	state.waiting++
+	pacer.mu.Unlock()
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			close(done)
+			pacer.mu.Lock()
+			defer pacer.mu.Unlock()
+			if state.waiting--; state.waiting == 0 && !state.next.After(time.Now()) {
+				delete(pacer.pairs, key)
+			}
+		})
+	}
+	// giveUp hands the turn on once the sends before this one are done.
+	giveUp := func(err error) (func(), error) {
+		go func() {
+			if previous != nil {
+				<-previous
+			}
+			release()
+		}()
+		return nil, err
+	}
+
+	if previous != nil {
+		select {
+		case <-previous:
+		case <-ctx.Done():
+			return giveUp(ctx.Err())
+		}
+	}
+	pacer.mu.Lock()
+	now := time.Now()
+	if state.next.Before(now) {
+		state.next = now
+	}
+	slot := state.next.Add(-time.Duration(pacer.config.Burst-1) * pacer.config.MinInterval)
+	state.next = state.next.Add(pacer.config.MinInterval)
+	pacer.mu.Unlock()
+
+	if delay := time.Until(slot); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			previous = nil
+			return giveUp(ctx.Err())
+		}
+	}
+	return release, nil
+}
+
+// sweep drops the pairs without sends in flight whose pace has caught up. It
+// is called with the lock held.
+func (pacer *PairPacer) sweep() {
+	now := time.Now()
+	for key, state := range pacer.pairs {
+		if state.waiting == 0 && !state.next.After(now) {
+			delete(pacer.pairs, key)
+		}
+	}
+	pacer.sweepAt = max(1024, 2*len(pacer.pairs))
+}
+
+// SetPairPacer makes the manager pace its sends, including split parts,
+// fallback templates, released queued messages and the sends the outbox and
+// the scheduler make through it, per recipient with the pacer.
+func (mm *MessageManager) SetPairPacer(pacer *PairPacer) {
+	mm.pairPacer = pacer
+}
+
+// acquirePair waits for the turn of a send to the recipient, returning a
+// release that does nothing when no pacer is set.
+func (mm *MessageManager) acquirePair(ctx context.Context, recipient string) (func(), error) {
+	if mm.pairPacer == nil {
+		return func() {}, nil
+	}
+	return mm.pairPacer.Acquire(ctx, mm.PhoneNumberId, recipient)
+}
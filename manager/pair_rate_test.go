@@ -0,0 +1,173 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/components"
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+type pacedSend struct {
+	to   any
+	text string
+	at   time.Time
+}
+
+func recordSends(t *testing.T) func() []pacedSend {
+	var mu sync.Mutex
+	var sent []pacedSend
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, pacedSend{to: body["to"], text: body["text"].(map[string]any)["body"].(string), at: time.Now()})
+		return sentTo(body)
+	})
+	return func() []pacedSend {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]pacedSend(nil), sent...)
+	}
+}
+
+func TestPairPacerQueuesSendsToOneRecipientInOrder(t *testing.T) {
+	sent := recordSends(t)
+	interval := 50 * time.Millisecond
+	mm := newTestMessageManager()
+	mm.SetPairPacer(NewPairPacer(PairRateConfig{MinInterval: interval, Burst: 2}))
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 1; i <= 4; i++ {
+		text, _ := components.NewTextMessage(components.TextMessageConfigs{Text: fmt.Sprint(i)})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := mm.Send(text, "911"); err != nil {
+				t.Error(err)
+			}
+		}()
+		time.Sleep(5 * time.Millisecond)
+	}
+	other, _ := components.NewTextMessage(components.TextMessageConfigs{Text: "other"})
+	if _, err := mm.Send(other, "912"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > interval {
+		t.Fatalf("send to another recipient waited %s", elapsed)
+	}
+	wg.Wait()
+
+	var texts []string
+	var times []time.Duration
+	for _, send := range sent() {
+		if send.to == "911" {
+			texts = append(texts, send.text)
+			times = append(times, send.at.Sub(start))
+		}
+	}
+	if fmt.Sprint(texts) != "[1 2 3 4]" {
+		t.Fatalf("sent in order %v", texts)
+	}
+	if times[1] >= interval || times[2] < interval || times[3] < 2*interval {
+		t.Fatalf("sent at %v", times)
+	}
+}
+
+func TestPairPacerPacesEventRepliesWithManagerSends(t *testing.T) {
+	sent := recordSends(t)
+	interval := 60 * time.Millisecond
+	pacer := NewPairPacer(PairRateConfig{MinInterval: interval})
+	mm := newTestMessageManager()
+	mm.SetPairPacer(pacer)
+	em := NewEventManager()
+	wh := newTestWebhook(em)
+	wh.SetSendPacer(pacer)
+	replied := make(chan error, 1)
+	em.On(events.TextMessageEventType, func(e events.BaseEvent) {
+		_, err := e.(*events.TextMessageEvent).ReplyText("reply")
+		replied <- err
+	})
+
+	text, _ := components.NewTextMessage(components.TextMessageConfigs{Text: "first"})
+	if _, err := mm.Send(text, "911"); err != nil {
+		t.Fatal(err)
+	}
+	postWebhook(t, wh, messagesPayload(`{"from":"911","id":"wamid.in","timestamp":"1","type":"text","text":{"body":"hi"}}`))
+	select {
+	case err := <-replied:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no reply")
+	}
+	sends := sent()
+	if len(sends) != 2 || sends[1].text != "reply" || sends[1].at.Sub(sends[0].at) < interval-5*time.Millisecond {
+		t.Fatalf("sends %+v", sends)
+	}
+}
+
+func TestPairPacerCanceledWaiterPassesItsTurnOn(t *testing.T) {
+	pacer := NewPairPacer(PairRateConfig{MinInterval: time.Millisecond})
+	release, err := pacer.Acquire(context.Background(), "pn-1", "911")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() {
+		_, err := pacer.Acquire(ctx, "pn-1", "911")
+		canceled <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+	acquired := make(chan struct{})
+	go func() {
+		if release, err := pacer.Acquire(context.Background(), "pn-1", "911"); err == nil {
+			release()
+			close(acquired)
+		}
+	}()
+	cancel()
+	if err := <-canceled; !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled waiter: %v", err)
+	}
+	release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("the waiter behind the canceled one never got its turn")
+	}
+}
+
+func TestPairPacerPacesOutboxSendsWithManagerSends(t *testing.T) {
+	sent := recordSends(t)
+	interval := 60 * time.Millisecond
+	mm := newTestMessageManager()
+	mm.SetPairPacer(NewPairPacer(PairRateConfig{MinInterval: interval}))
+	outbox := newTestOutbox(t, NewMemoryOutboxStore())
+	mm.SetOutbox(outbox)
+
+	text, _ := components.NewTextMessage(components.TextMessageConfigs{Text: "first"})
+	if _, err := mm.Send(text, "911"); err != nil {
+		t.Fatal(err)
+	}
+	queued, _ := components.NewTextMessage(components.TextMessageConfigs{Text: "queued"})
+	if _, err := mm.Enqueue("queued", queued, NewPhoneTarget("911")); err != nil {
+		t.Fatal(err)
+	}
+	runOutbox(t, outbox)
+	waitFor(t, func() bool {
+		entry, _ := outbox.Get("queued")
+		return entry.State == OutboxEntryStateSent
+	})
+	sends := sent()
+	if len(sends) != 2 || sends[1].text != "queued" || sends[1].at.Sub(sends[0].at) < interval-5*time.Millisecond {
+		t.Fatalf("sends %+v", sends)
+	}
+}
@@ -0,0 +1,268 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/components"
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+// ReengagementErrorCode is the Graph API error code of a free-form message
+// sent to a user whose customer service window is closed.
+const ReengagementErrorCode = 131047
+
+// ErrNoFallbackTemplate is returned by SetReengagementPolicy for a policy
+// without a FallbackTemplate.
+var ErrNoFallbackTemplate = errors.New("reengagement policy has no fallback template")
+
+// ErrMessageQueued is returned, with a nil response, by a send that the
+// reengagement policy queued behind a fallback template already sent to the
+// recipient. The message is sent once the recipient replies.
+var ErrMessageQueued = errors.New("message queued until the recipient replies")
+
+// QueuedMessage is a message held back until its recipient replies. The
+// message is stored already converted to its API body.
+type QueuedMessage struct {
+	PhoneNumberId string                                       `json:"phone_number_id"`
+	Recipient     string                                       `json:"recipient"`
+	Configs       components.ApiCompatibleJsonConverterConfigs `json:"configs"`
+	Body          json.RawMessage                              `json:"body"`
+	QueuedAt      time.Time                                    `json:"queued_at"`
+}
+
+// ReengagementStore keeps the messages waiting for their recipient to reopen
+// the customer service window. Implementations must be safe for concurrent
+// use.
+type ReengagementStore interface {
+	// Add queues the message for its recipient and returns the number of
+	// messages queued for the recipient before it. The check and the add are
+	// one step, so of concurrent sends to a recipient exactly one sees none.
+	Add(message QueuedMessage) (int, error)
+	// Take removes and returns the messages queued for the recipient, oldest
+	// first.
+	Take(recipient string) ([]QueuedMessage, error)
+	// Restore puts messages returned by Take back at the front of their
+	// recipient's queue, ahead of the messages queued since.
+	Restore(messages []QueuedMessage) error
+	// Pending returns the number of messages queued for the recipient.
+	Pending(recipient string) (int, error)
+}
+
+// MemoryReengagementStore keeps queued messages in memory. It is the default
+// store of a ReengagementPolicy.
+type MemoryReengagementStore struct {
+	mu       sync.Mutex
+	messages map[string][]QueuedMessage
+}
+
+// NewMemoryReengagementStore creates an empty in-memory store.
+func NewMemoryReengagementStore() *MemoryReengagementStore {
+	return &MemoryReengagementStore{messages: make(map[string][]QueuedMessage)}
+}
+
+func (store *MemoryReengagementStore) Add(message QueuedMessage) (int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	pending := len(store.messages[message.Recipient])
+	store.messages[message.Recipient] = append(store.messages[message.Recipient], message)
+	return pending, nil
+}
+
+func (store *MemoryReengagementStore) Take(recipient string) ([]QueuedMessage, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	messages := store.messages[recipient]
+	delete(store.messages, recipient)
+	return messages, nil
+}
+
+func (store *MemoryReengagementStore) Restore(messages []QueuedMessage) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for i := len(messages) - 1; i >= 0; i-- {
+		recipient := messages[i].Recipient
+		store.messages[recipient] = append([]QueuedMessage{messages[i]}, store.messages[recipient]...)
+	}
+	return nil
+}
+
+func (store *MemoryReengagementStore) Pending(recipient string) (int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return len(store.messages[recipient]), nil
+}
+
+// ReengagementPolicy makes a MessageManager answer a send refused with
+// ReengagementErrorCode by sending a template instead, e.g. "we have an update
+// for you, reply to continue", and sending the original message once the user
+// replies.
+type ReengagementPolicy struct {
+	FallbackTemplate *components.TemplateMessage // FallbackTemplate is required.
+	Store            ReengagementStore           // Store keeps the queued originals. Defaults to a MemoryReengagementStore.
+}
+
+// reengagement is the policy of a manager with the event manager it publishes
+// on and listens to.
+type reengagement struct {
+	policy       ReengagementPolicy
+	eventManager *EventManager
+}
+
+// SetReengagementPolicy enables the template fallback for sends to the messages
+// endpoint. The original of every fallback is queued and sent when the
+// recipient writes to this phone number, as seen on the event manager, which
+// also gets a TemplateFallbackSentEvent per fallback and a
+// QueuedMessageReleasedEvent per queued message. Failed releases are retried
+// and dead-lettered under the handler name "reengagement". When the service
+// window check of SetServiceWindow stops a send without a fallback template of
+// its own, the policy takes over too.
+//
+// A send answered with a fallback returns the response of the template and no
+// error. While messages already wait for the recipient no further template is
+// sent: the message joins the queue and the send returns ErrMessageQueued. It
+// returns the subscription id to pass to Off, or ErrNoFallbackTemplate when the
+// policy has no FallbackTemplate.
+func (mm *MessageManager) SetReengagementPolicy(eventManager *EventManager, policy ReengagementPolicy) (SubscriptionId, error) {
+	if policy.FallbackTemplate == nil {
+		return 0, ErrNoFallbackTemplate
+	}
+	if policy.Store == nil {
+		policy.Store = NewMemoryReengagementStore()
+	}
+	mm.reengagement = &reengagement{policy: policy, eventManager: eventManager}
+	return eventManager.HandleMatch(CategoryFilter(events.EventCategoryMessage), mm.releaseQueued, HandlerOptions{
+		Name:  "reengagement",
+		Retry: RetryPolicy{MaxAttempts: 3},
+	}), nil
+}
+
+// reengage queues the messages refused with errorCode (0 for the service
+// window check), the parts of a split message in order, and sends the
+// fallback template in their place.
+func (mm *MessageManager) reengage(messages []components.BaseMessage, configs components.ApiCompatibleJsonConverterConfigs, errorCode int) (*MessageSendResponse, error) {
+	queued := make([]QueuedMessage, 0, len(messages))
+	partConfigs := configs
+	for _, message := range messages {
+		if _, isTemplate := message.(*components.TemplateMessage); isTemplate {
+			return nil, errors.New("cannot queue a template message for reengagement")
+		}
+		body, err := message.ToJson(partConfigs)
+		if err != nil {
+			return nil, fmt.Errorf("error converting message to json: %v", err)
+		}
+		queued = append(queued, QueuedMessage{
+			PhoneNumberId: mm.PhoneNumberId,
+			Recipient:     recipientOf(configs),
+			Configs:       partConfigs,
+			Body:          body,
+			QueuedAt:      time.Now(),
+		})
+		partConfigs.ReplyToMessageId = ""
+	}
+	recipient := recipientOf(configs)
+	pending := 0
+	for i, message := range queued {
+		count, err := mm.reengagement.policy.Store.Add(message)
+		if err != nil {
+			return nil, fmt.Errorf("error queueing message: %w", err)
+		}
+		if i == 0 {
+			pending = count
+		}
+	}
+	if pending > 0 {
+		return nil, ErrMessageQueued
+	}
+
+	// The template starts a new thread; it does not quote the original's reply target.
+	fallbackConfigs := configs
+	fallbackConfigs.ReplyToMessageId = ""
+	var response *MessageSendResponse
+	release, sendErr := mm.reserveMessagingLimit(recipient)
+	if sendErr == nil {
+		response, sendErr = mm.send(mm.reengagement.policy.FallbackTemplate, fallbackConfigs, "messages")
+		release(sendErr == nil)
+	}
+	fallback := events.NewTemplateFallbackSentEvent(events.BaseSystemEvent{
+		Timestamp: fmt.Sprint(time.Now().Unix()),
+	}, mm.PhoneNumberId, recipient, "", errorCode)
+	if sendErr != nil {
+		fallback.Error = sendErr.Error()
+	} else {
+		mm.recordOutbound(mm.reengagement.policy.FallbackTemplate, fallbackConfigs, response)
+		if len(response.Messages) > 0 {
+			fallback.FallbackMessageId = response.Messages[0].ID
+		}
+	}
+	if err := mm.reengagement.eventManager.Publish(events.TemplateFallbackSentEventType, fallback); err != nil {
+		fmt.Println("Error publishing template fallback:", err)
+	}
+	return response, sendErr
+}
+
+// isReengagementError reports whether a send failed because the customer
+// service window of the recipient is closed.
+func isReengagementError(err error) bool {
+	var apiErr *GraphAPIError
+	return errors.As(err, &apiErr) && apiErr.Code == ReengagementErrorCode
+}
+
+// releaseQueued sends the messages queued for the sender of an inbound message
+// to this phone number. Messages that fail with a retryable error are put back
+// in front of the queue, in order, and the error returned, so the handler
+// retries them; other failures are reported and dropped.
+func (mm *MessageManager) releaseQueued(event events.BaseEvent) error {
+	message, ok := events.MessageEventOf(event)
+	if !ok || (message.PhoneNumber.Id != "" && message.PhoneNumber.Id != mm.PhoneNumberId) {
+		return nil
+	}
+	seen := map[string]bool{}
+	var errs []error
+	for _, sender := range []string{message.From, message.WaId, message.FromUserId, message.UserId} {
+		if sender == "" || seen[sender] {
+			continue
+		}
+		seen[sender] = true
+		queued, err := mm.reengagement.policy.Store.Take(sender)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for i, entry := range queued {
+			if err := mm.release(event, entry); err != nil {
+				if err := mm.reengagement.policy.Store.Restore(queued[i:]); err != nil {
+					errs = append(errs, err)
+				}
+				errs = append(errs, err)
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// release sends one queued message and publishes the outcome. It returns the
+// send error when the send is worth retrying.
+func (mm *MessageManager) release(event events.BaseEvent, entry QueuedMessage) error {
+	response, err := mm.sendBody(events.ContextOf(event), entry.Recipient, entry.Body, false, "messages")
+	if err != nil && isRetryableSend(err) {
+		return err
+	}
+	released := events.NewQueuedMessageReleasedEvent(events.BaseSystemEvent{
+		Timestamp: fmt.Sprint(time.Now().Unix()),
+	}, entry.PhoneNumberId, entry.Recipient, "")
+	if err != nil {
+		released.Error = err.Error()
+	} else if len(response.Messages) > 0 {
+		released.MessageId = response.Messages[0].ID
+	}
+	events.WithContext(released, events.ContextOf(event))
+	if err := mm.reengagement.eventManager.Publish(events.QueuedMessageReleasedEventType, released); err != nil {
+		fmt.Println("Error publishing queued message release:", err)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/components"
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+func TestReengagementSendsTemplateThenQueuedMessage(t *testing.T) {
+	var windowOpen atomic.Bool
+	var mu sync.Mutex
+	var sentTypes []any
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		mu.Lock()
+		sentTypes = append(sentTypes, body["type"])
+		mu.Unlock()
+		if body["type"] != "template" && !windowOpen.Load() {
+			return http.StatusBadRequest, `{"error":{"message":"Re-engagement message","code":131047}}`
+		}
+		return sentTo(body)
+	})
+	em := NewEventManager()
+	received := make(chan events.BaseEvent, 4)
+	em.OnMatch(TypeFilter(events.TemplateFallbackSentEventType, events.QueuedMessageReleasedEventType), func(e events.BaseEvent) { received <- e })
+	template, _ := components.NewTemplateMessage(&components.TemplateMessageConfigs{Name: "reopen", Language: "en_US"})
+	mm := newTestMessageManager()
+	mm.SetReengagementPolicy(em, ReengagementPolicy{FallbackTemplate: template})
+
+	text, _ := components.NewTextMessage(components.TextMessageConfigs{Text: "your order shipped"})
+	response, err := mm.Send(text, "911")
+	if err != nil || response.Messages[0].ID != "wamid.911" {
+		t.Fatalf("send: %+v, %v", response, err)
+	}
+	fallback := (<-received).(*events.TemplateFallbackSentEvent)
+	if fallback.Recipient != "911" || fallback.ErrorCode != ReengagementErrorCode || fallback.FallbackMessageId != "wamid.911" {
+		t.Fatalf("fallback event: %+v", fallback)
+	}
+
+	windowOpen.Store(true)
+	postWebhook(t, newTestWebhook(em), messagesPayload(fmt.Sprintf(
+		`{"from":"911","id":"wamid.in","timestamp":"%d","type":"text","text":{"body":"ok"}}`, time.Now().Unix())))
+	select {
+	case event := <-received:
+		released := event.(*events.QueuedMessageReleasedEvent)
+		if released.Recipient != "911" || released.MessageId != "wamid.911" || released.Error != "" {
+			t.Fatalf("released event: %+v", released)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("queued message not released")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(sentTypes) != "[text template text]" {
+		t.Fatalf("sent %v", sentTypes)
+	}
+}
+
+func TestReengagementTakesOverClosedServiceWindow(t *testing.T) {
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		if body["type"] != "template" {
+			t.Error("free-form message sent to a closed window")
+		}
+		return sentTo(body)
+	})
+	tracker := NewServiceWindowTracker(nil)
+	tracker.store.Put("911", time.Now().Add(-time.Minute))
+	store := NewMemoryReengagementStore()
+	template, _ := components.NewTemplateMessage(&components.TemplateMessageConfigs{Name: "reopen", Language: "en_US"})
+	mm := newTestMessageManager()
+	mm.SetServiceWindow(ServiceWindowConfig{Tracker: tracker})
+	mm.SetReengagementPolicy(NewEventManager(), ReengagementPolicy{FallbackTemplate: template, Store: store})
+
+	text, _ := components.NewTextMessage(components.TextMessageConfigs{Text: "hi"})
+	if _, err := mm.ReplyToTarget(text, NewPhoneTarget("911"), "wamid.old"); err != nil {
+		t.Fatal(err)
+	}
+	queued, _ := store.Take("911")
+	if len(queued) != 1 || queued[0].Configs.ReplyToMessageId != "wamid.old" {
+		t.Fatalf("queued %+v", queued)
+	}
+}
+
+func TestReengagementSendsOneFallbackForConcurrentSends(t *testing.T) {
+	var templates atomic.Int32
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		if body["type"] == "template" {
+			templates.Add(1)
+			return sentTo(body)
+		}
+		return http.StatusBadRequest, `{"error":{"message":"Re-engagement message","code":131047}}`
+	})
+	template, _ := components.NewTemplateMessage(&components.TemplateMessageConfigs{Name: "reopen", Language: "en_US"})
+	mm := newTestMessageManager()
+	if _, err := mm.SetReengagementPolicy(NewEventManager(), ReengagementPolicy{}); !errors.Is(err, ErrNoFallbackTemplate) {
+		t.Fatalf("policy without a template: %v", err)
+	}
+	if _, err := mm.SetReengagementPolicy(NewEventManager(), ReengagementPolicy{FallbackTemplate: template}); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var queued atomic.Int32
+	for i := 0; i < 8; i++ {
+		text, _ := components.NewTextMessage(components.TextMessageConfigs{Text: fmt.Sprint(i)})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := mm.Send(text, "911")
+			switch {
+			case errors.Is(err, ErrMessageQueued) && response == nil:
+				queued.Add(1)
+			case err != nil:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if templates.Load() != 1 || queued.Load() != 7 {
+		t.Fatalf("%d fallback templates sent, %d sends queued", templates.Load(), queued.Load())
+	}
+}
+
+func TestReengagementKeepsOrderOfFailedReleases(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	var mu sync.Mutex
+	var released []any
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		if down.Load() {
+			return http.StatusServiceUnavailable, `{"error":{"message":"down","code":2}}`
+		}
+		mu.Lock()
+		released = append(released, body["text"].(map[string]any)["body"])
+		mu.Unlock()
+		return sentTo(body)
+	})
+	store := NewMemoryReengagementStore()
+	for _, text := range []string{"1", "2"} {
+		message, _ := components.NewTextMessage(components.TextMessageConfigs{Text: text})
+		body, _ := message.ToJson(NewPhoneTarget("911").Configs(""))
+		store.Add(QueuedMessage{PhoneNumberId: "pn-1", Recipient: "911", Configs: NewPhoneTarget("911").Configs(""), Body: body})
+	}
+	template, _ := components.NewTemplateMessage(&components.TemplateMessageConfigs{Name: "reopen", Language: "en_US"})
+	mm := newTestMessageManager()
+	mm.SetReengagementPolicy(NewEventManager(), ReengagementPolicy{FallbackTemplate: template, Store: store})
+	inbound := textEvent("wamid.in")
+	inbound.From = "911"
+
+	if err := mm.releaseQueued(inbound); err == nil {
+		t.Fatal("release during an outage succeeded")
+	}
+	message, _ := components.NewTextMessage(components.TextMessageConfigs{Text: "3"})
+	body, _ := message.ToJson(NewPhoneTarget("911").Configs(""))
+	store.Add(QueuedMessage{PhoneNumberId: "pn-1", Recipient: "911", Configs: NewPhoneTarget("911").Configs(""), Body: body})
+	down.Store(false)
+	if err := mm.releaseQueued(inbound); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(released) != "[1 2 3]" {
+		t.Fatalf("released %v", released)
+	}
+}
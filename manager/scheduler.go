@@ -0,0 +1,521 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/components"
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+// ErrScheduledJobNotFound is returned by ScheduleStore.Get and
+// Scheduler.Cancel for an unknown key.
+var ErrScheduledJobNotFound = errors.New("scheduled job not found")
+
+// DefaultSchedulePath is the file NewScheduler stores jobs in when it is
+// given neither a store nor SchedulerConfig.Path.
+const DefaultSchedulePath = "wapi-schedule.jsonl"
+
+// ScheduledJobState is the state of a scheduled send.
+type ScheduledJobState string
+
+const (
+	ScheduledJobStateScheduled ScheduledJobState = "scheduled" // ScheduledJobStateScheduled jobs wait for SendAt.
+	ScheduledJobStateSent      ScheduledJobState = "sent"      // ScheduledJobStateSent jobs were sent, or handed to the outbox.
+	ScheduledJobStateSkipped   ScheduledJobState = "skipped"   // ScheduledJobStateSkipped jobs failed a condition; SkipReason says which.
+	ScheduledJobStateCanceled  ScheduledJobState = "canceled"
+	ScheduledJobStateFailed    ScheduledJobState = "failed" // ScheduledJobStateFailed jobs were refused or ran out of retries; Error is set.
+)
+
+// ScheduleOptions configures one scheduled send.
+type ScheduleOptions struct {
+	// Key identifies the job for Cancel and Get. Scheduling a key again
+	// returns the existing job. Defaults to a generated key.
+	Key string
+	// SkipIfReplied skips the send when the recipient writes to us after the
+	// job was scheduled, e.g. for a follow-up the conversation made moot.
+	// It needs the scheduler to be attached to the event manager.
+	SkipIfReplied bool
+	// RequireOpenWindow skips a non-template send when the customer service
+	// window of the recipient is closed at send time. It needs a tracker set
+	// with MessageManager.SetServiceWindow; without one the send is made and
+	// left to the API.
+	RequireOpenWindow bool
+}
+
+// ScheduledJob is a message to send at a later time. The message is stored
+// already converted to its API body.
+type ScheduledJob struct {
+	Key               string                                       `json:"key"`
+	PhoneNumberId     string                                       `json:"phone_number_id"`
+	Recipient         string                                       `json:"recipient"`
+	Configs           components.ApiCompatibleJsonConverterConfigs `json:"configs"`
+	Body              json.RawMessage                              `json:"body"`
+	Template          bool                                         `json:"template,omitempty"`  // Template is set for template messages, which the window check lets through.
+	Marketing         bool                                         `json:"marketing,omitempty"` // Marketing is set for marketing templates, which need consent.
+	SendAt            time.Time                                    `json:"send_at"`
+	SkipIfReplied     bool                                         `json:"skip_if_replied,omitempty"`
+	RequireOpenWindow bool                                         `json:"require_open_window,omitempty"`
+	State             ScheduledJobState                            `json:"state"`
+	SkipReason        string                                       `json:"skip_reason,omitempty"`
+	Error             string                                       `json:"error,omitempty"`
+	Attempts          int                                          `json:"attempts,omitempty"`   // Attempts counts the direct sends made, retries included.
+	MessageId         string                                       `json:"message_id,omitempty"` // MessageId is set once a direct send succeeded.
+	CreatedAt         time.Time                                    `json:"created_at"`
+	UpdatedAt         time.Time                                    `json:"updated_at"`
+}
+
+// ScheduleStore keeps scheduled jobs. Implementations must be safe for
+// concurrent use.
+type ScheduleStore interface {
+	// Put adds the job, replacing any job with the same key.
+	Put(job ScheduledJob) error
+	// Get returns the job with the key or ErrScheduledJobNotFound.
+	Get(key string) (ScheduledJob, error)
+	// Scheduled returns every job still waiting to be sent, earliest first.
+	Scheduled() ([]ScheduledJob, error)
+}
+
+// MemoryScheduleStore keeps scheduled jobs in memory, e.g. for tests. Jobs do
+// not survive a restart.
+type MemoryScheduleStore struct {
+	mu    sync.Mutex
+	order []string
+	jobs  map[string]ScheduledJob
+}
+
+// NewMemoryScheduleStore creates an empty in-memory store.
+func NewMemoryScheduleStore() *MemoryScheduleStore {
+	return &MemoryScheduleStore{jobs: make(map[string]ScheduledJob)}
+}
+
+func (store *MemoryScheduleStore) Put(job ScheduledJob) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.put(job)
+	return nil
+}
+
+func (store *MemoryScheduleStore) put(job ScheduledJob) {
+	if _, ok := store.jobs[job.Key]; !ok {
+		store.order = append(store.order, job.Key)
+	}
+	store.jobs[job.Key] = job
+}
+
+func (store *MemoryScheduleStore) Get(key string) (ScheduledJob, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	job, ok := store.jobs[key]
+	if !ok {
+		return ScheduledJob{}, ErrScheduledJobNotFound
+	}
+	return job, nil
+}
+
+func (store *MemoryScheduleStore) Scheduled() ([]ScheduledJob, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	var scheduled []ScheduledJob
+	for _, key := range store.order {
+		if job := store.jobs[key]; job.State == ScheduledJobStateScheduled {
+			scheduled = append(scheduled, job)
+		}
+	}
+	sort.SliceStable(scheduled, func(i, j int) bool {
+		return scheduled[i].SendAt.Before(scheduled[j].SendAt)
+	})
+	return scheduled, nil
+}
+
+// FileScheduleStore keeps scheduled jobs in a JSON lines file so they survive
+// restarts. Every change appends the whole job; Compact rewrites the file with
+// the jobs still scheduled.
+type FileScheduleStore struct {
+	path   string
+	memory *MemoryScheduleStore
+}
+
+// NewFileScheduleStore opens (or creates) the store at path and loads the jobs
+// already in it. A later line replaces an earlier one with the same key.
+func NewFileScheduleStore(path string) (*FileScheduleStore, error) {
+	store := &FileScheduleStore{path: path, memory: NewMemoryScheduleStore()}
+	if err := readJsonLines(path, store.memory.put); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *FileScheduleStore) Put(job ScheduledJob) error {
+	store.memory.mu.Lock()
+	defer store.memory.mu.Unlock()
+	if err := appendJsonLine(store.path, job); err != nil {
+		return err
+	}
+	store.memory.put(job)
+	return nil
+}
+
+func (store *FileScheduleStore) Get(key string) (ScheduledJob, error) {
+	return store.memory.Get(key)
+}
+
+func (store *FileScheduleStore) Scheduled() ([]ScheduledJob, error) {
+	return store.memory.Scheduled()
+}
+
+// Compact rewrites the file with the jobs still scheduled and forgets the
+// others, so their keys can be scheduled again.
+func (store *FileScheduleStore) Compact() error {
+	store.memory.mu.Lock()
+	defer store.memory.mu.Unlock()
+	var kept []ScheduledJob
+	for _, key := range store.memory.order {
+		if job := store.memory.jobs[key]; job.State == ScheduledJobStateScheduled {
+			kept = append(kept, job)
+		}
+	}
+	if err := rewriteJsonLines(store.path, kept); err != nil {
+		return err
+	}
+	store.memory.order = nil
+	store.memory.jobs = make(map[string]ScheduledJob)
+	for _, job := range kept {
+		store.memory.put(job)
+	}
+	return nil
+}
+
+// SchedulerConfig configures a Scheduler.
+type SchedulerConfig struct {
+	Path         string        // Path is the file of the default FileScheduleStore. Defaults to DefaultSchedulePath.
+	PollInterval time.Duration // PollInterval is how often Run looks for due jobs. Defaults to 1 second.
+	Retry        RetryPolicy   // Retry applies to direct sends that hit network errors, 429s and 5xx responses. MaxAttempts defaults to 5.
+	// OnResult is called when a job leaves the scheduled state other than
+	// by Cancel: sent, skipped or failed. It is called without the
+	// scheduler's lock held and may schedule or cancel jobs.
+	OnResult func(job ScheduledJob)
+}
+
+// Scheduler sends messages at a later time through a MessageManager. Jobs are
+// persisted, so they are sent after a restart too, and their conditions are
+// checked again right before sending. A non-template job due while the
+// customer service window is closed is sent as the manager's fallback
+// template, skipped with RequireOpenWindow, and failed with
+// ErrServiceWindowClosed otherwise. When the manager has an outbox, due jobs
+// are handed to it under the job key and get its retries.
+type Scheduler struct {
+	manager  *MessageManager
+	store    ScheduleStore
+	config   SchedulerConfig
+	wake     chan struct{}
+	mu       sync.Mutex
+	sending  map[string]bool // sending holds the keys of the direct sends in flight.
+	finished []ScheduledJob  // finished holds the jobs to report to OnResult once the lock is released.
+}
+
+// NewScheduler creates a scheduler sending through manager and persisting to
+// store, or to a FileScheduleStore at config.Path when store is nil.
+func NewScheduler(manager *MessageManager, store ScheduleStore, config SchedulerConfig) (*Scheduler, error) {
+	if store == nil {
+		path := config.Path
+		if path == "" {
+			path = DefaultSchedulePath
+		}
+		fileStore, err := NewFileScheduleStore(path)
+		if err != nil {
+			return nil, err
+		}
+		store = fileStore
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Second
+	}
+	if config.Retry.MaxAttempts == 0 {
+		config.Retry.MaxAttempts = 5
+	}
+	config.Retry = config.Retry.withDefaults()
+	return &Scheduler{manager: manager, store: store, config: config, wake: make(chan struct{}, 1), sending: make(map[string]bool)}, nil
+}
+
+// SendAt schedules the message to the target at the given time. A time in the
+// past sends on the next poll.
+func (scheduler *Scheduler) SendAt(at time.Time, message components.BaseMessage, target MessageTarget, options ScheduleOptions) (ScheduledJob, error) {
+	key := options.Key
+	if key == "" {
+		key = "job-" + events.NewTraceId()
+	}
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	if existing, err := scheduler.store.Get(key); err == nil {
+		return existing, nil
+	}
+
+	configs := target.Configs("")
+	if err := scheduler.manager.checkConsent(message, configs, "messages"); err != nil {
+		return ScheduledJob{}, err
+	}
+	body, err := message.ToJson(configs)
+	if err != nil {
+		return ScheduledJob{}, fmt.Errorf("error converting message to json: %v", err)
+	}
+	_, isTemplate := message.(*components.TemplateMessage)
+	now := time.Now()
+	job := ScheduledJob{
+		Key:               key,
+		PhoneNumberId:     scheduler.manager.PhoneNumberId,
+		Recipient:         target.Value,
+		Configs:           configs,
+		Body:              body,
+		Template:          isTemplate,
+		Marketing:         components.IsMarketing(message),
+		SendAt:            at,
+		SkipIfReplied:     options.SkipIfReplied,
+		RequireOpenWindow: options.RequireOpenWindow,
+		State:             ScheduledJobStateScheduled,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+	if err := scheduler.store.Put(job); err != nil {
+		return ScheduledJob{}, err
+	}
+	select {
+	case scheduler.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// SendAfter schedules the message to the target after the delay.
+func (scheduler *Scheduler) SendAfter(delay time.Duration, message components.BaseMessage, target MessageTarget, options ScheduleOptions) (ScheduledJob, error) {
+	return scheduler.SendAt(time.Now().Add(delay), message, target, options)
+}
+
+// Cancel cancels a job that is still scheduled. It returns
+// ErrScheduledJobNotFound for an unknown key and an error for a job that
+// already left the scheduled state.
+func (scheduler *Scheduler) Cancel(key string) error {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	job, err := scheduler.store.Get(key)
+	if err != nil {
+		return err
+	}
+	if job.State != ScheduledJobStateScheduled {
+		return fmt.Errorf("job %s is already %s", key, job.State)
+	}
+	if scheduler.sending[key] {
+		return fmt.Errorf("job %s is being sent", key)
+	}
+	job.State = ScheduledJobStateCanceled
+	job.UpdatedAt = time.Now()
+	return scheduler.store.Put(job)
+}
+
+// Get returns the job with the key or ErrScheduledJobNotFound.
+func (scheduler *Scheduler) Get(key string) (ScheduledJob, error) {
+	return scheduler.store.Get(key)
+}
+
+// Attach subscribes the scheduler to the inbound messages of the event
+// manager, so SkipIfReplied jobs of a user who writes are skipped. It returns
+// the subscription id to pass to Off.
+func (scheduler *Scheduler) Attach(eventManager *EventManager) SubscriptionId {
+	return eventManager.HandleMatch(CategoryFilter(events.EventCategoryMessage), scheduler.Observe, HandlerOptions{
+		Name:  "scheduler",
+		Retry: RetryPolicy{MaxAttempts: 3},
+	})
+}
+
+// Observe skips the SkipIfReplied jobs of the sender of an inbound message
+// that were scheduled before it. Other events are ignored.
+func (scheduler *Scheduler) Observe(event events.BaseEvent) error {
+	message, ok := events.MessageEventOf(event)
+	if !ok {
+		return nil
+	}
+	sentAt, err := message.Time()
+	if err != nil {
+		sentAt = time.Now()
+	}
+	senders := map[string]bool{}
+	for _, id := range []string{message.From, message.WaId, message.FromUserId, message.UserId} {
+		if id != "" {
+			senders[id] = true
+		}
+	}
+
+	scheduler.mu.Lock()
+	defer scheduler.unlock()
+	jobs, err := scheduler.store.Scheduled()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, job := range jobs {
+		if job.SkipIfReplied && senders[job.Recipient] && sentAt.After(job.CreatedAt) && !scheduler.sending[job.Key] {
+			if err := scheduler.finish(job, ScheduledJobStateSkipped, "recipient replied", nil); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run sends due jobs until ctx is done and returns the context error. Jobs
+// that fell due while no scheduler was running are sent first.
+func (scheduler *Scheduler) Run(ctx context.Context) error {
+	ticker := time.NewTicker(scheduler.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := scheduler.sendDue(ctx); err != nil {
+			fmt.Println("Error running scheduled jobs:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		case <-scheduler.wake:
+		}
+	}
+}
+
+// sendDue sends every job whose time has come, one at a time.
+func (scheduler *Scheduler) sendDue(ctx context.Context) error {
+	jobs, err := scheduler.store.Scheduled()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var errs []error
+	for _, job := range jobs {
+		if job.SendAt.After(now) || ctx.Err() != nil {
+			break
+		}
+		if err := scheduler.send(ctx, job.Key); err != nil {
+			errs = append(errs, fmt.Errorf("job %s: %w", job.Key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// send checks the job's conditions and sends it. The conditions are checked
+// under the lock, with the job read again so a Cancel or reply that came in
+// meanwhile wins; the send itself is made after releasing it, with the job
+// marked as sending so Cancel and Observe leave it alone.
+func (scheduler *Scheduler) send(ctx context.Context, key string) error {
+	scheduler.mu.Lock()
+	job, body, template, ready, err := scheduler.prepare(key)
+	if !ready {
+		scheduler.unlock()
+		return err
+	}
+	scheduler.sending[key] = true
+	scheduler.mu.Unlock()
+
+	response, err := scheduler.manager.sendBody(ctx, job.Recipient, body, template, "messages")
+
+	scheduler.mu.Lock()
+	defer scheduler.unlock()
+	delete(scheduler.sending, key)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		job.Attempts++
+		if isRetryableSend(err) && job.Attempts < scheduler.config.Retry.MaxAttempts {
+			job.Error = err.Error()
+			job.UpdatedAt = time.Now()
+			job.SendAt = job.UpdatedAt.Add(scheduler.config.Retry.backoff(job.Attempts))
+			return scheduler.store.Put(job)
+		}
+		return scheduler.finish(job, ScheduledJobStateFailed, "", err)
+	}
+	job.Attempts++
+	job.Error = ""
+	if len(response.Messages) > 0 {
+		job.MessageId = response.Messages[0].ID
+	}
+	return scheduler.finish(job, ScheduledJobStateSent, "", nil)
+}
+
+// prepare checks the conditions of a due job and returns the body to send
+// directly, with ready set. Jobs that are skipped, failed or handed to the
+// outbox are finished here. It is called with the lock held.
+func (scheduler *Scheduler) prepare(key string) (job ScheduledJob, body []byte, template, ready bool, err error) {
+	job, err = scheduler.store.Get(key)
+	if err != nil || job.State != ScheduledJobStateScheduled || scheduler.sending[key] {
+		return job, nil, false, false, err
+	}
+
+	if err := scheduler.manager.recheckConsent(job.Recipient, job.Marketing); err != nil {
+		var refused *ConsentError
+		if !errors.As(err, &refused) {
+			return job, nil, false, false, err
+		}
+		return job, nil, false, false, scheduler.finish(job, ScheduledJobStateSkipped, refused.Reason, nil)
+	}
+
+	body, template = job.Body, job.Template
+	if window := scheduler.manager.serviceWindow; window != nil && !job.Template && window.Tracker != nil {
+		openUntil, known, err := window.Tracker.OpenUntil(job.Recipient)
+		if err != nil {
+			return job, nil, false, false, err
+		}
+		switch {
+		case (!known && window.AllowUnknown) || (known && time.Now().Before(openUntil)):
+		case job.RequireOpenWindow:
+			return job, nil, false, false, scheduler.finish(job, ScheduledJobStateSkipped, "customer service window closed", nil)
+		case window.FallbackTemplate != nil:
+			if body, err = window.FallbackTemplate.ToJson(job.Configs); err != nil {
+				return job, nil, false, false, scheduler.finish(job, ScheduledJobStateFailed, "", err)
+			}
+			template = true
+		default:
+			closed := fmt.Errorf("%w for %s", ErrServiceWindowClosed, job.Recipient)
+			return job, nil, false, false, errors.Join(closed, scheduler.finish(job, ScheduledJobStateFailed, "", closed))
+		}
+	}
+
+	if outbox := scheduler.manager.outbox; outbox != nil {
+		if _, err := outbox.enqueueBody(job.Key, job.PhoneNumberId, body, template, job.Marketing, job.Configs, "messages"); err != nil {
+			return job, nil, false, false, err
+		}
+		return job, nil, false, false, scheduler.finish(job, ScheduledJobStateSent, "", nil)
+	}
+	return job, body, template, true, nil
+}
+
+// finish moves a job out of the scheduled state and queues it for OnResult,
+// which unlock calls. It is called with the lock held.
+func (scheduler *Scheduler) finish(job ScheduledJob, state ScheduledJobState, skipReason string, sendErr error) error {
+	job.State = state
+	job.SkipReason = skipReason
+	if sendErr != nil {
+		job.Error = sendErr.Error()
+	}
+	job.UpdatedAt = time.Now()
+	if err := scheduler.store.Put(job); err != nil {
+		return err
+	}
+	if scheduler.config.OnResult != nil {
+		scheduler.finished = append(scheduler.finished, job)
+	}
+	return nil
+}
+
+// unlock releases the lock and then reports the jobs finished under it, so
+// OnResult may call back into the scheduler, e.g. to reschedule a job.
+func (scheduler *Scheduler) unlock() {
+	finished := scheduler.finished
+	scheduler.finished = nil
+	scheduler.mu.Unlock()
+	for _, job := range finished {
+		scheduler.config.OnResult(job)
+	}
+}
@@ -0,0 +1,256 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/components"
+)
+
+func newTestScheduler(t *testing.T, mm *MessageManager, store ScheduleStore) *Scheduler {
+	t.Helper()
+	scheduler, err := NewScheduler(mm, store, SchedulerConfig{PollInterval: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scheduler
+}
+
+func runScheduler(t *testing.T, scheduler *Scheduler) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		scheduler.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestSchedulerSendsAfterRestartAndHonorsCancel(t *testing.T) {
+	var calls atomic.Int32
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		calls.Add(1)
+		return sentTo(body)
+	})
+	path := filepath.Join(t.TempDir(), "schedule.jsonl")
+	store, err := NewFileScheduleStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mm := newTestMessageManager()
+	scheduler := newTestScheduler(t, mm, store)
+	text, _ := components.NewTextMessage(components.TextMessageConfigs{Text: "reminder"})
+	if _, err := scheduler.SendAfter(20*time.Millisecond, text, NewPhoneTarget("911"), ScheduleOptions{Key: "remind-911"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := scheduler.SendAfter(20*time.Millisecond, text, NewPhoneTarget("912"), ScheduleOptions{Key: "remind-912"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := scheduler.Cancel("remind-912"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new process opens the same file and sends what is due.
+	reopened, err := NewFileScheduleStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted := newTestScheduler(t, mm, reopened)
+	runScheduler(t, restarted)
+	waitFor(t, func() bool {
+		job, _ := restarted.Get("remind-911")
+		return job.State == ScheduledJobStateSent
+	})
+
+	job, _ := restarted.Get("remind-911")
+	if job.MessageId != "wamid.911" || job.SendAt.After(job.UpdatedAt) {
+		t.Fatalf("job=%+v", job)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if canceled, _ := restarted.Get("remind-912"); canceled.State != ScheduledJobStateCanceled || calls.Load() != 1 {
+		t.Fatalf("canceled job=%+v, %d API calls", canceled, calls.Load())
+	}
+	if err := restarted.Cancel("remind-911"); err == nil {
+		t.Fatal("canceled a job that was already sent")
+	}
+	if err := restarted.Cancel("unknown"); !errors.Is(err, ErrScheduledJobNotFound) {
+		t.Fatalf("unknown key: got %v", err)
+	}
+}
+
+func TestSchedulerSkipsFollowUpWhenRecipientReplied(t *testing.T) {
+	em := NewEventManager()
+	scheduler := newTestScheduler(t, newTestMessageManager(), NewMemoryScheduleStore())
+	scheduler.Attach(em)
+	text, _ := components.NewTextMessage(components.TextMessageConfigs{Text: "still there?"})
+	if _, err := scheduler.SendAfter(time.Hour, text, NewPhoneTarget("911"), ScheduleOptions{Key: "nudge", SkipIfReplied: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := scheduler.SendAfter(time.Hour, text, NewPhoneTarget("911"), ScheduleOptions{Key: "digest"}); err != nil {
+		t.Fatal(err)
+	}
+
+	postWebhook(t, newTestWebhook(em), messagesPayload(fmt.Sprintf(
+		`{"from":"911","id":"wamid.in","timestamp":"%d","type":"text","text":{"body":"yes"}}`, time.Now().Add(time.Second).Unix())))
+	waitFor(t, func() bool {
+		job, _ := scheduler.Get("nudge")
+		return job.State == ScheduledJobStateSkipped
+	})
+	if job, _ := scheduler.Get("digest"); job.State != ScheduledJobStateScheduled {
+		t.Fatalf("job without SkipIfReplied: %+v", job)
+	}
+}
+
+func TestSchedulerSkipsWhenWindowClosed(t *testing.T) {
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		t.Error("closed window send reached the API")
+		return sentTo(body)
+	})
+	tracker := NewServiceWindowTracker(nil)
+	tracker.store.Put("911", time.Now().Add(-time.Minute))
+	mm := newTestMessageManager()
+	mm.SetServiceWindow(ServiceWindowConfig{Tracker: tracker})
+	results := make(chan ScheduledJob, 1)
+	scheduler, err := NewScheduler(mm, NewMemoryScheduleStore(), SchedulerConfig{
+		PollInterval: 5 * time.Millisecond,
+		OnResult:     func(job ScheduledJob) { results <- job },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, _ := components.NewTextMessage(components.TextMessageConfigs{Text: "hi"})
+	if _, err := scheduler.SendAt(time.Now(), text, NewPhoneTarget("911"), ScheduleOptions{RequireOpenWindow: true}); err != nil {
+		t.Fatal(err)
+	}
+	runScheduler(t, scheduler)
+
+	select {
+	case job := <-results:
+		if job.State != ScheduledJobStateSkipped || job.SkipReason == "" {
+			t.Fatalf("job=%+v", job)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("job not evaluated")
+	}
+}
+
+func TestSchedulerFailsWhenWindowClosedWithoutFallback(t *testing.T) {
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		t.Error("closed window send reached the API")
+		return sentTo(body)
+	})
+	tracker := NewServiceWindowTracker(nil)
+	tracker.store.Put("911", time.Now().Add(-time.Minute))
+	mm := newTestMessageManager()
+	mm.SetServiceWindow(ServiceWindowConfig{Tracker: tracker})
+	scheduler := newTestScheduler(t, mm, NewMemoryScheduleStore())
+	text, _ := components.NewTextMessage(components.TextMessageConfigs{Text: "hi"})
+	job, err := scheduler.SendAt(time.Now(), text, NewPhoneTarget("911"), ScheduleOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := scheduler.send(context.Background(), job.Key); !errors.Is(err, ErrServiceWindowClosed) {
+		t.Fatalf("send: %v", err)
+	}
+	if job, _ := scheduler.Get(job.Key); job.State != ScheduledJobStateFailed || job.Error == "" {
+		t.Fatalf("job=%+v", job)
+	}
+}
+
+func TestSchedulerRetriesOutagesWithoutHoldingTheLock(t *testing.T) {
+	var calls atomic.Int32
+	unblock := make(chan struct{})
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		if body["to"] == "912" {
+			<-unblock
+			return sentTo(body)
+		}
+		if calls.Add(1) == 1 {
+			return http.StatusServiceUnavailable, `{"error":{"message":"down","code":2}}`
+		}
+		return sentTo(body)
+	})
+	scheduler, err := NewScheduler(newTestMessageManager(), NewMemoryScheduleStore(), SchedulerConfig{
+		PollInterval: 5 * time.Millisecond,
+		Retry:        RetryPolicy{InitialBackoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, _ := components.NewTextMessage(components.TextMessageConfigs{Text: "hi"})
+	scheduler.SendAt(time.Now(), text, NewPhoneTarget("911"), ScheduleOptions{Key: "retried"})
+	runScheduler(t, scheduler)
+	waitFor(t, func() bool {
+		job, _ := scheduler.Get("retried")
+		return job.State == ScheduledJobStateSent
+	})
+	if job, _ := scheduler.Get("retried"); job.Attempts != 2 || job.MessageId != "wamid.911" {
+		t.Fatalf("job=%+v", job)
+	}
+
+	scheduler.SendAt(time.Now(), text, NewPhoneTarget("912"), ScheduleOptions{Key: "slow"})
+	time.Sleep(20 * time.Millisecond)
+	canceled := make(chan error, 1)
+	go func() { canceled <- scheduler.Cancel("slow") }()
+	select {
+	case err := <-canceled:
+		if err == nil {
+			t.Fatal("canceled a job that was being sent")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Cancel waited for the send")
+	}
+	close(unblock)
+	waitFor(t, func() bool {
+		job, _ := scheduler.Get("slow")
+		return job.State == ScheduledJobStateSent
+	})
+}
+
+func TestSchedulerOnResultCanRescheduleJobs(t *testing.T) {
+	var calls atomic.Int32
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		if calls.Add(1) == 1 {
+			return http.StatusBadRequest, `{"error":{"message":"Invalid parameter","code":100}}`
+		}
+		return sentTo(body)
+	})
+	text, _ := components.NewTextMessage(components.TextMessageConfigs{Text: "hi"})
+	var scheduler *Scheduler
+	rescheduled := make(chan error, 1)
+	scheduler, err := NewScheduler(newTestMessageManager(), NewMemoryScheduleStore(), SchedulerConfig{
+		PollInterval: 5 * time.Millisecond,
+		OnResult: func(job ScheduledJob) {
+			if job.State == ScheduledJobStateFailed {
+				_, err := scheduler.SendAfter(0, text, NewPhoneTarget(job.Recipient), ScheduleOptions{Key: job.Key + "-again"})
+				rescheduled <- err
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler.SendAt(time.Now(), text, NewPhoneTarget("911"), ScheduleOptions{Key: "first"})
+	runScheduler(t, scheduler)
+	select {
+	case err := <-rescheduled:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnResult could not reschedule the failed job")
+	}
+	waitFor(t, func() bool {
+		job, _ := scheduler.Get("first-again")
+		return job.State == ScheduledJobStateSent
+	})
+}
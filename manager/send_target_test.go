@@ -18,7 +18,7 @@ func newTextMsg(t *testing.T) components.BaseMessage {
 
 // Phone targets must serialize `to` and never `recipient` (backward compatible).
 func TestPhoneTargetSerializesTo(t *testing.T) {
-	body, err := newTextMsg(t).ToJson(NewPhoneTarget("919999999999").configs(""))
+	body, err := newTextMsg(t).ToJson(NewPhoneTarget("919999999999").Configs(""))
 	if err != nil {
 		t.Fatalf("ToJson: %v", err)
 	}
@@ -36,7 +36,7 @@ func TestPhoneTargetSerializesTo(t *testing.T) {
 
 // BSUID targets must serialize `recipient` and never `to`.
 func TestBSUIDTargetSerializesRecipient(t *testing.T) {
-	body, err := newTextMsg(t).ToJson(NewBSUIDTarget("IN.1013756321578695").configs(""))
+	body, err := newTextMsg(t).ToJson(NewBSUIDTarget("IN.1013756321578695").Configs(""))
 	if err != nil {
 		t.Fatalf("ToJson: %v", err)
 	}
@@ -54,7 +54,7 @@ func TestBSUIDTargetSerializesRecipient(t *testing.T) {
 
 // Parent-BSUID targets also serialize `recipient`.
 func TestParentBSUIDTargetSerializesRecipient(t *testing.T) {
-	body, _ := newTextMsg(t).ToJson(NewParentBSUIDTarget("IN.parent.42").configs(""))
+	body, _ := newTextMsg(t).ToJson(NewParentBSUIDTarget("IN.parent.42").Configs(""))
 	var payload map[string]any
 	_ = json.Unmarshal(body, &payload)
 	if payload["recipient"] != "IN.parent.42" {
@@ -0,0 +1,227 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/components"
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+// ServiceWindowDuration is how long the customer service window stays open
+// after the last message of a user.
+const ServiceWindowDuration = 24 * time.Hour
+
+// ErrServiceWindowClosed is returned by MessageManager sends of non-template
+// messages to users whose customer service window is closed, when the
+// manager enforces the window.
+var ErrServiceWindowClosed = errors.New("customer service window closed")
+
+// ServiceWindowStore keeps the time each user's customer service window closes,
+// keyed by phone number or BSUID. Implementations must be safe for concurrent
+// use.
+type ServiceWindowStore interface {
+	// Get returns the close time of the user's window and false when the
+	// user is unknown.
+	Get(user string) (time.Time, bool, error)
+	// Put sets the close time of the user's window.
+	Put(user string, openUntil time.Time) error
+}
+
+// MemoryServiceWindowStore keeps service windows in memory. It is the default
+// store of a ServiceWindowTracker.
+type MemoryServiceWindowStore struct {
+	mu      sync.Mutex
+	windows map[string]time.Time
+}
+
+// NewMemoryServiceWindowStore creates an empty in-memory store.
+func NewMemoryServiceWindowStore() *MemoryServiceWindowStore {
+	return &MemoryServiceWindowStore{windows: make(map[string]time.Time)}
+}
+
+func (store *MemoryServiceWindowStore) Get(user string) (time.Time, bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	openUntil, ok := store.windows[user]
+	return openUntil, ok, nil
+}
+
+func (store *MemoryServiceWindowStore) Put(user string, openUntil time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.windows[user] = openUntil
+	return nil
+}
+
+// ServiceWindowTracker follows the 24-hour customer service window of every
+// user from their inbound messages and from the expiration of service
+// conversations on status updates. A window is recorded under every
+// identifier the user is known by, so phone and BSUID targets both find it.
+type ServiceWindowTracker struct {
+	mu    sync.Mutex
+	store ServiceWindowStore
+}
+
+// NewServiceWindowTracker creates a tracker keeping its state in store, or in
+// memory when store is nil.
+func NewServiceWindowTracker(store ServiceWindowStore) *ServiceWindowTracker {
+	if store == nil {
+		store = NewMemoryServiceWindowStore()
+	}
+	return &ServiceWindowTracker{store: store}
+}
+
+// Attach subscribes the tracker to the message and status events of the event
+// manager. Store errors are retried and dead-lettered under the handler name
+// "service-window-tracker". It returns the subscription id to pass to Off.
+func (tracker *ServiceWindowTracker) Attach(eventManager *EventManager) SubscriptionId {
+	return eventManager.HandleMatch(CategoryFilter(events.EventCategoryMessage, events.EventCategoryStatus), tracker.Observe, HandlerOptions{
+		Name:  "service-window-tracker",
+		Retry: RetryPolicy{MaxAttempts: 3},
+	})
+}
+
+// Observe feeds one event to the tracker. An inbound message opens the window
+// for ServiceWindowDuration from its timestamp; a status update of a service
+// conversation opens it until the conversation expires. Other conversation
+// origins (marketing, utility, ...) only allow templates and are ignored.
+// Every other event is ignored.
+func (tracker *ServiceWindowTracker) Observe(event events.BaseEvent) error {
+	var users []string
+	var openUntil time.Time
+	if base, ok := events.MessageEventOf(event); ok {
+		sentAt, err := base.Time()
+		if err != nil {
+			sentAt = time.Now()
+		}
+		users = []string{base.From, base.WaId, base.FromUserId, base.UserId}
+		openUntil = sentAt.Add(ServiceWindowDuration)
+	} else {
+		details, recipients, ok := statusDetailsOf(event)
+		if !ok || details.Conversation == nil || details.Conversation.OriginType != "service" {
+			return nil
+		}
+		expiration, err := details.Conversation.ExpirationTime()
+		if err != nil {
+			return nil
+		}
+		users = recipients
+		openUntil = expiration
+	}
+	return tracker.extend(users, openUntil)
+}
+
+// statusDetailsOf returns the status details and recipient identifiers of a
+// status event.
+func statusDetailsOf(event events.BaseEvent) (events.StatusDetails, []string, bool) {
+	switch e := event.(type) {
+	case *events.MessageSentEvent:
+		return e.StatusDetails, []string{e.SentTo, e.RecipientUserId}, true
+	case *events.MessageDeliveredEvent:
+		return e.StatusDetails, []string{e.SentTo, e.RecipientUserId}, true
+	case *events.MessageReadEvent:
+		return e.StatusDetails, []string{e.SentTo, e.RecipientUserId}, true
+	}
+	return events.StatusDetails{}, nil, false
+}
+
+// extend moves the window of every non-empty user to openUntil unless it is
+// already open longer.
+func (tracker *ServiceWindowTracker) extend(users []string, openUntil time.Time) error {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	var errs []error
+	for _, user := range users {
+		if user == "" {
+			continue
+		}
+		current, ok, err := tracker.store.Get(user)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if ok && !current.Before(openUntil) {
+			continue
+		}
+		if err := tracker.store.Put(user, openUntil); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// OpenUntil returns the time the user's window closes, which may be in the
+// past, and false when the tracker has never seen the user.
+func (tracker *ServiceWindowTracker) OpenUntil(user string) (time.Time, bool, error) {
+	return tracker.store.Get(user)
+}
+
+// IsOpen reports whether the user's window is open now.
+func (tracker *ServiceWindowTracker) IsOpen(user string) (bool, error) {
+	openUntil, ok, err := tracker.store.Get(user)
+	if err != nil || !ok {
+		return false, err
+	}
+	return time.Now().Before(openUntil), nil
+}
+
+// ServiceWindowConfig makes a MessageManager check the customer service window
+// before sending anything but a template.
+type ServiceWindowConfig struct {
+	Tracker *ServiceWindowTracker
+	// FallbackTemplate is sent instead of a non-template message when the
+	// window is closed, once for all the parts of a split message. When nil
+	// such sends fail with ErrServiceWindowClosed.
+	FallbackTemplate *components.TemplateMessage
+	// AllowUnknown lets sends through to users the tracker has never seen,
+	// e.g. right after a restart with an in-memory store. By default they are
+	// treated as closed.
+	AllowUnknown bool
+}
+
+// SetServiceWindow enables the service window check for sends to the messages
+// endpoint. Marketing (MM Lite) sends are templates and never checked.
+func (mm *MessageManager) SetServiceWindow(config ServiceWindowConfig) {
+	mm.serviceWindow = &config
+}
+
+// WindowOpenUntil returns the time the customer service window of the target
+// closes, which may be in the past, or the zero time when the target is
+// unknown. It fails when no tracker is configured with SetServiceWindow.
+func (mm *MessageManager) WindowOpenUntil(target MessageTarget) (time.Time, error) {
+	if mm.serviceWindow == nil || mm.serviceWindow.Tracker == nil {
+		return time.Time{}, errors.New("no service window tracker configured")
+	}
+	openUntil, _, err := mm.serviceWindow.Tracker.OpenUntil(target.Value)
+	return openUntil, err
+}
+
+// checkServiceWindow returns the message to send to the recipient of configs:
+// the message itself when it is a template or the window is open, the fallback
+// template otherwise.
+func (config *ServiceWindowConfig) checkServiceWindow(message components.BaseMessage, configs components.ApiCompatibleJsonConverterConfigs) (components.BaseMessage, error) {
+	user := configs.SendToPhoneNumber
+	if user == "" {
+		user = configs.SendToRecipient
+	}
+	if _, isTemplate := message.(*components.TemplateMessage); isTemplate || config.Tracker == nil {
+		return message, nil
+	}
+	openUntil, known, err := config.Tracker.OpenUntil(user)
+	if err != nil {
+		return nil, fmt.Errorf("error reading service window: %w", err)
+	}
+	if (!known && config.AllowUnknown) || (known && time.Now().Before(openUntil)) {
+		return message, nil
+	}
+	if config.FallbackTemplate != nil {
+		return config.FallbackTemplate, nil
+	}
+	if !known {
+		return nil, fmt.Errorf("%w: no message received from %s", ErrServiceWindowClosed, user)
+	}
+	return nil, fmt.Errorf("%w: closed for %s at %s", ErrServiceWindowClosed, user, openUntil.Format(time.RFC3339))
+}
@@ -0,0 +1,76 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/wapikit/wapi.go/internal/request_client"
+	"github.com/wapikit/wapi.go/pkg/components"
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+func TestServiceWindowOpensOnInboundMessage(t *testing.T) {
+	em := NewEventManager()
+	tracker := NewServiceWindowTracker(nil)
+	tracker.Attach(em)
+	sentAt := time.Now().Add(-time.Hour).Unix()
+	postWebhook(t, newTestWebhook(em), messagesPayload(fmt.Sprintf(
+		`{"from":"911","from_user_id":"IN.1","id":"wamid.1","timestamp":"%d","type":"text","text":{"body":"hi"}}`, sentAt)))
+
+	waitFor(t, func() bool {
+		open, _ := tracker.IsOpen("911")
+		return open
+	})
+	openUntil, known, _ := tracker.OpenUntil("IN.1")
+	if !known || openUntil.Unix() != sentAt+int64(ServiceWindowDuration/time.Second) {
+		t.Fatalf("bsuid window=%v known=%v", openUntil, known)
+	}
+}
+
+func TestServiceWindowFollowsServiceConversations(t *testing.T) {
+	tracker := NewServiceWindowTracker(nil)
+	expiration := time.Now().Add(2 * time.Hour).Unix()
+	for _, origin := range []string{"marketing", "service"} {
+		sent := events.NewMessageSentEvent(events.BaseSystemEvent{Timestamp: "1"}, "wamid."+origin, "911")
+		sent.Conversation = &events.StatusConversation{Id: "conv", OriginType: origin, ExpirationTimestamp: fmt.Sprint(expiration)}
+		if err := tracker.Observe(sent); err != nil {
+			t.Fatal(err)
+		}
+		openUntil, known, _ := tracker.OpenUntil("911")
+		if origin == "marketing" && known {
+			t.Fatalf("marketing conversation opened the window until %v", openUntil)
+		}
+		if origin == "service" && openUntil.Unix() != expiration {
+			t.Fatalf("window=%v, want the conversation expiration", openUntil)
+		}
+	}
+}
+
+func TestMessageManagerEnforcesServiceWindow(t *testing.T) {
+	tracker := NewServiceWindowTracker(nil)
+	tracker.store.Put("911", time.Now().Add(-time.Minute))
+	mm := NewMessageManager(*request_client.NewRequestClient("token"), "pn-1")
+	mm.SetServiceWindow(ServiceWindowConfig{Tracker: tracker})
+
+	text, _ := components.NewTextMessage(components.TextMessageConfigs{Text: "hi"})
+	if _, err := mm.Send(text, "911"); !errors.Is(err, ErrServiceWindowClosed) {
+		t.Fatalf("closed window: got %v", err)
+	}
+	if _, err := mm.SendToTarget(text, NewBSUIDTarget("IN.unknown")); !errors.Is(err, ErrServiceWindowClosed) {
+		t.Fatalf("unknown user: got %v", err)
+	}
+	if openUntil, err := mm.WindowOpenUntil(NewPhoneTarget("911")); err != nil || !openUntil.Before(time.Now()) {
+		t.Fatalf("WindowOpenUntil=%v, %v", openUntil, err)
+	}
+
+	template, _ := components.NewTemplateMessage(&components.TemplateMessageConfigs{Name: "reopen", Language: "en_US"})
+	config := ServiceWindowConfig{Tracker: tracker, FallbackTemplate: template}
+	if message, err := config.checkServiceWindow(text, NewPhoneTarget("911").Configs("")); err != nil || message != components.BaseMessage(template) {
+		t.Fatalf("fallback: got %v, %v", message, err)
+	}
+	if message, err := config.checkServiceWindow(template, NewPhoneTarget("911").Configs("")); err != nil || message != components.BaseMessage(template) {
+		t.Fatalf("template: got %v, %v", message, err)
+	}
+}
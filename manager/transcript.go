@@ -0,0 +1,490 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/components"
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+// ErrTranscriptEntryNotFound is returned by TranscriptStore.Get for a message
+// the transcript has never seen.
+var ErrTranscriptEntryNotFound = errors.New("transcript entry not found")
+
+// TranscriptDirection tells messages we sent from messages we received.
+type TranscriptDirection string
+
+const (
+	TranscriptDirectionInbound  TranscriptDirection = "inbound"
+	TranscriptDirectionOutbound TranscriptDirection = "outbound"
+)
+
+// TranscriptEntry is one message of a conversation.
+type TranscriptEntry struct {
+	MessageId     string              `json:"message_id"`
+	Direction     TranscriptDirection `json:"direction"`
+	PhoneNumberId string              `json:"phone_number_id,omitempty"`
+	// Contact is the user the message was exchanged with, the phone number
+	// when known, else the BSUID.
+	Contact string `json:"contact"`
+	// ContactIds are every identifier of the user seen with the message,
+	// Contact included. Queries match any of them.
+	ContactIds []string `json:"contact_ids,omitempty"`
+	Type       string   `json:"type,omitempty"` // Type is the API message type of outbound messages and the event type of inbound ones.
+	Text       string   `json:"text,omitempty"` // Text is the text body or caption, when the message has one.
+	// Body is the API body of an outbound message, or the event of an
+	// inbound message as encoded by events.Marshal. It is empty for outbound
+	// messages only known from their status updates.
+	Body   json.RawMessage       `json:"body,omitempty"`
+	Status events.DeliveryStatus `json:"status,omitempty"` // Status is the delivery status of outbound messages.
+	Errors []events.StatusError  `json:"errors,omitempty"`
+	// Tags are the metadata attached to an outbound message at send time,
+	// see MessageManager.WithTags.
+	Tags map[string]string `json:"tags,omitempty"`
+	// Timestamp is when the message was sent or received.
+	Timestamp time.Time `json:"timestamp"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// hasContact reports whether the entry belongs to the contact.
+func (entry TranscriptEntry) hasContact(contact string) bool {
+	if entry.Contact == contact {
+		return true
+	}
+	for _, id := range entry.ContactIds {
+		if id == contact {
+			return true
+		}
+	}
+	return false
+}
+
+// TranscriptQuery selects transcript entries. Zero fields match everything.
+type TranscriptQuery struct {
+	Contact string    // Contact is a phone number or BSUID of the user.
+	Since   time.Time // Since keeps entries at or after the time.
+	Until   time.Time // Until keeps entries before the time.
+	// Limit keeps the latest entries up to the limit. Zero means no limit.
+	Limit int
+}
+
+// matches reports whether the entry is selected by the query.
+func (query TranscriptQuery) matches(entry TranscriptEntry) bool {
+	switch {
+	case query.Contact != "" && !entry.hasContact(query.Contact):
+		return false
+	case !query.Since.IsZero() && entry.Timestamp.Before(query.Since):
+		return false
+	case !query.Until.IsZero() && !entry.Timestamp.Before(query.Until):
+		return false
+	}
+	return true
+}
+
+// TranscriptStore keeps transcript entries. Implementations must be safe for
+// concurrent use.
+type TranscriptStore interface {
+	// Put adds the entry, replacing any entry of the same message.
+	Put(entry TranscriptEntry) error
+	// Get returns the entry of the message or ErrTranscriptEntryNotFound.
+	Get(messageId string) (TranscriptEntry, error)
+	// Query returns the entries selected by the query, oldest first.
+	Query(query TranscriptQuery) ([]TranscriptEntry, error)
+}
+
+// MemoryTranscriptStore keeps the transcript in memory. It is the default
+// store of a Transcript.
+type MemoryTranscriptStore struct {
+	mu      sync.Mutex
+	entries map[string]TranscriptEntry
+}
+
+// NewMemoryTranscriptStore creates an empty in-memory store.
+func NewMemoryTranscriptStore() *MemoryTranscriptStore {
+	return &MemoryTranscriptStore{entries: make(map[string]TranscriptEntry)}
+}
+
+func (store *MemoryTranscriptStore) Put(entry TranscriptEntry) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.entries[entry.MessageId] = entry
+	return nil
+}
+
+func (store *MemoryTranscriptStore) Get(messageId string) (TranscriptEntry, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	entry, ok := store.entries[messageId]
+	if !ok {
+		return TranscriptEntry{}, ErrTranscriptEntryNotFound
+	}
+	return entry, nil
+}
+
+func (store *MemoryTranscriptStore) Query(query TranscriptQuery) ([]TranscriptEntry, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	var selected []TranscriptEntry
+	for _, entry := range store.entries {
+		if query.matches(entry) {
+			selected = append(selected, entry)
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		if selected[i].Timestamp.Equal(selected[j].Timestamp) {
+			return selected[i].MessageId < selected[j].MessageId
+		}
+		return selected[i].Timestamp.Before(selected[j].Timestamp)
+	})
+	if query.Limit > 0 && len(selected) > query.Limit {
+		selected = selected[len(selected)-query.Limit:]
+	}
+	return selected, nil
+}
+
+// FileTranscriptStore keeps the transcript in a JSON lines file so it survives
+// restarts. Every change appends the whole entry; Compact rewrites the file
+// with one line per message.
+type FileTranscriptStore struct {
+	path   string
+	memory *MemoryTranscriptStore
+}
+
+// NewFileTranscriptStore opens (or creates) the store at path and loads the
+// entries already in it. A later line replaces an earlier one of the same
+// message.
+func NewFileTranscriptStore(path string) (*FileTranscriptStore, error) {
+	store := &FileTranscriptStore{path: path, memory: NewMemoryTranscriptStore()}
+	err := readJsonLines(path, func(entry TranscriptEntry) {
+		store.memory.entries[entry.MessageId] = entry
+	})
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *FileTranscriptStore) Put(entry TranscriptEntry) error {
+	store.memory.mu.Lock()
+	defer store.memory.mu.Unlock()
+	if err := appendJsonLine(store.path, entry); err != nil {
+		return err
+	}
+	store.memory.entries[entry.MessageId] = entry
+	return nil
+}
+
+func (store *FileTranscriptStore) Get(messageId string) (TranscriptEntry, error) {
+	return store.memory.Get(messageId)
+}
+
+func (store *FileTranscriptStore) Query(query TranscriptQuery) ([]TranscriptEntry, error) {
+	return store.memory.Query(query)
+}
+
+// Compact rewrites the file with the current entry of every message.
+func (store *FileTranscriptStore) Compact() error {
+	store.memory.mu.Lock()
+	defer store.memory.mu.Unlock()
+	entries := make([]TranscriptEntry, 0, len(store.memory.entries))
+	for _, entry := range store.memory.entries {
+		entries = append(entries, entry)
+	}
+	return rewriteJsonLines(store.path, entries)
+}
+
+// Transcript records the messages exchanged with every contact: inbound
+// messages and status updates from the event manager it is attached to, and
+// outbound messages from the MessageManagers it is set on with SetTranscript.
+type Transcript struct {
+	mu    sync.Mutex
+	store TranscriptStore
+}
+
+// NewTranscript creates a transcript keeping its entries in store, or in memory
+// when store is nil.
+func NewTranscript(store TranscriptStore) *Transcript {
+	if store == nil {
+		store = NewMemoryTranscriptStore()
+	}
+	return &Transcript{store: store}
+}
+
+// Attach subscribes the transcript to the message and status events of the
+// event manager. Store errors are retried and dead-lettered under the handler
+// name "transcript". It returns the subscription id to pass to Off.
+func (transcript *Transcript) Attach(eventManager *EventManager) SubscriptionId {
+	return eventManager.HandleMatch(CategoryFilter(events.EventCategoryMessage, events.EventCategoryStatus), transcript.Observe, HandlerOptions{
+		Name:  "transcript",
+		Retry: RetryPolicy{MaxAttempts: 3},
+	})
+}
+
+// Observe records an inbound message or applies a status update to the
+// outbound message it is about. A status of a message the transcript has not
+// recorded, e.g. one sent outside this SDK, adds an entry without a body.
+// Other events are ignored.
+func (transcript *Transcript) Observe(event events.BaseEvent) error {
+	if message, ok := events.MessageEventOf(event); ok {
+		return transcript.recordInbound(event, message)
+	}
+	var messageId string
+	var status events.DeliveryStatus
+	var base events.BaseSystemEvent
+	var statusErrors []events.StatusError
+	var contactIds []string
+	switch e := event.(type) {
+	case *events.MessageSentEvent:
+		messageId, status, base, contactIds = e.MessageId, events.DeliveryStatusSent, e.BaseSystemEvent, []string{e.SentTo, e.RecipientUserId}
+	case *events.MessageDeliveredEvent:
+		messageId, status, base, contactIds = e.MessageId, events.DeliveryStatusDelivered, e.BaseSystemEvent, []string{e.SentTo, e.RecipientUserId}
+	case *events.MessageReadEvent:
+		messageId, status, base, contactIds = e.MessageId, events.DeliveryStatusRead, e.BaseSystemEvent, []string{e.SentTo, e.RecipientUserId}
+	case *events.MessageFailedEvent:
+		messageId, status, base, statusErrors, contactIds = e.MessageId, events.DeliveryStatusFailed, e.BaseSystemEvent, e.Errors, []string{e.SentTo}
+	case *events.MessageUndeliveredEvent:
+		messageId, status, base, statusErrors, contactIds = e.MessageId, events.DeliveryStatusFailed, e.BaseSystemEvent, e.Errors, []string{e.SentTo}
+	default:
+		return nil
+	}
+	if messageId == "" {
+		return nil
+	}
+	at, err := base.Time()
+	if err != nil {
+		at = time.Now()
+	}
+
+	transcript.mu.Lock()
+	defer transcript.mu.Unlock()
+	entry, err := transcript.store.Get(messageId)
+	if errors.Is(err, ErrTranscriptEntryNotFound) {
+		entry = TranscriptEntry{
+			MessageId: messageId,
+			Direction: TranscriptDirectionOutbound,
+			Contact:   events.ConversationKeyOf(event),
+			Timestamp: at,
+		}
+	} else if err != nil {
+		return err
+	}
+	if !advances(entry.Status, status) {
+		return nil
+	}
+	entry.Status = status
+	if status == events.DeliveryStatusFailed {
+		entry.Errors = statusErrors
+	}
+	entry.ContactIds = mergeContactIds(entry.ContactIds, entry.Contact, contactIds...)
+	entry.UpdatedAt = time.Now()
+	return transcript.store.Put(entry)
+}
+
+// recordInbound adds an inbound message.
+func (transcript *Transcript) recordInbound(event events.BaseEvent, message *events.BaseMessageEvent) error {
+	body, err := events.Marshal(event)
+	if err != nil {
+		return err
+	}
+	receivedAt, err := message.Time()
+	if err != nil {
+		receivedAt = time.Now()
+	}
+	eventType, _ := events.EventTypeOf(event)
+	contact := events.ConversationKeyOf(event)
+	entry := TranscriptEntry{
+		MessageId:     message.MessageId,
+		Direction:     TranscriptDirectionInbound,
+		PhoneNumberId: message.PhoneNumber.Id,
+		Contact:       contact,
+		ContactIds:    mergeContactIds(nil, contact, message.From, message.WaId, message.FromUserId, message.UserId),
+		Type:          string(eventType),
+		Text:          inboundText(event),
+		Body:          body,
+		Timestamp:     receivedAt,
+		UpdatedAt:     time.Now(),
+	}
+	transcript.mu.Lock()
+	defer transcript.mu.Unlock()
+	return transcript.store.Put(entry)
+}
+
+// inboundText returns the text body or caption of an inbound message.
+func inboundText(event events.BaseEvent) string {
+	switch e := event.(type) {
+	case *events.TextMessageEvent:
+		return e.Text
+	case *events.ImageMessageEvent:
+		return e.Image.Caption
+	case *events.VideoMessageEvent:
+		return e.Video.Caption
+	case *events.DocumentMessageEvent:
+		if e.Document.Caption != nil {
+			return *e.Document.Caption
+		}
+	}
+	return ""
+}
+
+// RecordOutbound adds the messages of a successful send of body to the
+// recipient. Statuses that arrived before the send was recorded are kept.
+func (transcript *Transcript) RecordOutbound(phoneNumberId, recipient string, body []byte, response *MessageSendResponse) error {
+	return transcript.recordOutbound(phoneNumberId, recipient, body, response, nil)
+}
+
+// RecordSentMessage records a message sent from an event reply. It makes the
+// transcript an events.SentMessageStore.
+func (transcript *Transcript) RecordSentMessage(message events.SentMessage) error {
+	response := &MessageSendResponse{Messages: []struct {
+		ID string `json:"id"`
+	}{{ID: message.MessageId}}}
+	return transcript.recordOutbound(message.PhoneNumberId, message.Recipient, message.Payload, response, message.Tags)
+}
+
+// LookupSentMessage returns an outbound message of the transcript for
+// BaseMessageEvent.RepliedTo. Messages only known from their status updates
+// are returned without payload.
+func (transcript *Transcript) LookupSentMessage(messageId string) (events.SentMessage, error) {
+	entry, err := transcript.store.Get(messageId)
+	if errors.Is(err, ErrTranscriptEntryNotFound) || (err == nil && entry.Direction != TranscriptDirectionOutbound) {
+		return events.SentMessage{}, fmt.Errorf("%w: %s", events.ErrSentMessageNotFound, messageId)
+	}
+	if err != nil {
+		return events.SentMessage{}, err
+	}
+	return events.SentMessage{
+		MessageId:     entry.MessageId,
+		PhoneNumberId: entry.PhoneNumberId,
+		Recipient:     entry.Contact,
+		Type:          entry.Type,
+		Payload:       entry.Body,
+		SentAt:        entry.Timestamp,
+		Tags:          maps.Clone(entry.Tags),
+	}, nil
+}
+
+func (transcript *Transcript) recordOutbound(phoneNumberId, recipient string, body []byte, response *MessageSendResponse, tags map[string]string) error {
+	if response == nil {
+		return nil
+	}
+	var payload map[string]json.RawMessage
+	json.Unmarshal(body, &payload)
+	var messageType string
+	json.Unmarshal(payload["type"], &messageType)
+	var content struct {
+		Body    string `json:"body"`
+		Caption string `json:"caption"`
+	}
+	json.Unmarshal(payload[messageType], &content)
+	text := content.Body
+	if messageType != string(components.MessageTypeText) {
+		text = content.Caption
+	}
+	contactIds := []string{recipient}
+	for _, contact := range response.Contacts {
+		contactIds = append(contactIds, contact.WaID, contact.UserId)
+	}
+
+	transcript.mu.Lock()
+	defer transcript.mu.Unlock()
+	var errs []error
+	for _, message := range response.Messages {
+		entry, err := transcript.store.Get(message.ID)
+		if err != nil && !errors.Is(err, ErrTranscriptEntryNotFound) {
+			errs = append(errs, err)
+			continue
+		}
+		if err != nil {
+			entry = TranscriptEntry{MessageId: message.ID, Status: events.DeliveryStatusAccepted, Timestamp: time.Now()}
+		}
+		entry.Direction = TranscriptDirectionOutbound
+		entry.PhoneNumberId = phoneNumberId
+		entry.Contact = recipient
+		entry.ContactIds = mergeContactIds(entry.ContactIds, recipient, contactIds...)
+		entry.Type = messageType
+		entry.Text = text
+		entry.Body = body
+		entry.Tags = maps.Clone(tags)
+		entry.UpdatedAt = time.Now()
+		if err := transcript.store.Put(entry); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// mergeContactIds adds the non-empty ids to ids, contact first, without
+// duplicates.
+func mergeContactIds(ids []string, contact string, more ...string) []string {
+	merged := make([]string, 0, len(ids)+len(more)+1)
+	seen := map[string]bool{}
+	for _, id := range append(append([]string{contact}, ids...), more...) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			merged = append(merged, id)
+		}
+	}
+	return merged
+}
+
+// Get returns the entry of the message or ErrTranscriptEntryNotFound.
+func (transcript *Transcript) Get(messageId string) (TranscriptEntry, error) {
+	return transcript.store.Get(messageId)
+}
+
+// Query returns the entries selected by the query, oldest first.
+func (transcript *Transcript) Query(query TranscriptQuery) ([]TranscriptEntry, error) {
+	return transcript.store.Query(query)
+}
+
+// Conversation returns the messages exchanged with the contact, a phone number
+// or BSUID, between since and until (zero times leave the range open), oldest
+// first.
+func (transcript *Transcript) Conversation(contact string, since, until time.Time) ([]TranscriptEntry, error) {
+	return transcript.store.Query(TranscriptQuery{Contact: contact, Since: since, Until: until})
+}
+
+// WithTags returns a copy of the manager that records the tags with the
+// messages it sends in the transcript, e.g. {"menu": "main"} for a button
+// message, so BaseMessageEvent.RepliedTo of a reply to it returns them.
+func (mm *MessageManager) WithTags(tags map[string]string) *MessageManager {
+	tagged := *mm
+	tagged.tags = maps.Clone(tags)
+	return &tagged
+}
+
+// SetTranscript records every message this manager sends successfully in the
+// transcript, including the sends the outbox and the scheduler make through it
+// and the queued messages the reengagement policy releases.
+func (mm *MessageManager) SetTranscript(transcript *Transcript) {
+	mm.transcript = transcript
+}
+
+// recordOutbound records a sent message in the manager's transcript, if any.
+// Transcript errors do not fail the send.
+func (mm *MessageManager) recordOutbound(message components.BaseMessage, configs components.ApiCompatibleJsonConverterConfigs, response *MessageSendResponse) {
+	if mm.transcript == nil || response == nil {
+		return
+	}
+	body, err := message.ToJson(configs)
+	if err == nil {
+		err = mm.transcript.recordOutbound(mm.PhoneNumberId, recipientOf(configs), body, response, mm.tags)
+	}
+	if err != nil {
+		fmt.Println("Error recording sent message:", err)
+	}
+}
+
+// recipientOf returns the phone number or BSUID configs send to.
+func recipientOf(configs components.ApiCompatibleJsonConverterConfigs) string {
+	if configs.SendToPhoneNumber != "" {
+		return configs.SendToPhoneNumber
+	}
+	return configs.SendToRecipient
+}
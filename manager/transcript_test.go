@@ -0,0 +1,140 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/components"
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+func TestTranscriptRecordsBothDirectionsWithStatuses(t *testing.T) {
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		return http.StatusOK, `{"messages":[{"id":"wamid.out"}],"contacts":[{"input":"911","wa_id":"911"}]}`
+	})
+	store, err := NewFileTranscriptStore(filepath.Join(t.TempDir(), "transcript.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	em := NewEventManager()
+	transcript := NewTranscript(store)
+	transcript.Attach(em)
+	mm := newTestMessageManager()
+	mm.SetTranscript(transcript)
+	wh := newTestWebhook(em)
+
+	receivedAt := time.Now().Add(-time.Minute).Unix()
+	postWebhook(t, wh, messagesPayload(fmt.Sprintf(
+		`{"from":"911","from_user_id":"IN.1","id":"wamid.in","timestamp":"%d","type":"text","text":{"body":"where is my order?"}}`, receivedAt)))
+	text, _ := components.NewTextMessage(components.TextMessageConfigs{Text: "on its way"})
+	if _, err := mm.Reply(text, "911", "wamid.in"); err != nil {
+		t.Fatal(err)
+	}
+	postWebhook(t, wh, statusesPayload(fmt.Sprintf(`{"id":"wamid.out","status":"read","timestamp":"%d","recipient_id":"911"}`, time.Now().Unix())))
+	postWebhook(t, wh, statusesPayload(`{"id":"wamid.out","status":"delivered","timestamp":"1","recipient_id":"911"}`))
+	waitFor(t, func() bool {
+		entry, _ := transcript.Get("wamid.out")
+		return entry.Status == events.DeliveryStatusRead
+	})
+	time.Sleep(10 * time.Millisecond)
+
+	reopened, err := NewFileTranscriptStore(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conversation, err := NewTranscript(reopened).Conversation("IN.1", time.Time{}, time.Time{})
+	if err != nil || len(conversation) != 1 {
+		t.Fatalf("by BSUID: %+v, %v", conversation, err)
+	}
+	conversation, _ = NewTranscript(reopened).Conversation("911", time.Time{}, time.Time{})
+	if len(conversation) != 2 {
+		t.Fatalf("by phone: %+v", conversation)
+	}
+	inbound, outbound := conversation[0], conversation[1]
+	if inbound.Direction != TranscriptDirectionInbound || inbound.Text != "where is my order?" || inbound.Timestamp.Unix() != receivedAt {
+		t.Fatalf("inbound %+v", inbound)
+	}
+	if decoded, err := events.Unmarshal(inbound.Body); err != nil || decoded.(*events.TextMessageEvent).MessageId != "wamid.in" {
+		t.Fatalf("inbound body decodes to %v, %v", decoded, err)
+	}
+	if outbound.Direction != TranscriptDirectionOutbound || outbound.Text != "on its way" || outbound.Type != "text" || outbound.Status != events.DeliveryStatusRead {
+		t.Fatalf("outbound %+v", outbound)
+	}
+}
+
+func TestTranscriptQueriesByTimeRange(t *testing.T) {
+	transcript := NewTranscript(nil)
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		transcript.store.Put(TranscriptEntry{
+			MessageId: fmt.Sprintf("wamid.%d", i),
+			Contact:   "911",
+			Timestamp: base.Add(time.Duration(i) * 24 * time.Hour),
+		})
+	}
+	transcript.store.Put(TranscriptEntry{MessageId: "wamid.other", Contact: "912", Timestamp: base})
+
+	entries, _ := transcript.Query(TranscriptQuery{Contact: "911", Since: base.Add(24 * time.Hour), Until: base.Add(4 * 24 * time.Hour)})
+	if len(entries) != 3 || entries[0].MessageId != "wamid.1" || entries[2].MessageId != "wamid.3" {
+		t.Fatalf("range: %+v", entries)
+	}
+	entries, _ = transcript.Query(TranscriptQuery{Contact: "911", Limit: 2})
+	if len(entries) != 2 || entries[1].MessageId != "wamid.4" {
+		t.Fatalf("limit: %+v", entries)
+	}
+}
+
+func TestRepliedToResolvesTaggedOutboundMessages(t *testing.T) {
+	var sends int
+	fakeGraphApi(t, func(request *http.Request, body map[string]any) (int, string) {
+		sends++
+		return http.StatusOK, fmt.Sprintf(`{"messages":[{"id":"wamid.out%d"}]}`, sends)
+	})
+	em := NewEventManager()
+	transcript := NewTranscript(nil)
+	wh := newTestWebhook(em)
+	wh.SetSentMessages(transcript)
+	received := make(chan *events.TextMessageEvent, 1)
+	em.On(events.TextMessageEventType, func(e events.BaseEvent) { received <- e.(*events.TextMessageEvent) })
+	mm := newTestMessageManager()
+	mm.SetTranscript(transcript)
+
+	menu, _ := components.NewTextMessage(components.TextMessageConfigs{Text: "1. Orders 2. Returns"})
+	if _, err := mm.WithTags(map[string]string{"menu": "main"}).Send(menu, "911"); err != nil {
+		t.Fatal(err)
+	}
+	inbound := func(id, repliedTo string) *events.TextMessageEvent {
+		t.Helper()
+		postWebhook(t, wh, messagesPayload(fmt.Sprintf(
+			`{"from":"911","id":"%s","timestamp":"1","type":"text","text":{"body":"2"},"context":{"from":"1","id":"%s"}}`, id, repliedTo)))
+		select {
+		case event := <-received:
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("event not published")
+			return nil
+		}
+	}
+
+	event := inbound("wamid.in1", "wamid.out1")
+	original, err := event.RepliedTo()
+	if err != nil || original.Tags["menu"] != "main" || original.Type != "text" || original.Recipient != "911" {
+		t.Fatalf("RepliedTo = %+v, %v", original, err)
+	}
+	if _, err := event.ReplyWithTags(menu, map[string]string{"menu": "returns"}); err != nil {
+		t.Fatal(err)
+	}
+	if original, err := inbound("wamid.in2", "wamid.out2").RepliedTo(); err != nil || original.Tags["menu"] != "returns" {
+		t.Fatalf("reply sent from an event: %+v, %v", original, err)
+	}
+	if _, err := inbound("wamid.in3", "wamid.unknown").RepliedTo(); !errors.Is(err, events.ErrSentMessageNotFound) {
+		t.Fatalf("unknown original: %v", err)
+	}
+	if _, err := (&events.BaseMessageEvent{}).RepliedTo(); !errors.Is(err, events.ErrNotAReply) {
+		t.Fatalf("not a reply: %v", err)
+	}
+}
@@ -24,10 +24,16 @@ type NotificationPayloadMessageContextSchemaType struct {
 	FrequentlyForwarded bool   `json:"frequently_forwarded,omitempty"`
 	From                string `json:"from,omitempty"`
 	Id                  string `json:"id"`
-	ReferredProduct     struct {
-		CatalogId         string `json:"catalog_id"`
-		ProductRetailerId string `json:"product_retailer_id"`
-	} `json:"referred_product,omitempty"`
+	// ReferredProduct is set when the customer replied to a single- or
+	// multi-product message, naming the product the reply is about.
+	ReferredProduct *NotificationPayloadReferredProductSchemaType `json:"referred_product,omitempty"`
+}
+
+// NotificationPayloadReferredProductSchemaType identifies a catalog product a
+// message refers to.
+type NotificationPayloadReferredProductSchemaType struct {
+	CatalogId         string `json:"catalog_id"`
+	ProductRetailerId string `json:"product_retailer_id"`
 }
 
 // ReferralInfo represents referral data from Click to WhatsApp ads
@@ -503,6 +509,9 @@ type UserPreferencesValue struct {
 		Category  string `json:"category"` // e.g., "marketing_messages"
 		Value     string `json:"value"`    // Preference value
 		Timestamp int64  `json:"timestamp"`
+		// BSUID identity fields, present when Meta includes them.
+		UserId       string `json:"user_id,omitempty"`
+		ParentUserId string `json:"parent_user_id,omitempty"`
 	} `json:"user_preferences"`
 }
 
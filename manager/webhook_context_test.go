@@ -0,0 +1,64 @@
+package manager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/wapikit/wapi.go/internal/request_client"
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+// postWebhook runs the webhook POST handler on a raw payload.
+func postWebhook(t *testing.T, wh *WebhookManager, payload string) {
+	t.Helper()
+	request := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(payload))
+	recorder := httptest.NewRecorder()
+	if err := wh.PostRequestHandler(echo.New().NewContext(request, recorder)); err != nil {
+		t.Fatalf("post: %v", err)
+	}
+}
+
+func newTestWebhook(em *EventManager) *WebhookManager {
+	return NewWebhook(&WebhookManagerConfig{
+		Secret:       "secret",
+		Path:         "/webhook",
+		EventManager: em,
+		Requester:    *request_client.NewRequestClient("token"),
+	})
+}
+
+func TestWebhookEventsCarryTraceMetadata(t *testing.T) {
+	em := NewEventManager()
+	received := make(chan events.BaseEvent, 2)
+	em.OnAll(func(e events.BaseEvent) { received <- e })
+
+	postWebhook(t, newTestWebhook(em), `{"object":"whatsapp_business_account","entry":[{"id":"waba-1","changes":[
+		{"field":"messages","value":{"messaging_product":"whatsapp","metadata":{"display_phone_number":"1","phone_number_id":"pn-1"},
+			"messages":[{"from":"911","id":"wamid.1","timestamp":"1","type":"text","text":{"body":"hi"}}]}},
+		{"field":"messages","value":{"messaging_product":"whatsapp","metadata":{"display_phone_number":"1","phone_number_id":"pn-1"},
+			"statuses":[{"id":"wamid.2","status":"sent","timestamp":"1","recipient_id":"911"}]}}]}]}`)
+
+	var traceIds []string
+	for i := 0; i < 2; i++ {
+		select {
+		case event := <-received:
+			metadata, ok := events.EventMetadataFrom(events.ContextOf(event))
+			if !ok || metadata.TraceId == "" || metadata.EntryId != "waba-1" || metadata.ChangeField != "messages" {
+				t.Fatalf("%T: unexpected metadata %+v", event, metadata)
+			}
+			if _, isStatus := event.(*events.MessageSentEvent); isStatus && metadata.ChangeIndex != 1 {
+				t.Fatalf("status change index=%d, want 1", metadata.ChangeIndex)
+			}
+			traceIds = append(traceIds, metadata.TraceId)
+		case <-time.After(time.Second):
+			t.Fatal("event not published")
+		}
+	}
+	if traceIds[0] != traceIds[1] {
+		t.Fatalf("events of one delivery have different trace ids: %v", traceIds)
+	}
+}
@@ -18,22 +18,48 @@ import (
 	"github.com/wapikit/wapi.go/pkg/events"
 )
 
-// WebhookManager represents a manager for handling webhooks.
+// WebhookManager represents a manager for handling webhooks. Its EventManager
+// is shared with the caller, so handlers registered on it after NewWebhook see
+// the webhook's events.
 type WebhookManager struct {
 	secret       string
 	path         string
 	port         int
-	EventManager EventManager
+	EventManager *EventManager
 	Requester    request_client.RequestClient
+
+	replyTargetPreference components.TargetPreference
+	splitLongMessages     bool
+	sentMessages          events.SentMessageStore
+	sendPacer             events.SendPacer
 }
 
 // WebhookManagerConfig represents the configuration options for creating a new WebhookManager.
+//
+// EventManager is the event manager the webhook publishes to, e.g. the result
+// of NewEventManager; it is shared, not copied.
 type WebhookManagerConfig struct {
 	Secret       string                       `validate:"required"`
-	EventManager EventManager                 `validate:"required"`
+	EventManager *EventManager                `validate:"required"`
 	Requester    request_client.RequestClient `validate:"required"`
 	Path         string
 	Port         int
+
+	// ReplyTargetPreference is set on every message event and decides whether
+	// replies go to the phone number or the BSUID when a sender has both.
+	// Defaults to the phone number.
+	ReplyTargetPreference components.TargetPreference
+	// SplitLongMessages is set on every message event and makes event replies
+	// split texts and captions over the API limits into several messages.
+	SplitLongMessages bool
+
+	// SentMessages is set on every message event to resolve RepliedTo and
+	// record event replies, e.g. a Transcript. See also SetSentMessages.
+	SentMessages events.SentMessageStore
+	// SendPacer is set on every message event to pace event replies per
+	// recipient, e.g. the PairPacer of the messaging clients. See also
+	// SetSendPacer.
+	SendPacer events.SendPacer
 }
 
 // NewWebhook creates a new WebhookManager with the given options.
@@ -41,12 +67,54 @@ func NewWebhook(options *WebhookManagerConfig) *WebhookManager {
 	if err := internal.GetValidator().Struct(options); err != nil {
 		return nil
 	}
-	return &WebhookManager{
+	wh := &WebhookManager{
 		secret:       options.Secret,
 		path:         options.Path,
 		port:         options.Port,
 		EventManager: options.EventManager,
 		Requester:    options.Requester,
+
+		replyTargetPreference: options.ReplyTargetPreference,
+		splitLongMessages:     options.SplitLongMessages,
+		sentMessages:          options.SentMessages,
+		sendPacer:             options.SendPacer,
+	}
+	options.EventManager.binder = func(event events.BaseEvent) {
+		events.Bind(event, options.Requester)
+		if message, ok := events.MessageEventOf(event); ok {
+			message.SetTargetPreference(wh.replyTargetPreference)
+			message.SetSplitLongMessages(wh.splitLongMessages)
+			if wh.sentMessages != nil {
+				message.SetSentMessages(wh.sentMessages)
+			}
+			if wh.sendPacer != nil {
+				message.SetSendPacer(wh.sendPacer)
+			}
+		}
+	}
+	return wh
+}
+
+// SetSentMessages sets the store of sent messages of the message events, like
+// WebhookManagerConfig.SentMessages. Call it before the webhook receives
+// events.
+func (wh *WebhookManager) SetSentMessages(store events.SentMessageStore) {
+	wh.sentMessages = store
+}
+
+// SetSendPacer sets the pacer of the replies of the message events, like
+// WebhookManagerConfig.SendPacer. Call it before the webhook receives events.
+func (wh *WebhookManager) SetSendPacer(pacer events.SendPacer) {
+	wh.sendPacer = pacer
+}
+
+// publish hands the event to the event manager with ctx as its event context,
+// logging the subscribers it could not be delivered to. Events published by
+// value must have their context set by the caller.
+func (wh *WebhookManager) publish(ctx context.Context, eventType events.EventType, data events.BaseEvent) {
+	events.WithContext(data, ctx)
+	if err := wh.EventManager.Publish(eventType, data); err != nil {
+		fmt.Println("Error publishing event:", err)
 	}
 }
 
@@ -98,8 +166,18 @@ func (wh *WebhookManager) PostRequestHandler(c echo.Context) error {
 		return c.String(400, "Invalid JSON data")
 	}
 
+	// Handlers run after the response is sent, so events keep the request
+	// context's values but not its cancellation.
+	requestContext := context.WithoutCancel(c.Request().Context())
+	traceId := events.NewTraceId()
 	for _, entry := range payload.Entry {
-		for _, change := range entry.Changes {
+		for changeIndex, change := range entry.Changes {
+			ctx := events.WithEventMetadata(requestContext, events.EventMetadata{
+				TraceId:     traceId,
+				EntryId:     entry.Id,
+				ChangeField: string(change.Field),
+				ChangeIndex: changeIndex,
+			})
 			switch change.Field {
 			case WebhookFieldEnumMessages:
 				messageValue, err := unmarshalWebhookValue[MessagesValue](change.Value)
@@ -121,7 +199,7 @@ func (wh *WebhookManager) PostRequestHandler(c echo.Context) error {
 					senderUsername = contact.Profile.Username
 				}
 
-				err = wh.handleMessagesSubscriptionEvents(HandleMessageSubscriptionEventPayload{
+				err = wh.handleMessagesSubscriptionEvents(ctx, HandleMessageSubscriptionEventPayload{
 					Messages: messageValue.Messages,
 					Statuses: messageValue.Statuses,
 					PhoneNumber: events.BusinessPhoneNumber{
@@ -146,7 +224,7 @@ func (wh *WebhookManager) PostRequestHandler(c echo.Context) error {
 				if err != nil {
 					return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid account_review webhook: %v", err))
 				}
-				err = wh.handleAccountReviewSubscriptionEvents(events.BaseBusinessAccountEvent{
+				err = wh.handleAccountReviewSubscriptionEvents(ctx, events.BaseBusinessAccountEvent{
 					BusinessAccountId: entry.Id,
 					Timestamp:         fmt.Sprint(entry.Time),
 				}, accountReviewValue)
@@ -160,7 +238,7 @@ func (wh *WebhookManager) PostRequestHandler(c echo.Context) error {
 				if err != nil {
 					return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid account_alerts webhook: %v", err))
 				}
-				err = wh.handleAccountAlertsSubscriptionEvents(events.BaseBusinessAccountEvent{
+				err = wh.handleAccountAlertsSubscriptionEvents(ctx, events.BaseBusinessAccountEvent{
 					BusinessAccountId: entry.Id,
 					Timestamp:         fmt.Sprint(entry.Time),
 				}, accountAlertValue)
@@ -174,7 +252,7 @@ func (wh *WebhookManager) PostRequestHandler(c echo.Context) error {
 				if err != nil {
 					return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid account_update webhook: %v", err))
 				}
-				wh.handleAccountUpdateSubscriptionEvents(events.BaseBusinessAccountEvent{
+				wh.handleAccountUpdateSubscriptionEvents(ctx, events.BaseBusinessAccountEvent{
 					BusinessAccountId: entry.Id,
 					Timestamp:         fmt.Sprint(entry.Time),
 				}, accountUpdate)
@@ -183,7 +261,7 @@ func (wh *WebhookManager) PostRequestHandler(c echo.Context) error {
 				if err != nil {
 					return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid template_category webhook: %v", err))
 				}
-				wh.handleTemplateCategoryUpdateSubscriptionEvents(events.BaseBusinessAccountEvent{
+				wh.handleTemplateCategoryUpdateSubscriptionEvents(ctx, events.BaseBusinessAccountEvent{
 					BusinessAccountId: entry.Id,
 					Timestamp:         fmt.Sprint(entry.Time),
 				}, templateCategoryUpdate)
@@ -197,7 +275,7 @@ func (wh *WebhookManager) PostRequestHandler(c echo.Context) error {
 				if err != nil {
 					return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid message_template_quality webhook: %v", err))
 				}
-				wh.handleMessageTemplateQualitySubscriptionEvents(events.BaseBusinessAccountEvent{
+				wh.handleMessageTemplateQualitySubscriptionEvents(ctx, events.BaseBusinessAccountEvent{
 					BusinessAccountId: entry.Id,
 					Timestamp:         fmt.Sprint(entry.Time),
 				}, qualityUpdate)
@@ -211,7 +289,7 @@ func (wh *WebhookManager) PostRequestHandler(c echo.Context) error {
 				if err != nil {
 					return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid message_template_status webhook: %v", err))
 				}
-				wh.handleMessageTemplateStatusSubscriptionEvents(events.BaseBusinessAccountEvent{
+				wh.handleMessageTemplateStatusSubscriptionEvents(ctx, events.BaseBusinessAccountEvent{
 					BusinessAccountId: entry.Id,
 					Timestamp:         fmt.Sprint(entry.Time),
 				}, statusUpdate)
@@ -225,7 +303,7 @@ func (wh *WebhookManager) PostRequestHandler(c echo.Context) error {
 				if err != nil {
 					return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid phone_number_name webhook: %v", err))
 				}
-				wh.handlePhoneNumberNameSubscriptionEvents(events.BaseBusinessAccountEvent{
+				wh.handlePhoneNumberNameSubscriptionEvents(ctx, events.BaseBusinessAccountEvent{
 					BusinessAccountId: entry.Id,
 					Timestamp:         fmt.Sprint(entry.Time),
 				}, nameUpdate)
@@ -239,7 +317,7 @@ func (wh *WebhookManager) PostRequestHandler(c echo.Context) error {
 				if err != nil {
 					return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid phone_number_quality webhook: %v", err))
 				}
-				wh.handlePhoneNumberQualitySubscriptionEvents(events.BaseBusinessAccountEvent{
+				wh.handlePhoneNumberQualitySubscriptionEvents(ctx, events.BaseBusinessAccountEvent{
 					BusinessAccountId: entry.Id,
 					Timestamp:         fmt.Sprint(entry.Time),
 				}, qualityUpdate)
@@ -253,7 +331,7 @@ func (wh *WebhookManager) PostRequestHandler(c echo.Context) error {
 				if err != nil {
 					return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid business_capability webhook: %v", err))
 				}
-				wh.handleBusinessCapabilitySubscriptionEvents(events.BaseBusinessAccountEvent{
+				wh.handleBusinessCapabilitySubscriptionEvents(ctx, events.BaseBusinessAccountEvent{
 					BusinessAccountId: entry.Id,
 					Timestamp:         fmt.Sprint(entry.Time),
 				}, capabilityUpdate)
@@ -267,13 +345,13 @@ func (wh *WebhookManager) PostRequestHandler(c echo.Context) error {
 				if err != nil {
 					return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid security webhook: %v", err))
 				}
-				wh.handleSecuritySubscriptionEvents(securityChange)
+				wh.handleSecuritySubscriptionEvents(ctx, securityChange)
 			case WebhookFieldEnumUserPreferences:
 				userPrefsValue, err := unmarshalWebhookValue[UserPreferencesValue](change.Value)
 				if err != nil {
 					return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid user_preferences webhook: %v", err))
 				}
-				wh.handleUserPreferencesSubscriptionEvents(events.BaseBusinessAccountEvent{
+				wh.handleUserPreferencesSubscriptionEvents(ctx, events.BaseBusinessAccountEvent{
 					BusinessAccountId: entry.Id,
 					Timestamp:         fmt.Sprint(entry.Time),
 				}, userPrefsValue)
@@ -282,7 +360,7 @@ func (wh *WebhookManager) PostRequestHandler(c echo.Context) error {
 				if err != nil {
 					return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid message_template_components_update webhook: %v", err))
 				}
-				wh.handleMessageTemplateComponentsUpdateSubscriptionEvents(events.BaseBusinessAccountEvent{
+				wh.handleMessageTemplateComponentsUpdateSubscriptionEvents(ctx, events.BaseBusinessAccountEvent{
 					BusinessAccountId: entry.Id,
 					Timestamp:         fmt.Sprint(entry.Time),
 				}, componentsValue)
@@ -291,7 +369,7 @@ func (wh *WebhookManager) PostRequestHandler(c echo.Context) error {
 				if err != nil {
 					return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid payment_configuration_update webhook: %v", err))
 				}
-				wh.handlePaymentConfigurationUpdateSubscriptionEvents(events.BaseBusinessAccountEvent{
+				wh.handlePaymentConfigurationUpdateSubscriptionEvents(ctx, events.BaseBusinessAccountEvent{
 					BusinessAccountId: entry.Id,
 					Timestamp:         fmt.Sprint(entry.Time),
 				}, paymentConfigValue)
@@ -300,7 +378,7 @@ func (wh *WebhookManager) PostRequestHandler(c echo.Context) error {
 				if err != nil {
 					return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid smb_app_state_sync webhook: %v", err))
 				}
-				wh.handleSmbAppStateSyncSubscriptionEvents(events.BaseBusinessAccountEvent{
+				wh.handleSmbAppStateSyncSubscriptionEvents(ctx, events.BaseBusinessAccountEvent{
 					BusinessAccountId: entry.Id,
 					Timestamp:         fmt.Sprint(entry.Time),
 				}, stateSyncValue)
@@ -309,7 +387,7 @@ func (wh *WebhookManager) PostRequestHandler(c echo.Context) error {
 				if err != nil {
 					return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid smb_message_echoes webhook: %v", err))
 				}
-				wh.handleSmbMessageEchoesSubscriptionEvents(events.BaseBusinessAccountEvent{
+				wh.handleSmbMessageEchoesSubscriptionEvents(ctx, events.BaseBusinessAccountEvent{
 					BusinessAccountId: entry.Id,
 					Timestamp:         fmt.Sprint(entry.Time),
 				}, messageEchoesValue)
@@ -318,7 +396,7 @@ func (wh *WebhookManager) PostRequestHandler(c echo.Context) error {
 				if err != nil {
 					return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid history webhook: %v", err))
 				}
-				wh.handleHistorySubscriptionEvents(events.BaseBusinessAccountEvent{
+				wh.handleHistorySubscriptionEvents(ctx, events.BaseBusinessAccountEvent{
 					BusinessAccountId: entry.Id,
 					Timestamp:         fmt.Sprint(entry.Time),
 				}, historyValue)
@@ -327,7 +405,7 @@ func (wh *WebhookManager) PostRequestHandler(c echo.Context) error {
 				if err != nil {
 					return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid user_id_update webhook: %v", err))
 				}
-				wh.handleUserIdUpdateSubscriptionEvents(events.BaseSystemEvent{
+				wh.handleUserIdUpdateSubscriptionEvents(ctx, events.BaseSystemEvent{
 					Timestamp: fmt.Sprint(entry.Time),
 				}, entry.Id, userIdUpdate)
 			case WebhookFieldEnumBusinessUsernameUpdates:
@@ -335,7 +413,7 @@ func (wh *WebhookManager) PostRequestHandler(c echo.Context) error {
 				if err != nil {
 					return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid business_username_updates webhook: %v", err))
 				}
-				wh.handleBusinessUsernameUpdateSubscriptionEvents(events.BaseBusinessAccountEvent{
+				wh.handleBusinessUsernameUpdateSubscriptionEvents(ctx, events.BaseBusinessAccountEvent{
 					BusinessAccountId: entry.Id,
 					Timestamp:         fmt.Sprint(entry.Time),
 				}, usernameUpdate)
@@ -362,7 +440,7 @@ func (wh *WebhookManager) ListenToEvents() {
 		}
 	}()
 
-	wh.EventManager.Publish(events.ReadyEventType, events.NewReadyEvent())
+	wh.publish(context.Background(), events.ReadyEventType, events.NewReadyEvent())
 	// Wait for an interrupt signal (e.g., Ctrl+C)
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt) // Capture SIGINT (Ctrl+C)
@@ -390,7 +468,7 @@ type HandleMessageSubscriptionEventPayload struct {
 	SenderUsername     string `json:"sender_username"`
 }
 
-func (wh *WebhookManager) handleMessagesSubscriptionEvents(payload HandleMessageSubscriptionEventPayload) error {
+func (wh *WebhookManager) handleMessagesSubscriptionEvents(ctx context.Context, payload HandleMessageSubscriptionEventPayload) error {
 	// consider the field here too, because we will be supporting more events
 	if len(payload.Statuses) > 0 {
 		for _, status := range payload.Statuses {
@@ -399,6 +477,12 @@ func (wh *WebhookManager) handleMessagesSubscriptionEvents(payload HandleMessage
 			// status they bill on; deduplicate downstream by message id, since
 			// the block may repeat across sent/delivered/read for one message.
 			pricing := buildPricingInfo(status.Pricing)
+			details := buildStatusDetails(status)
+			var errorCode int
+			var errorTitle, errorMessage string
+			if first := details.FirstError(); first != nil {
+				errorCode, errorTitle, errorMessage = first.Code, first.Title, first.Message
+			}
 
 			switch status.Status {
 			case string(MessageStatusDelivered):
@@ -406,10 +490,12 @@ func (wh *WebhookManager) handleMessagesSubscriptionEvents(payload HandleMessage
 					ev := events.NewMessageDeliveredEvent(events.BaseSystemEvent{
 						Timestamp: status.Timestamp,
 					}, status.Id, status.RecipientId)
+					ev.StatusDetails = details
+					ev.ErrorCode, ev.ErrorMessage = errorCode, errorMessage
 					ev.Pricing = pricing
 					ev.RecipientUserId = status.RecipientUserId
 					ev.RecipientParentUserId = status.RecipientParentUserId
-					wh.EventManager.Publish(events.MessageDeliveredEventType, ev)
+					wh.publish(ctx, events.MessageDeliveredEventType, ev)
 				}
 
 			case string(MessageStatusRead):
@@ -417,56 +503,40 @@ func (wh *WebhookManager) handleMessagesSubscriptionEvents(payload HandleMessage
 					ev := events.NewMessageReadEvent(events.BaseSystemEvent{
 						Timestamp: status.Timestamp,
 					}, status.Id, status.RecipientId)
+					ev.StatusDetails = details
+					ev.ErrorCode, ev.ErrorMessage = errorCode, errorMessage
 					ev.Pricing = pricing
 					ev.RecipientUserId = status.RecipientUserId
 					ev.RecipientParentUserId = status.RecipientParentUserId
-					wh.EventManager.Publish(events.MessageReadEventType, ev)
+					wh.publish(ctx, events.MessageReadEventType, ev)
 				}
 			case string(MessageStatusSent):
 				{
 					ev := events.NewMessageSentEvent(events.BaseSystemEvent{
 						Timestamp: status.Timestamp,
 					}, status.Id, status.RecipientId)
+					ev.StatusDetails = details
+					ev.ErrorCode, ev.ErrorMessage = errorCode, errorMessage
 					ev.Pricing = pricing
 					ev.RecipientUserId = status.RecipientUserId
 					ev.RecipientParentUserId = status.RecipientParentUserId
-					wh.EventManager.Publish(events.MessageSentEventType, ev)
+					wh.publish(ctx, events.MessageSentEventType, ev)
 				}
 			case string(MessageStatusFailed):
 				{
-					failedReason := ""
-					errorCode := 0
-					errorMessage := ""
-					if len(status.Errors) > 0 {
-						for _, err := range status.Errors {
-							failedReason = err.Title
-							errorCode = err.Code
-							errorMessage = err.Message
-							break
-						}
-					}
-
-					wh.EventManager.Publish(events.MessageFailedEventType, events.NewMessageFailedEvent(events.BaseSystemEvent{
+					ev := events.NewMessageFailedEvent(events.BaseSystemEvent{
 						Timestamp: status.Timestamp,
-					}, status.Id, status.RecipientId, failedReason, errorCode, errorMessage))
+					}, status.Id, status.RecipientId, errorTitle, errorCode, errorMessage)
+					ev.StatusDetails = details
+					wh.publish(ctx, events.MessageFailedEventType, ev)
 				}
 			case string(MessageStatusUnDelivered):
 				{
-					undeliveredReason := ""
-					errorCode := 0
-					errorMessage := ""
-					if len(status.Errors) > 0 {
-						for _, err := range status.Errors {
-							undeliveredReason = err.Title
-							errorCode = err.Code
-							errorMessage = err.Message
-							break
-						}
-					}
-
-					wh.EventManager.Publish(events.MessageUndeliveredEventType, events.NewMessageUndeliveredEvent(events.BaseSystemEvent{
+					ev := events.NewMessageUndeliveredEvent(events.BaseSystemEvent{
 						Timestamp: status.Timestamp,
-					}, status.Id, status.RecipientId, undeliveredReason, errorCode, errorMessage))
+					}, status.Id, status.RecipientId, errorTitle, errorCode, errorMessage)
+					ev.StatusDetails = details
+					wh.publish(ctx, events.MessageUndeliveredEventType, ev)
 				}
 			}
 
@@ -479,6 +549,11 @@ func (wh *WebhookManager) handleMessagesSubscriptionEvents(payload HandleMessage
 			repliedTo = message.Context.Id
 		}
 
+		var adSource *events.AdSource
+		if message.Referral != nil {
+			adSource = adSourceOf(message.Referral)
+		}
+
 		baseMessageEvent := events.NewBaseMessageEvent(events.BaseMessageEventParams{
 			BusinessAccountId: payload.BusinessAccountId,
 			MessageId:         message.Id,
@@ -494,37 +569,27 @@ func (wh *WebhookManager) handleMessagesSubscriptionEvents(payload HandleMessage
 			// Identity fields (BSUID/username rollout). Sender-contact-level
 			// fields come from contacts[0]; the from_* fields come from the
 			// message itself. All additive/optional.
-			WaId:             payload.SenderWaId,
-			UserId:           payload.SenderUserId,
-			ParentUserId:     payload.SenderParentUserId,
-			Username:         payload.SenderUsername,
-			FromUserId:       message.FromUserId,
-			FromParentUserId: message.FromParentUserId,
+			WaId:              payload.SenderWaId,
+			UserId:            payload.SenderUserId,
+			ParentUserId:      payload.SenderParentUserId,
+			Username:          payload.SenderUsername,
+			FromUserId:        message.FromUserId,
+			FromParentUserId:  message.FromParentUserId,
+			TargetPreference:  wh.replyTargetPreference,
+			Referral:          adSource,
+			SplitLongMessages: wh.splitLongMessages,
+			SentMessages:      wh.sentMessages,
+			SendPacer:         wh.sendPacer,
 		})
 
-		if message.Referral != nil {
-			mediaUrl := message.Referral.ImageUrl
-			if message.Referral.VideoUrl != "" {
-				mediaUrl = message.Referral.VideoUrl
-			}
-			adSource := events.AdSource{
-				Url:          message.Referral.SourceUrl,
-				Id:           message.Referral.SourceId,
-				Type:         events.AdInteractionSourceType(message.Referral.SourceType),
-				Title:        message.Referral.Headline,
-				Description:  message.Referral.Body,
-				MediaUrl:     mediaUrl,
-				MediaType:    events.AdInteractionSourceMediaType(message.Referral.MediaType),
-				ThumbnailUrl: message.Referral.ThumbnailUrl,
-				CtwaClid:     message.Referral.CtwaClid,
-			}
+		if adSource != nil {
 			welcomeText := message.Referral.WelcomeMessage.Text
-			if welcomeText == "" && message.Type == NotificationMessageTypeText {
-				welcomeText = message.Text.Body
+			if welcomeText == "" {
+				welcomeText = messageText(&message)
 			}
-			wh.EventManager.Publish(events.AdInteractionEventType, events.NewAdInteractionEvent(
+			wh.publish(ctx, events.AdInteractionEventType, events.NewAdInteractionEvent(
 				baseMessageEvent,
-				adSource,
+				*adSource,
 				welcomeText,
 			))
 		}
@@ -532,7 +597,18 @@ func (wh *WebhookManager) handleMessagesSubscriptionEvents(payload HandleMessage
 		switch message.Type {
 		case NotificationMessageTypeText:
 			{
-				wh.EventManager.Publish(events.TextMessageEventType, events.NewTextMessageEvent(
+				// A text reply to a product message is an inquiry about that
+				// product; publishing it as plain text would drop the product.
+				if product := message.Context.ReferredProduct; product != nil {
+					wh.publish(ctx, events.ProductInquiryEventType, events.NewProductInquiryEvent(
+						baseMessageEvent,
+						product.ProductRetailerId,
+						product.CatalogId,
+						message.Text.Body,
+					))
+					break
+				}
+				wh.publish(ctx, events.TextMessageEventType, events.NewTextMessageEvent(
 					baseMessageEvent,
 					message.Text.Body),
 				)
@@ -551,7 +627,7 @@ func (wh *WebhookManager) handleMessagesSubscriptionEvents(payload HandleMessage
 					return err
 				}
 
-				wh.EventManager.Publish(events.ImageMessageEventType, events.NewImageMessageEvent(
+				wh.publish(ctx, events.ImageMessageEventType, events.NewImageMessageEvent(
 					baseMessageEvent,
 					*imageMessageComponent,
 					message.Image.MIMEType, message.Image.SHA256, message.Image.Id),
@@ -570,7 +646,7 @@ func (wh *WebhookManager) handleMessagesSubscriptionEvents(payload HandleMessage
 					return err
 				}
 
-				wh.EventManager.Publish(events.AudioMessageEventType, events.NewAudioMessageEvent(
+				wh.publish(ctx, events.AudioMessageEventType, events.NewAudioMessageEvent(
 					baseMessageEvent,
 					*audioMessageComponent,
 					message.Audio.MIMEType, message.Audio.SHA256, message.Audio.Id),
@@ -590,7 +666,7 @@ func (wh *WebhookManager) handleMessagesSubscriptionEvents(payload HandleMessage
 					return err
 				}
 
-				wh.EventManager.Publish(events.VideoMessageEventType, events.NewVideoMessageEvent(
+				wh.publish(ctx, events.VideoMessageEventType, events.NewVideoMessageEvent(
 					baseMessageEvent,
 					*videoMessageComponent,
 					message.Video.MIMEType, message.Video.SHA256, message.Video.Id),
@@ -611,7 +687,7 @@ func (wh *WebhookManager) handleMessagesSubscriptionEvents(payload HandleMessage
 					return err
 				}
 
-				wh.EventManager.Publish(events.DocumentMessageEventType, events.NewDocumentMessageEvent(
+				wh.publish(ctx, events.DocumentMessageEventType, events.NewDocumentMessageEvent(
 					baseMessageEvent,
 					*documentMessageComponent,
 					message.Document.Id, message.Document.SHA256, message.Document.MIMEType),
@@ -627,7 +703,7 @@ func (wh *WebhookManager) handleMessagesSubscriptionEvents(payload HandleMessage
 					return err
 				}
 
-				wh.EventManager.Publish(events.LocationMessageEventType, events.NewLocationMessageEvent(
+				wh.publish(ctx, events.LocationMessageEventType, events.NewLocationMessageEvent(
 					baseMessageEvent,
 					*locationMessageComponent),
 				)
@@ -635,7 +711,7 @@ func (wh *WebhookManager) handleMessagesSubscriptionEvents(payload HandleMessage
 		case NotificationMessageTypeContacts:
 			{
 				contactMessageComponent, _ := components.NewContactMessage(message.Contacts)
-				wh.EventManager.Publish(events.ContactMessageEventType, events.NewContactsMessageEvent(
+				wh.publish(ctx, events.ContactMessageEventType, events.NewContactsMessageEvent(
 					baseMessageEvent,
 					*contactMessageComponent,
 				))
@@ -654,7 +730,7 @@ func (wh *WebhookManager) handleMessagesSubscriptionEvents(payload HandleMessage
 					return err
 				}
 
-				wh.EventManager.Publish(events.StickerMessageEventType, events.NewStickerMessageEvent(
+				wh.publish(ctx, events.StickerMessageEventType, events.NewStickerMessageEvent(
 					baseMessageEvent,
 					*stickerMessageComponent,
 					message.Sticker.Id, message.Sticker.SHA256, message.Sticker.MIMEType),
@@ -662,7 +738,7 @@ func (wh *WebhookManager) handleMessagesSubscriptionEvents(payload HandleMessage
 			}
 		case NotificationMessageTypeButton:
 			{
-				wh.EventManager.Publish(events.QuickReplyMessageEventType, events.NewQuickReplyButtonInteractionEvent(
+				wh.publish(ctx, events.QuickReplyMessageEventType, events.NewQuickReplyButtonInteractionEvent(
 					baseMessageEvent,
 					message.Button.Text,
 					message.Button.Payload,
@@ -671,14 +747,14 @@ func (wh *WebhookManager) handleMessagesSubscriptionEvents(payload HandleMessage
 		case NotificationMessageTypeInteractive:
 			{
 				if message.Interactive.Type == "list_reply" {
-					wh.EventManager.Publish(events.ListInteractionMessageEventType, events.NewListInteractionEvent(
+					wh.publish(ctx, events.ListInteractionMessageEventType, events.NewListInteractionEvent(
 						baseMessageEvent,
 						message.Interactive.ListReply.Title,
 						message.Interactive.ListReply.Id,
 						message.Interactive.ListReply.Description,
 					))
 				} else {
-					wh.EventManager.Publish(events.ReplyButtonInteractionEventType, events.NewReplyButtonInteractionEvent(
+					wh.publish(ctx, events.ReplyButtonInteractionEventType, events.NewReplyButtonInteractionEvent(
 						baseMessageEvent,
 						message.Interactive.ButtonReply.Title,
 						message.Interactive.ButtonReply.Id,
@@ -699,7 +775,7 @@ func (wh *WebhookManager) handleMessagesSubscriptionEvents(payload HandleMessage
 					return err
 				}
 
-				wh.EventManager.Publish(events.ReactionMessageEventType, events.NewReactionMessageEvent(
+				wh.publish(ctx, events.ReactionMessageEventType, events.NewReactionMessageEvent(
 					baseMessageEvent,
 					*reactionMessageComponent,
 				))
@@ -717,7 +793,7 @@ func (wh *WebhookManager) handleMessagesSubscriptionEvents(payload HandleMessage
 					}
 				}
 
-				wh.EventManager.Publish(events.OrderReceivedEventType, events.NewOrderEvent(
+				wh.publish(ctx, events.OrderReceivedEventType, events.NewOrderEvent(
 					baseMessageEvent,
 					components.Order{
 						CatalogID:    message.Order.CatalogId,
@@ -731,14 +807,16 @@ func (wh *WebhookManager) handleMessagesSubscriptionEvents(payload HandleMessage
 				// According to official WhatsApp docs, system messages only have: body, wa_id, and type
 				// The user_changed_number type is the primary system message type
 				if message.System.Type == SystemNotificationTypeCustomerPhoneNumberChange {
-					wh.EventManager.Publish(events.CustomerNumberChangedEventType, events.CustomerNumberChangedEvent{
+					numberChanged := events.CustomerNumberChangedEvent{
 						BaseSystemEvent: events.BaseSystemEvent{
 							Timestamp: message.Timestamp,
 						},
 						NewWaId:           message.System.WaId,
 						OldWaId:           message.From, // The old number is in the 'from' field
 						ChangeDescription: message.System.Body,
-					})
+					}
+					numberChanged.SetEventContext(ctx)
+					wh.publish(ctx, events.CustomerNumberChangedEventType, numberChanged)
 				}
 				// Note: customer_identity_changed is no longer supported in the current webhook structure
 			}
@@ -752,8 +830,8 @@ func (wh *WebhookManager) handleMessagesSubscriptionEvents(payload HandleMessage
 	return nil
 }
 
-func (wh *WebhookManager) handleAccountAlertsSubscriptionEvents(baseEvent events.BaseBusinessAccountEvent, value AccountAlertsValue) error {
-	wh.EventManager.Publish(events.AccountAlertsEventType, events.NewAccountAlertEvent(
+func (wh *WebhookManager) handleAccountAlertsSubscriptionEvents(ctx context.Context, baseEvent events.BaseBusinessAccountEvent, value AccountAlertsValue) error {
+	wh.publish(ctx, events.AccountAlertsEventType, events.NewAccountAlertEvent(
 		&baseEvent,
 		value.EntityType,
 		value.EntityId,
@@ -765,11 +843,13 @@ func (wh *WebhookManager) handleAccountAlertsSubscriptionEvents(baseEvent events
 	return nil
 }
 
-func (wh *WebhookManager) handleSecuritySubscriptionEvents(value SecurityValue) {
-	wh.EventManager.Publish(events.AccountAlertsEventType, events.SecurityEvent{})
+func (wh *WebhookManager) handleSecuritySubscriptionEvents(ctx context.Context, value SecurityValue) {
+	securityEvent := events.SecurityEvent{}
+	securityEvent.SetEventContext(ctx)
+	wh.publish(ctx, events.AccountAlertsEventType, securityEvent)
 }
 
-func (wh *WebhookManager) handleAccountUpdateSubscriptionEvents(baseEvent events.BaseBusinessAccountEvent, value AccountUpdateValue) {
+func (wh *WebhookManager) handleAccountUpdateSubscriptionEvents(ctx context.Context, baseEvent events.BaseBusinessAccountEvent, value AccountUpdateValue) {
 	var wabaInfo *events.WabaInfo
 	if value.WabaInfo != nil {
 		wabaInfo = &events.WabaInfo{
@@ -829,7 +909,7 @@ func (wh *WebhookManager) handleAccountUpdateSubscriptionEvents(baseEvent events
 		}
 	}
 
-	wh.EventManager.Publish(events.AccountUpdateEventType, events.NewAccountUpdateEvent(
+	wh.publish(ctx, events.AccountUpdateEventType, events.NewAccountUpdateEvent(
 		&baseEvent,
 		events.AccountUpdateEventEnum(value.Event),
 		value.PhoneNumber,
@@ -843,8 +923,8 @@ func (wh *WebhookManager) handleAccountUpdateSubscriptionEvents(baseEvent events
 	))
 }
 
-func (wh *WebhookManager) handleAccountReviewSubscriptionEvents(baseEvent events.BaseBusinessAccountEvent, value AccountReviewUpdateValue) error {
-	wh.EventManager.Publish(events.AccountAlertsEventType, events.NewAccountReviewUpdateEvent(
+func (wh *WebhookManager) handleAccountReviewSubscriptionEvents(ctx context.Context, baseEvent events.BaseBusinessAccountEvent, value AccountReviewUpdateValue) error {
+	wh.publish(ctx, events.AccountAlertsEventType, events.NewAccountReviewUpdateEvent(
 		&baseEvent,
 		events.AccountReviewUpdateEventEnum(value.Decision),
 	))
@@ -852,8 +932,8 @@ func (wh *WebhookManager) handleAccountReviewSubscriptionEvents(baseEvent events
 
 }
 
-func (wh *WebhookManager) handleBusinessCapabilitySubscriptionEvents(baseEvent events.BaseBusinessAccountEvent, value BusinessCapabilityUpdateValue) error {
-	wh.EventManager.Publish(events.AccountAlertsEventType, events.NewBusinessCapabilityUpdateEvent(
+func (wh *WebhookManager) handleBusinessCapabilitySubscriptionEvents(ctx context.Context, baseEvent events.BaseBusinessAccountEvent, value BusinessCapabilityUpdateValue) error {
+	wh.publish(ctx, events.AccountAlertsEventType, events.NewBusinessCapabilityUpdateEvent(
 		&baseEvent,
 		int64(value.MaxDailyConversationPerPhone),
 		int64(value.MaxPhoneNumbersPerBusiness),
@@ -862,8 +942,8 @@ func (wh *WebhookManager) handleBusinessCapabilitySubscriptionEvents(baseEvent e
 
 }
 
-func (wh *WebhookManager) handleMessageTemplateQualitySubscriptionEvents(baseEvent events.BaseBusinessAccountEvent, value TemplateQualityUpdateValue) error {
-	wh.EventManager.Publish(events.AccountAlertsEventType, events.NewMessageTemplateQualityUpdateEvent(
+func (wh *WebhookManager) handleMessageTemplateQualitySubscriptionEvents(ctx context.Context, baseEvent events.BaseBusinessAccountEvent, value TemplateQualityUpdateValue) error {
+	wh.publish(ctx, events.AccountAlertsEventType, events.NewMessageTemplateQualityUpdateEvent(
 		&baseEvent,
 		events.MessageTemplateQualityUpdateQualityScoreEnum(value.PreviousQualityScore),
 		events.MessageTemplateQualityUpdateQualityScoreEnum(value.NewQualityScore),
@@ -874,8 +954,8 @@ func (wh *WebhookManager) handleMessageTemplateQualitySubscriptionEvents(baseEve
 	return nil
 }
 
-func (wh *WebhookManager) handleMessageTemplateStatusSubscriptionEvents(baseEvent events.BaseBusinessAccountEvent, value TemplateStatusUpdateValue) error {
-	wh.EventManager.Publish(events.AccountAlertsEventType, events.NewMessageTemplateStatusUpdateEvent(
+func (wh *WebhookManager) handleMessageTemplateStatusSubscriptionEvents(ctx context.Context, baseEvent events.BaseBusinessAccountEvent, value TemplateStatusUpdateValue) error {
+	wh.publish(ctx, events.AccountAlertsEventType, events.NewMessageTemplateStatusUpdateEvent(
 		&baseEvent,
 		events.MessageTemplateStatusUpdateEventEnum(value.Event),
 		value.MessageTemplateId,
@@ -887,8 +967,8 @@ func (wh *WebhookManager) handleMessageTemplateStatusSubscriptionEvents(baseEven
 
 }
 
-func (wh *WebhookManager) handlePhoneNumberNameSubscriptionEvents(baseEvent events.BaseBusinessAccountEvent, value PhoneNumberNameUpdateValue) error {
-	wh.EventManager.Publish(events.AccountAlertsEventType, events.NewPhoneNumberNameUpdateEvent(
+func (wh *WebhookManager) handlePhoneNumberNameSubscriptionEvents(ctx context.Context, baseEvent events.BaseBusinessAccountEvent, value PhoneNumberNameUpdateValue) error {
+	wh.publish(ctx, events.AccountAlertsEventType, events.NewPhoneNumberNameUpdateEvent(
 		&baseEvent,
 		value.DisplayPhoneNumber,
 		value.RequestedVerifiedName,
@@ -898,8 +978,8 @@ func (wh *WebhookManager) handlePhoneNumberNameSubscriptionEvents(baseEvent even
 	return nil
 }
 
-func (wh *WebhookManager) handlePhoneNumberQualitySubscriptionEvents(baseEvent events.BaseBusinessAccountEvent, value PhoneNumberQualityUpdateValue) error {
-	wh.EventManager.Publish(events.AccountAlertsEventType, events.NewPhoneNumberQualityUpdateEvent(
+func (wh *WebhookManager) handlePhoneNumberQualitySubscriptionEvents(ctx context.Context, baseEvent events.BaseBusinessAccountEvent, value PhoneNumberQualityUpdateValue) error {
+	wh.publish(ctx, events.AccountAlertsEventType, events.NewPhoneNumberQualityUpdateEvent(
 		&baseEvent,
 		value.DisplayPhoneNumber,
 		events.PhoneNumberUpdateEventEnum(value.Event),
@@ -908,8 +988,8 @@ func (wh *WebhookManager) handlePhoneNumberQualitySubscriptionEvents(baseEvent e
 	return nil
 }
 
-func (wh *WebhookManager) handleTemplateCategoryUpdateSubscriptionEvents(baseEvent events.BaseBusinessAccountEvent, value TemplateCategoryUpdateValue) error {
-	wh.EventManager.Publish(events.AccountAlertsEventType, events.NewMessageTemplateCategoryUpdateEvent(
+func (wh *WebhookManager) handleTemplateCategoryUpdateSubscriptionEvents(ctx context.Context, baseEvent events.BaseBusinessAccountEvent, value TemplateCategoryUpdateValue) error {
+	wh.publish(ctx, events.AccountAlertsEventType, events.NewMessageTemplateCategoryUpdateEvent(
 		&baseEvent,
 		value.MessageTemplateId,
 		value.MessageTemplateName,
@@ -920,7 +1000,7 @@ func (wh *WebhookManager) handleTemplateCategoryUpdateSubscriptionEvents(baseEve
 	return nil
 }
 
-func (wh *WebhookManager) handleUserPreferencesSubscriptionEvents(baseEvent events.BaseBusinessAccountEvent, value UserPreferencesValue) {
+func (wh *WebhookManager) handleUserPreferencesSubscriptionEvents(ctx context.Context, baseEvent events.BaseBusinessAccountEvent, value UserPreferencesValue) {
 	// Convert webhook value to event preferences
 	prefs := make([]events.UserPreference, len(value.UserPreferences))
 	for i, p := range value.UserPreferences {
@@ -930,12 +1010,15 @@ func (wh *WebhookManager) handleUserPreferencesSubscriptionEvents(baseEvent even
 			Category:  p.Category,
 			Value:     p.Value,
 			Timestamp: p.Timestamp,
+
+			UserId:       p.UserId,
+			ParentUserId: p.ParentUserId,
 		}
 	}
-	wh.EventManager.Publish(events.UserPreferencesEventType, events.NewUserPreferencesEvent(&baseEvent, prefs))
+	wh.publish(ctx, events.UserPreferencesEventType, events.NewUserPreferencesEvent(&baseEvent, prefs))
 }
 
-func (wh *WebhookManager) handleMessageTemplateComponentsUpdateSubscriptionEvents(baseEvent events.BaseBusinessAccountEvent, value MessageTemplateComponentsUpdateValue) {
+func (wh *WebhookManager) handleMessageTemplateComponentsUpdateSubscriptionEvents(ctx context.Context, baseEvent events.BaseBusinessAccountEvent, value MessageTemplateComponentsUpdateValue) {
 	// Convert webhook buttons to event buttons
 	buttons := make([]events.MessageTemplateButton, len(value.MessageTemplateButtons))
 	for i, b := range value.MessageTemplateButtons {
@@ -946,7 +1029,7 @@ func (wh *WebhookManager) handleMessageTemplateComponentsUpdateSubscriptionEvent
 			PhoneNumber: b.MessageTemplateButtonPhoneNumber,
 		}
 	}
-	wh.EventManager.Publish(events.MessageTemplateComponentsUpdateEventType, events.NewMessageTemplateComponentsUpdateEvent(
+	wh.publish(ctx, events.MessageTemplateComponentsUpdateEventType, events.NewMessageTemplateComponentsUpdateEvent(
 		&baseEvent,
 		value.MessageTemplateId,
 		value.MessageTemplateName,
@@ -958,8 +1041,8 @@ func (wh *WebhookManager) handleMessageTemplateComponentsUpdateSubscriptionEvent
 	))
 }
 
-func (wh *WebhookManager) handlePaymentConfigurationUpdateSubscriptionEvents(baseEvent events.BaseBusinessAccountEvent, value PaymentConfigurationUpdateValue) {
-	wh.EventManager.Publish(events.PaymentConfigurationUpdateEventType, events.NewPaymentConfigurationUpdateEvent(
+func (wh *WebhookManager) handlePaymentConfigurationUpdateSubscriptionEvents(ctx context.Context, baseEvent events.BaseBusinessAccountEvent, value PaymentConfigurationUpdateValue) {
+	wh.publish(ctx, events.PaymentConfigurationUpdateEventType, events.NewPaymentConfigurationUpdateEvent(
 		&baseEvent,
 		value.ConfigurationName,
 		value.ProviderName,
@@ -970,7 +1053,7 @@ func (wh *WebhookManager) handlePaymentConfigurationUpdateSubscriptionEvents(bas
 	))
 }
 
-func (wh *WebhookManager) handleSmbAppStateSyncSubscriptionEvents(baseEvent events.BaseBusinessAccountEvent, value SmbAppStateSyncValue) {
+func (wh *WebhookManager) handleSmbAppStateSyncSubscriptionEvents(ctx context.Context, baseEvent events.BaseBusinessAccountEvent, value SmbAppStateSyncValue) {
 	// Convert webhook state sync to event state sync
 	stateSync := make([]events.StateSyncItem, len(value.StateSync))
 	for i, s := range value.StateSync {
@@ -985,7 +1068,7 @@ func (wh *WebhookManager) handleSmbAppStateSyncSubscriptionEvents(baseEvent even
 			Timestamp: s.Metadata.Timestamp,
 		}
 	}
-	wh.EventManager.Publish(events.SmbAppStateSyncEventType, events.NewSmbAppStateSyncEvent(
+	wh.publish(ctx, events.SmbAppStateSyncEventType, events.NewSmbAppStateSyncEvent(
 		&baseEvent,
 		value.MessagingProduct,
 		value.Metadata.DisplayPhoneNumber,
@@ -994,7 +1077,7 @@ func (wh *WebhookManager) handleSmbAppStateSyncSubscriptionEvents(baseEvent even
 	))
 }
 
-func (wh *WebhookManager) handleSmbMessageEchoesSubscriptionEvents(baseEvent events.BaseBusinessAccountEvent, value SmbMessageEchoesValue) {
+func (wh *WebhookManager) handleSmbMessageEchoesSubscriptionEvents(ctx context.Context, baseEvent events.BaseBusinessAccountEvent, value SmbMessageEchoesValue) {
 	// Convert webhook message echoes to event message echoes
 	echoes := make([]events.MessageEcho, len(value.MessageEchoes))
 	for i, e := range value.MessageEchoes {
@@ -1006,7 +1089,7 @@ func (wh *WebhookManager) handleSmbMessageEchoesSubscriptionEvents(baseEvent eve
 			Type:      e.Type,
 		}
 	}
-	wh.EventManager.Publish(events.SmbMessageEchoesEventType, events.NewSmbMessageEchoesEvent(
+	wh.publish(ctx, events.SmbMessageEchoesEventType, events.NewSmbMessageEchoesEvent(
 		&baseEvent,
 		value.MessagingProduct,
 		value.Metadata.DisplayPhoneNumber,
@@ -1015,7 +1098,7 @@ func (wh *WebhookManager) handleSmbMessageEchoesSubscriptionEvents(baseEvent eve
 	))
 }
 
-func (wh *WebhookManager) handleHistorySubscriptionEvents(baseEvent events.BaseBusinessAccountEvent, value HistoryValue) {
+func (wh *WebhookManager) handleHistorySubscriptionEvents(ctx context.Context, baseEvent events.BaseBusinessAccountEvent, value HistoryValue) {
 	// Convert webhook history to event history chunks
 	chunks := make([]events.HistoryChunk, len(value.History))
 	for i, h := range value.History {
@@ -1044,7 +1127,7 @@ func (wh *WebhookManager) handleHistorySubscriptionEvents(baseEvent events.BaseB
 			Threads:    threads,
 		}
 	}
-	wh.EventManager.Publish(events.HistoryEventType, events.NewHistoryEvent(
+	wh.publish(ctx, events.HistoryEventType, events.NewHistoryEvent(
 		&baseEvent,
 		value.MessagingProduct,
 		value.Metadata.DisplayPhoneNumber,
@@ -1053,14 +1136,14 @@ func (wh *WebhookManager) handleHistorySubscriptionEvents(baseEvent events.BaseB
 	))
 }
 
-func (wh *WebhookManager) handleUserIdUpdateSubscriptionEvents(baseEvent events.BaseSystemEvent, businessAccountId string, value UserIdUpdateValue) error {
+func (wh *WebhookManager) handleUserIdUpdateSubscriptionEvents(ctx context.Context, baseEvent events.BaseSystemEvent, businessAccountId string, value UserIdUpdateValue) error {
 	// Prefer explicit old/new; fall back to user_id when Meta only sends the
 	// current id. Raw values preserved for downstream identity bridging.
 	newUserId := value.NewUserId
 	if newUserId == "" {
 		newUserId = value.UserId
 	}
-	wh.EventManager.Publish(events.UserIdUpdateEventType, events.NewUserIdUpdateEvent(
+	wh.publish(ctx, events.UserIdUpdateEventType, events.NewUserIdUpdateEvent(
 		baseEvent,
 		businessAccountId,
 		value.WaId,
@@ -1071,8 +1154,8 @@ func (wh *WebhookManager) handleUserIdUpdateSubscriptionEvents(baseEvent events.
 	return nil
 }
 
-func (wh *WebhookManager) handleBusinessUsernameUpdateSubscriptionEvents(baseEvent events.BaseBusinessAccountEvent, value BusinessUsernameUpdateValue) error {
-	wh.EventManager.Publish(events.BusinessUsernameUpdateEventType, events.NewBusinessUsernameUpdateEvent(
+func (wh *WebhookManager) handleBusinessUsernameUpdateSubscriptionEvents(ctx context.Context, baseEvent events.BaseBusinessAccountEvent, value BusinessUsernameUpdateValue) error {
+	wh.publish(ctx, events.BusinessUsernameUpdateEventType, events.NewBusinessUsernameUpdateEvent(
 		&baseEvent,
 		value.Metadata.PhoneNumberId,
 		value.Username,
@@ -1101,3 +1184,67 @@ func buildPricingInfo(p Pricing) *events.MessagePricingInfo {
 		Category:     string(p.Category),
 	}
 }
+
+// buildStatusDetails converts the errors and conversation blocks of a status.
+// The conversation is nil when Meta sent none (per-message pricing).
+func buildStatusDetails(status Status) events.StatusDetails {
+	var details events.StatusDetails
+	for _, err := range status.Errors {
+		details.Errors = append(details.Errors, events.StatusError{
+			Code:    err.Code,
+			Title:   err.Title,
+			Message: err.Message,
+			Details: err.ErrorData.Details,
+			Href:    err.Href,
+		})
+	}
+	if conversation := status.Conversation; conversation.Id != "" {
+		expiration := conversation.ExpirationTimestamp
+		if expiration == "" {
+			expiration = conversation.Origin.ExpirationTimestamp
+		}
+		details.Conversation = &events.StatusConversation{
+			Id:                  conversation.Id,
+			OriginType:          string(conversation.Origin.Type),
+			ExpirationTimestamp: expiration,
+		}
+	}
+	return details
+}
+
+// adSourceOf converts the Click-to-WhatsApp referral of a message.
+func adSourceOf(referral *ReferralInfo) *events.AdSource {
+	mediaUrl := referral.ImageUrl
+	if referral.VideoUrl != "" {
+		mediaUrl = referral.VideoUrl
+	}
+	return &events.AdSource{
+		Url:          referral.SourceUrl,
+		Id:           referral.SourceId,
+		Type:         events.AdInteractionSourceType(referral.SourceType),
+		Title:        referral.Headline,
+		Description:  referral.Body,
+		MediaUrl:     mediaUrl,
+		MediaType:    events.AdInteractionSourceMediaType(referral.MediaType),
+		ThumbnailUrl: referral.ThumbnailUrl,
+		CtwaClid:     referral.CtwaClid,
+	}
+}
+
+// messageText returns what the customer wrote in a message: the text body, a
+// media caption or the button text. It is empty for messages without text.
+func messageText(message *Message) string {
+	switch message.Type {
+	case NotificationMessageTypeText:
+		return message.Text.Body
+	case NotificationMessageTypeImage:
+		return message.Image.Caption
+	case NotificationMessageTypeVideo:
+		return message.Video.Caption
+	case NotificationMessageTypeDocument:
+		return message.Document.Caption
+	case NotificationMessageTypeButton:
+		return message.Button.Text
+	}
+	return ""
+}
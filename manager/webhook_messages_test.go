@@ -0,0 +1,145 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/events"
+)
+
+// messagesPayload wraps message objects in a messages webhook delivery.
+func messagesPayload(messages string) string {
+	return `{"object":"whatsapp_business_account","entry":[{"id":"waba-1","changes":[{"field":"messages","value":{
+		"messaging_product":"whatsapp","metadata":{"display_phone_number":"1","phone_number_id":"pn-1"},
+		"contacts":[{"wa_id":"911","profile":{"name":"Ravi"}}],
+		"messages":[` + messages + `]}}]}]}`
+}
+
+// receiveOne posts payload and returns the first event published.
+func receiveOne(t *testing.T, payload string) events.BaseEvent {
+	t.Helper()
+	em := NewEventManager()
+	received := make(chan events.BaseEvent, 4)
+	em.OnAll(func(e events.BaseEvent) { received <- e })
+	postWebhook(t, newTestWebhook(em), payload)
+	select {
+	case event := <-received:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("event not published")
+		return nil
+	}
+}
+
+func TestTextReplyToProductIsProductInquiry(t *testing.T) {
+	em := NewEventManager()
+	received := make(chan events.BaseEvent, 4)
+	em.OnAll(func(e events.BaseEvent) { received <- e })
+	postWebhook(t, newTestWebhook(em), messagesPayload(`{"from":"911","id":"wamid.1","timestamp":"1","type":"text",
+		"text":{"body":"Is this in stock?"},
+		"context":{"from":"1","id":"wamid.0","referred_product":{"catalog_id":"cat-1","product_retailer_id":"sku-1"}}}`))
+	postWebhook(t, newTestWebhook(em), messagesPayload(`{"from":"911","id":"wamid.2","timestamp":"2","type":"text","text":{"body":"hi"}}`))
+
+	inquiry, ok := (<-received).(*events.ProductInquiryEvent)
+	if !ok {
+		t.Fatal("the reply to the product is not a ProductInquiryEvent")
+	}
+	if inquiry.CatalogId != "cat-1" || inquiry.ProductId != "sku-1" || inquiry.Text != "Is this in stock?" {
+		t.Fatalf("unexpected inquiry %+v", inquiry)
+	}
+	// The inquiry replaces the TextMessageEvent: the next event is the plain
+	// text sent after it.
+	if text, ok := (<-received).(*events.TextMessageEvent); !ok || text.MessageId != "wamid.2" {
+		t.Fatalf("got %#v after the inquiry", text)
+	}
+}
+
+func TestPlainTextIsTextMessage(t *testing.T) {
+	event := receiveOne(t, messagesPayload(`{"from":"911","id":"wamid.1","timestamp":"1","type":"text","text":{"body":"hi"}}`))
+	if _, ok := event.(*events.TextMessageEvent); !ok {
+		t.Fatalf("got %T, want *events.TextMessageEvent", event)
+	}
+}
+
+func TestReferralIsAttachedToEveryMessageType(t *testing.T) {
+	em := NewEventManager()
+	received := make(chan events.BaseEvent, 4)
+	em.OnAll(func(e events.BaseEvent) { received <- e })
+	postWebhook(t, newTestWebhook(em), messagesPayload(`{"from":"911","id":"wamid.1","timestamp":"1","type":"image",
+		"image":{"id":"media-1","mime_type":"image/jpeg","sha256":"x","caption":"Saw your ad"},
+		"referral":{"source_url":"https://fb.me/ad","source_type":"ad","source_id":"ad-1","headline":"Sale",
+			"media_type":"image","image_url":"https://cdn/ad.jpg","ctwa_clid":"clid-1"}}`))
+
+	var sawImage, sawAd bool
+	for i := 0; i < 2; i++ {
+		select {
+		case event := <-received:
+			switch event := event.(type) {
+			case *events.AdInteractionEvent:
+				sawAd = true
+				if event.Text != "Saw your ad" {
+					t.Fatalf("ad interaction text=%q, want the image caption", event.Text)
+				}
+			case *events.ImageMessageEvent:
+				sawImage = true
+				referral := event.Referral
+				if referral == nil || referral.CtwaClid != "clid-1" || referral.Id != "ad-1" || referral.MediaUrl != "https://cdn/ad.jpg" {
+					t.Fatalf("image event referral=%+v", referral)
+				}
+			default:
+				t.Fatalf("unexpected %T", event)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("event not published")
+		}
+	}
+	if !sawImage || !sawAd {
+		t.Fatalf("image=%v ad=%v", sawImage, sawAd)
+	}
+}
+
+// statusesPayload wraps status objects in a messages webhook delivery.
+func statusesPayload(statuses string) string {
+	return `{"object":"whatsapp_business_account","entry":[{"id":"waba-1","changes":[{"field":"messages","value":{
+		"messaging_product":"whatsapp","metadata":{"display_phone_number":"1","phone_number_id":"pn-1"},
+		"statuses":[` + statuses + `]}}]}]}`
+}
+
+func TestFailedStatusKeepsEveryErrorAndConversation(t *testing.T) {
+	event := receiveOne(t, statusesPayload(`{"id":"wamid.1","status":"failed","timestamp":"1","recipient_id":"911",
+		"conversation":{"id":"conv-1","origin":{"type":"marketing","expiration_timestamp":"1700000000"}},
+		"errors":[
+			{"code":131047,"title":"Re-engagement message","message":"Re-engagement message","href":"https://developers.facebook.com/docs","error_data":{"details":"More than 24 hours have passed"}},
+			{"code":131026,"title":"Message undeliverable"}]}`))
+
+	failed, ok := event.(*events.MessageFailedEvent)
+	if !ok {
+		t.Fatalf("got %T, want *events.MessageFailedEvent", event)
+	}
+	if len(failed.Errors) != 2 || failed.Errors[0].Details != "More than 24 hours have passed" || failed.Errors[1].Code != 131026 {
+		t.Fatalf("errors=%+v", failed.Errors)
+	}
+	if failed.ErrorCode != 131047 || failed.FailReason != "Re-engagement message" {
+		t.Fatalf("first error fields: code=%d reason=%q", failed.ErrorCode, failed.FailReason)
+	}
+	conversation := failed.Conversation
+	if conversation == nil || conversation.Id != "conv-1" || conversation.OriginType != "marketing" || conversation.ExpirationTimestamp != "1700000000" {
+		t.Fatalf("conversation=%+v", conversation)
+	}
+}
+
+func TestDeliveredStatusCarriesErrorFields(t *testing.T) {
+	event := receiveOne(t, statusesPayload(`{"id":"wamid.1","status":"delivered","timestamp":"1","recipient_id":"911",
+		"errors":[{"code":131053,"title":"Media upload error","message":"Media upload error"}]}`))
+
+	delivered, ok := event.(*events.MessageDeliveredEvent)
+	if !ok {
+		t.Fatalf("got %T, want *events.MessageDeliveredEvent", event)
+	}
+	if delivered.ErrorCode != 131053 || delivered.ErrorMessage != "Media upload error" || len(delivered.Errors) != 1 {
+		t.Fatalf("unexpected delivered event %+v", delivered)
+	}
+	if delivered.Conversation != nil {
+		t.Fatalf("conversation=%+v, want nil", delivered.Conversation)
+	}
+}
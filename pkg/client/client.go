@@ -5,6 +5,7 @@ import (
 	"github.com/wapikit/wapi.go/internal/request_client"
 	"github.com/wapikit/wapi.go/manager"
 	"github.com/wapikit/wapi.go/pkg/business"
+	"github.com/wapikit/wapi.go/pkg/components"
 	"github.com/wapikit/wapi.go/pkg/events"
 	"github.com/wapikit/wapi.go/pkg/messaging"
 )
@@ -17,6 +18,25 @@ type ClientConfig struct {
 	// these two are not required, because may be user want to use their own server
 	WebhookPath       string
 	WebhookServerPort int
+
+	// Events configures the event queues: their size and what happens when a
+	// handler falls behind. The zero value keeps 100 slots per queue and drops
+	// new events once a queue is full.
+	Events manager.EventManagerConfig
+
+	// ReplyTargetPreference decides whether event replies go to the phone
+	// number or the BSUID when a sender has both. Defaults to the phone number.
+	ReplyTargetPreference components.TargetPreference
+
+	// SplitLongMessages makes sends of the messaging clients and event
+	// replies split texts over 4096 characters and captions over 1024 into a
+	// sequence of messages instead of having them rejected.
+	SplitLongMessages bool
+
+	// PairRate, when set, paces the sends of the messaging clients and event
+	// replies per recipient so one user is not messaged faster than the API
+	// allows (error 131056). Messages to other users are not held up.
+	PairRate *manager.PairRateConfig
 }
 
 type Client struct {
@@ -26,25 +46,36 @@ type Client struct {
 	webhook      *manager.WebhookManager     // webhook is the webhook manager.
 	requester    *request_client.RequestClient
 
+	splitLongMessages bool
+	pairPacer         *manager.PairPacer
+
 	apiAccessToken    string
 	businessAccountId string
 }
 
 func New(config *ClientConfig) *Client {
-	eventManager := *manager.NewEventManager()
+	eventManager := manager.NewEventManagerWithConfig(config.Events)
 	requester := *request_client.NewRequestClient(config.ApiAccessToken)
+	webhookConfig := &manager.WebhookManagerConfig{Path: config.WebhookPath, Secret: config.WebhookSecret, Port: config.WebhookServerPort, EventManager: eventManager, Requester: requester, ReplyTargetPreference: config.ReplyTargetPreference, SplitLongMessages: config.SplitLongMessages}
+	var pairPacer *manager.PairPacer
+	if config.PairRate != nil {
+		pairPacer = manager.NewPairPacer(*config.PairRate)
+		webhookConfig.SendPacer = pairPacer
+	}
 	return &Client{
 		businessAccountId: config.BusinessAccountId,
 		apiAccessToken:    config.ApiAccessToken,
 		Messaging:         []messaging.MessagingClient{},
-		eventManager:      &eventManager,
+		eventManager:      eventManager,
 		Business: *business.NewBusinessClient(&business.BusinessClientConfig{
 			BusinessAccountId: config.BusinessAccountId,
 			AccessToken:       config.ApiAccessToken,
 			Requester:         &requester,
 		}),
-		webhook:   manager.NewWebhook(&manager.WebhookManagerConfig{Path: config.WebhookPath, Secret: config.WebhookSecret, Port: config.WebhookServerPort, EventManager: eventManager, Requester: requester}),
-		requester: &requester,
+		webhook:           manager.NewWebhook(webhookConfig),
+		requester:         &requester,
+		splitLongMessages: config.SplitLongMessages,
+		pairPacer:         pairPacer,
 	}
 }
 
@@ -60,6 +91,10 @@ func (client *Client) NewMessagingClient(phoneNumberId string) *messaging.Messag
 		BusinessAccountId: client.businessAccountId,
 		Requester:         client.requester,
 	}
+	messagingClient.Message.SetSplitLongMessages(client.splitLongMessages)
+	if client.pairPacer != nil {
+		messagingClient.Message.SetPairPacer(client.pairPacer)
+	}
 
 	client.Messaging = append(client.Messaging, *messagingClient)
 	return messagingClient
@@ -81,6 +116,130 @@ func (client *Client) On(eventType events.EventType, handler func(events.BaseEve
 		EventManager.On(eventType, handler)
 }
 
+// OnCategory registers a handler for every event of a category, e.g. all
+// inbound messages (events.EventCategoryMessage) or all statuses.
+func (client *Client) OnCategory(category events.EventCategory, handler func(events.BaseEvent)) manager.SubscriptionId {
+	return client.webhook.EventManager.OnCategory(category, handler)
+}
+
+// OnAll registers a handler for every event.
+func (client *Client) OnAll(handler func(events.BaseEvent)) manager.SubscriptionId {
+	return client.webhook.EventManager.OnAll(handler)
+}
+
+// OnMatch registers a handler for every event accepted by the predicate.
+func (client *Client) OnMatch(predicate manager.EventFilter, handler func(events.BaseEvent)) manager.SubscriptionId {
+	return client.webhook.EventManager.OnMatch(predicate, handler)
+}
+
+// Handle registers a handler that can fail. Failed events are retried as
+// configured in options and then kept in the dead-letter store.
+func (client *Client) Handle(eventType events.EventType, handler manager.EventHandler, options manager.HandlerOptions) manager.SubscriptionId {
+	return client.webhook.EventManager.Handle(eventType, handler, options)
+}
+
+// DeadLetters returns the store of events that Handle handlers gave up on.
+func (client *Client) DeadLetters() manager.DeadLetterStore {
+	return client.webhook.EventManager.DeadLetters()
+}
+
+// Redrive hands a dead-lettered event back to its handler.
+func (client *Client) Redrive(id string) error {
+	return client.webhook.EventManager.Redrive(id)
+}
+
+// AddSink forwards every event to the sink, e.g. a file, an HTTP endpoint or
+// a broker client.
+func (client *Client) AddSink(sink manager.EventSink, options manager.HandlerOptions) manager.SubscriptionId {
+	return client.webhook.EventManager.AddSink(sink, options)
+}
+
+// TrackDeliveries starts a delivery tracker on the webhook's status events,
+// keeping its state in store (in memory when nil).
+func (client *Client) TrackDeliveries(store manager.DeliveryStore) *manager.DeliveryTracker {
+	tracker := manager.NewDeliveryTracker(client.webhook.EventManager, store)
+	tracker.Attach()
+	return tracker
+}
+
+// TrackServiceWindows starts a customer service window tracker on the
+// webhook's message and status events, keeping its state in store (in memory
+// when nil). Pass it to MessageManager.SetServiceWindow to check sends.
+func (client *Client) TrackServiceWindows(store manager.ServiceWindowStore) *manager.ServiceWindowTracker {
+	tracker := manager.NewServiceWindowTracker(store)
+	tracker.Attach(client.webhook.EventManager)
+	return tracker
+}
+
+// RecordTranscript starts a transcript of the webhook's inbound messages and
+// status updates, kept in store (in memory when nil). Event replies are
+// recorded too and resolve RepliedTo. Pass it to MessageManager.SetTranscript
+// to record the sends of a messaging client as well.
+func (client *Client) RecordTranscript(store manager.TranscriptStore) *manager.Transcript {
+	transcript := manager.NewTranscript(store)
+	transcript.Attach(client.webhook.EventManager)
+	client.webhook.SetSentMessages(transcript)
+	return transcript
+}
+
+// TrackConsent starts a marketing consent registry on the webhook's user
+// preference updates and opt-in and opt-out keywords, keeping its records in
+// store (in memory when nil). Pass it to MessageManager.SetConsent to refuse
+// marketing sends to users without consent.
+func (client *Client) TrackConsent(store manager.ConsentStore, config manager.ConsentConfig) *manager.ConsentRegistry {
+	registry := manager.NewConsentRegistry(store, config)
+	registry.Attach(client.webhook.EventManager)
+	return registry
+}
+
+// TrackMessagingLimits starts a messaging limit tracker keeping its sends in
+// store (in memory when nil). Tiers are fetched with the business client's
+// phone number manager unless config sets one, on the first send of every
+// phone number, and refreshed on the webhook's phone number quality updates;
+// warnings are published on the webhook's event manager. Pass it to
+// MessageManager.SetMessagingLimits to count sends.
+func (client *Client) TrackMessagingLimits(store manager.MessagingLimitStore, config manager.MessagingLimitConfig) *manager.MessagingLimitTracker {
+	if config.PhoneNumbers == nil {
+		config.PhoneNumbers = client.Business.PhoneNumber
+	}
+	tracker := manager.NewMessagingLimitTracker(store, config)
+	tracker.Attach(client.webhook.EventManager)
+	return tracker
+}
+
+// NewOutbox creates an outbox for queued sends, persisted to store or, when
+// store is nil, to a file at config.Path. Pass it to MessageManager.SetOutbox
+// and start draining it with Run.
+func (client *Client) NewOutbox(store manager.OutboxStore, config manager.OutboxConfig) (*manager.Outbox, error) {
+	return manager.NewOutbox(*client.requester, store, config)
+}
+
+// NewScheduler creates a scheduler for delayed sends through messageManager,
+// persisted to store or, when store is nil, to a file at config.Path. It is
+// attached to the webhook's message events so SkipIfReplied jobs are skipped
+// when the recipient writes. Start sending due jobs with Run.
+func (client *Client) NewScheduler(messageManager *manager.MessageManager, store manager.ScheduleStore, config manager.SchedulerConfig) (*manager.Scheduler, error) {
+	scheduler, err := manager.NewScheduler(messageManager, store, config)
+	if err != nil {
+		return nil, err
+	}
+	scheduler.Attach(client.webhook.EventManager)
+	return scheduler, nil
+}
+
+// SetReengagementPolicy makes the messaging client send policy.FallbackTemplate
+// when a free-form send is refused because the customer service window is
+// closed, and send the original once the user replies on the webhook. See
+// MessageManager.SetReengagementPolicy.
+func (client *Client) SetReengagementPolicy(messagingClient *messaging.MessagingClient, policy manager.ReengagementPolicy) (manager.SubscriptionId, error) {
+	return messagingClient.Message.SetReengagementPolicy(client.webhook.EventManager, policy)
+}
+
+// Off removes a subscription created by OnCategory, OnAll, OnMatch, Handle or AddSink.
+func (client *Client) Off(id manager.SubscriptionId) {
+	client.webhook.EventManager.Off(id)
+}
+
 // InitiateClient initializes the client and starts listening to events from the webhook.
 // It returns true if the client was successfully initiated.
 func (client *Client) Initiate() bool {
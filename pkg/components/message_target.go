@@ -0,0 +1,77 @@
+package components
+
+// MessageTargetType is how a send is addressed. Phone targets serialize as
+// Meta's `to`; BSUID and parent-BSUID targets serialize as `recipient` (Meta
+// business-scoped user id send contract).
+type MessageTargetType string
+
+const (
+	MessageTargetTypePhone       MessageTargetType = "phone"
+	MessageTargetTypeBSUID       MessageTargetType = "bsuid"
+	MessageTargetTypeParentBSUID MessageTargetType = "parent_bsuid"
+)
+
+// MessageTarget identifies who a message is sent to, independent of message
+// type. Use the constructors below; the zero value is not a valid target.
+type MessageTarget struct {
+	Type  MessageTargetType
+	Value string
+}
+
+// NewPhoneTarget targets a recipient by phone number (serialized as `to`). This
+// is the historical default and keeps existing behavior byte-for-byte.
+func NewPhoneTarget(phoneNumber string) MessageTarget {
+	return MessageTarget{Type: MessageTargetTypePhone, Value: phoneNumber}
+}
+
+// NewBSUIDTarget targets a recipient by business-scoped user id (serialized as
+// `recipient`).
+func NewBSUIDTarget(bsuid string) MessageTarget {
+	return MessageTarget{Type: MessageTargetTypeBSUID, Value: bsuid}
+}
+
+// NewParentBSUIDTarget targets a recipient by parent business-scoped user id
+// (serialized as `recipient`).
+func NewParentBSUIDTarget(parentBSUID string) MessageTarget {
+	return MessageTarget{Type: MessageTargetTypeParentBSUID, Value: parentBSUID}
+}
+
+// Configs builds the send configs for this target: phone → `to`, BSUID/parent →
+// `recipient`. replyTo is optional (empty for a non-reply send).
+func (t MessageTarget) Configs(replyTo string) ApiCompatibleJsonConverterConfigs {
+	configs := ApiCompatibleJsonConverterConfigs{ReplyToMessageId: replyTo}
+	switch t.Type {
+	case MessageTargetTypePhone:
+		configs.SendToPhoneNumber = t.Value
+	default: // bsuid, parent_bsuid
+		configs.SendToRecipient = t.Value
+	}
+	return configs
+}
+
+// TargetPreference decides which identifier ChooseTarget uses when a user
+// exposes both a phone number and a BSUID.
+type TargetPreference string
+
+const (
+	// TargetPreferencePhone addresses users by phone whenever it is known
+	// (default, matches the historical behavior).
+	TargetPreferencePhone TargetPreference = "phone"
+	// TargetPreferenceBSUID addresses users by BSUID whenever it is known.
+	TargetPreferenceBSUID TargetPreference = "bsuid"
+)
+
+// ChooseTarget picks the target for a user from whatever identifiers are
+// known: the preferred one of phone and BSUID, then the other, then the parent
+// BSUID. It reports false when all identifiers are empty.
+func ChooseTarget(preference TargetPreference, phoneNumber, bsuid, parentBSUID string) (MessageTarget, bool) {
+	switch {
+	case phoneNumber != "" && (preference != TargetPreferenceBSUID || bsuid == ""):
+		return NewPhoneTarget(phoneNumber), true
+	case bsuid != "":
+		return NewBSUIDTarget(bsuid), true
+	case parentBSUID != "":
+		return NewParentBSUIDTarget(parentBSUID), true
+	}
+	return MessageTarget{}, false
+}
@@ -0,0 +1,37 @@
+package components
+
+// MessageSendResponse represents the structured API response for sending a message.
+type MessageSendResponse struct {
+	MessagingProduct string `json:"messaging_product"`
+	Contacts         []struct {
+		Input string `json:"input"`
+		WaID  string `json:"wa_id"`
+		// UserId is the recipient BSUID when the send targeted a BSUID
+		// (identity rollout). Empty for phone-targeted sends — do not treat
+		// its absence as a parse failure.
+		UserId string `json:"user_id,omitempty"`
+	} `json:"contacts"`
+	Messages []struct {
+		ID string `json:"id"`
+	} `json:"messages"`
+	Error *MessageSendError `json:"error,omitempty"`
+}
+
+// MessageSendError represents the error object in an API response.
+type MessageSendError struct {
+	Message   string `json:"message"` // Error description.
+	Type      string `json:"type"`    // Error type (e.g., OAuthException).
+	Code      int    `json:"code"`    // Error code.
+	ErrorData struct {
+		MessagingProduct string `json:"messaging_product"`
+		Details          string `json:"details"`
+	} `json:"error_data"` // Additional error details.
+	ErrorSubcode int    `json:"error_subcode"`
+	FbtraceID    string `json:"fbtrace_id"`
+}
+
+// StatusResponse represents the API response for status updates (read receipts).
+type StatusResponse struct {
+	Success bool              `json:"success"`
+	Error   *MessageSendError `json:"error,omitempty"`
+}
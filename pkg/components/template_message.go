@@ -3,6 +3,7 @@ package components
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/wapikit/wapi.go/internal"
 )
@@ -219,6 +220,20 @@ type TemplateMessage struct {
 	Name       string                     `json:"name" validate:"required"`       // Template name.
 	Language   TemplateMessageLanguage    `json:"language" validate:"required"`   // Language configuration.
 	Components []TemplateMessageComponent `json:"components" validate:"required"` // Array of components.
+	// Category is the category the template was approved in, e.g.
+	// TemplateCategoryMarketing. It is not sent; senders use it to apply the
+	// rules of the category, such as marketing consent.
+	Category string `json:"-"`
+}
+
+// TemplateCategoryMarketing is the Category of marketing templates.
+const TemplateCategoryMarketing = "MARKETING"
+
+// IsMarketing reports whether the message is a template of the marketing
+// category.
+func IsMarketing(message BaseMessage) bool {
+	template, ok := message.(*TemplateMessage)
+	return ok && strings.EqualFold(template.Category, TemplateCategoryMarketing)
 }
 
 // TemplateMessageApiPayload represents the API payload for sending a template message.
@@ -231,6 +246,7 @@ type TemplateMessageApiPayload struct {
 type TemplateMessageConfigs struct {
 	Name     string `json:"name" validate:"required"`     // Template name.
 	Language string `json:"language" validate:"required"` // Language code.
+	Category string `json:"category,omitempty"`           // Category of the template, e.g. TemplateCategoryMarketing.
 }
 
 // NewTemplateMessage creates a new TemplateMessage instance.
@@ -241,6 +257,7 @@ func NewTemplateMessage(params *TemplateMessageConfigs) (*TemplateMessage, error
 			Code:   params.Language,
 			Policy: "deterministic",
 		},
+		Category: params.Category,
 	}, nil
 }
 
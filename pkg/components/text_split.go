@@ -0,0 +1,268 @@
+package components
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxTextBodyLength is the longest text message body the API accepts, in
+// characters.
+const MaxTextBodyLength = 4096
+
+// MaxCaptionLength is the longest media caption the API accepts, in characters.
+const MaxCaptionLength = 1024
+
+// SplitMessage returns the message as a sequence of messages the API accepts.
+// A text message with a body over MaxTextBodyLength becomes several text
+// messages. An image, video or document with a caption over MaxCaptionLength
+// keeps the first part of the caption and is followed by text messages with the
+// rest. Any other message is returned as is.
+func SplitMessage(message BaseMessage) []BaseMessage {
+	switch m := message.(type) {
+	case *textMessage:
+		parts := SplitText(m.Text, MaxTextBodyLength)
+		if len(parts) == 1 {
+			return []BaseMessage{message}
+		}
+		messages := make([]BaseMessage, len(parts))
+		for i, part := range parts {
+			messages[i] = &textMessage{Text: part, AllowPreview: m.AllowPreview}
+		}
+		return messages
+	case *ImageMessage:
+		caption, rest := splitCaption(m.Caption)
+		if rest == nil {
+			return []BaseMessage{message}
+		}
+		image := *m
+		image.Caption = caption
+		return append([]BaseMessage{&image}, rest...)
+	case *VideoMessage:
+		caption, rest := splitCaption(m.Caption)
+		if rest == nil {
+			return []BaseMessage{message}
+		}
+		video := *m
+		video.Caption = caption
+		return append([]BaseMessage{&video}, rest...)
+	case *DocumentMessage:
+		if m.Caption == nil {
+			return []BaseMessage{message}
+		}
+		caption, rest := splitCaption(*m.Caption)
+		if rest == nil {
+			return []BaseMessage{message}
+		}
+		document := *m
+		document.Caption = &caption
+		return append([]BaseMessage{&document}, rest...)
+	}
+	return []BaseMessage{message}
+}
+
+// splitCaption returns the part of the caption that fits MaxCaptionLength and
+// text messages with the rest, or nil when the caption fits.
+func splitCaption(caption string) (string, []BaseMessage) {
+	if utf8.RuneCountInString(caption) <= MaxCaptionLength {
+		return caption, nil
+	}
+	first, rest := cutText([]rune(caption), MaxCaptionLength)
+	var messages []BaseMessage
+	for _, part := range SplitText(string(rest), MaxTextBodyLength) {
+		if part != "" {
+			messages = append(messages, &textMessage{Text: part})
+		}
+	}
+	return first, messages
+}
+
+// SplitText breaks text into parts of at most limit characters. It cuts at
+// the last paragraph, line or sentence break in the second half of a part,
+// else at the last space, else anywhere, but never inside a grapheme cluster
+// (combining marks, emoji sequences, flags). Cuts avoid WhatsApp formatting
+// spans (*bold*, _italic_, ~strikethrough~, `code`, ```monospace```); a span
+// that must be cut is closed at the end of the part and reopened at the start
+// of the next. Whitespace at the cuts is dropped. Text that fits is returned as
+// its only part.
+func SplitText(text string, limit int) []string {
+	if limit <= 0 || utf8.RuneCountInString(text) <= limit {
+		return []string{text}
+	}
+	runes := []rune(text)
+	var parts []string
+	for len(runes) > limit {
+		var part string
+		part, runes = cutText(runes, limit)
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(runes) > 0 {
+		parts = append(parts, string(runes))
+	}
+	return parts
+}
+
+// cutText returns the first part of runes, at most limit characters long, and
+// the rest.
+func cutText(runes []rune, limit int) (string, []rune) {
+	cut, closing, opening := splitPoint(runes, limit)
+	part := strings.TrimRightFunc(string(runes[:cut]), unicode.IsSpace) + closing
+	rest := runes[cut:]
+	for len(rest) > 0 && unicode.IsSpace(rest[0]) {
+		rest = rest[1:]
+	}
+	if opening != "" {
+		rest = append([]rune(opening), rest...)
+	}
+	return part, rest
+}
+
+// breakLevels are the places to cut at, most preferred first. A break at p
+// cuts between runes[p-1] and runes[p].
+var breakLevels = []func(runes []rune, p int) bool{
+	func(runes []rune, p int) bool { // paragraph
+		return p >= 2 && runes[p-1] == '\n' && runes[p-2] == '\n'
+	},
+	func(runes []rune, p int) bool { // line
+		return runes[p-1] == '\n'
+	},
+	func(runes []rune, p int) bool { // sentence
+		return p >= 2 && unicode.IsSpace(runes[p-1]) && strings.ContainsRune(".!?…", runes[p-2])
+	},
+}
+
+// splitPoint returns where to cut runes for a part of at most limit
+// characters, with the markers that close the formatting spans open at the cut
+// and reopen them after it.
+func splitPoint(runes []rune, limit int) (int, string, string) {
+	spans := formattingSpans(runes)
+	usable := func(p int) bool {
+		return graphemeBoundary(runes, p) && len(spansAt(spans, p)) == 0
+	}
+	for _, isBreak := range breakLevels {
+		for p := limit; p > limit/2; p-- {
+			if isBreak(runes, p) && usable(p) {
+				return p, "", ""
+			}
+		}
+	}
+	for p := limit; p > 0; p-- {
+		if unicode.IsSpace(runes[p-1]) && usable(p) {
+			return p, "", ""
+		}
+	}
+	for p := limit; p > 0; p-- {
+		if !graphemeBoundary(runes, p) {
+			continue
+		}
+		open := spansAt(spans, p)
+		var closing, opening string
+		fits := true
+		for _, span := range open {
+			opening += span.marker
+			closing = span.marker + closing
+			fits = fits && p > span.start+utf8.RuneCountInString(span.marker)
+		}
+		if fits && p+utf8.RuneCountInString(closing) <= limit {
+			return p, closing, opening
+		}
+	}
+	return limit, "", ""
+}
+
+// formattingSpan is a run of WhatsApp formatting, markers included.
+type formattingSpan struct {
+	start, end int
+	marker     string
+}
+
+// formattingSpans finds the formatting spans of runes, outermost first. A
+// marker opens a span when it does not follow a letter or digit and is followed
+// by a non-space; it is closed by the same marker on the same line following a
+// non-space and not followed by a letter or digit. Monospace blocks may span
+// lines and contain no further formatting.
+func formattingSpans(runes []rune) []formattingSpan {
+	var spans []formattingSpan
+	isWord := func(i int) bool {
+		return i >= 0 && i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]))
+	}
+	for i := 0; i < len(runes); i++ {
+		if strings.HasPrefix(string(runes[i:min(i+3, len(runes))]), "```") {
+			if end := indexRunes(runes, i+3, "```"); end >= 0 {
+				spans = append(spans, formattingSpan{start: i, end: end + 3, marker: "```"})
+				i = end + 2
+				continue
+			}
+		}
+		marker := runes[i]
+		if !strings.ContainsRune("*_~`", marker) || isWord(i-1) || i+1 >= len(runes) || unicode.IsSpace(runes[i+1]) || runes[i+1] == marker {
+			continue
+		}
+		for j := i + 2; j < len(runes) && runes[j] != '\n'; j++ {
+			if runes[j] == marker && !unicode.IsSpace(runes[j-1]) && !isWord(j+1) {
+				spans = append(spans, formattingSpan{start: i, end: j + 1, marker: string(marker)})
+				break
+			}
+		}
+	}
+	return spans
+}
+
+// spansAt returns the spans a cut at p would break, outermost first.
+func spansAt(spans []formattingSpan, p int) []formattingSpan {
+	var open []formattingSpan
+	for _, span := range spans {
+		if span.start < p && p < span.end {
+			open = append(open, span)
+		}
+	}
+	return open
+}
+
+// indexRunes returns the index of the first occurrence of substr in runes at
+// or after from, or -1.
+func indexRunes(runes []rune, from int, substr string) int {
+	needle := []rune(substr)
+	for i := from; i+len(needle) <= len(runes); i++ {
+		if string(runes[i:i+len(needle)]) == substr {
+			return i
+		}
+	}
+	return -1
+}
+
+// graphemeBoundary reports whether a cut between runes[p-1] and runes[p] keeps
+// grapheme clusters whole. It covers what chat text contains in practice:
+// CRLF, combining marks, zero width joiner sequences, variation selectors,
+// emoji modifiers and tags, and regional indicator (flag) pairs.
+func graphemeBoundary(runes []rune, p int) bool {
+	if p <= 0 || p >= len(runes) {
+		return true
+	}
+	previous, next := runes[p-1], runes[p]
+	switch {
+	case previous == '\r' && next == '\n':
+		return false
+	case previous == '\u200d' || next == '\u200d':
+		return false
+	case unicode.In(next, unicode.Mn, unicode.Me, unicode.Mc):
+		return false
+	case next >= '\ufe00' && next <= '\ufe0f', next >= 0xe0100 && next <= 0xe01ef:
+		return false
+	case next >= 0x1f3fb && next <= 0x1f3ff, next >= 0xe0020 && next <= 0xe007f:
+		return false
+	case isRegionalIndicator(previous) && isRegionalIndicator(next):
+		count := 0
+		for i := p - 1; i >= 0 && isRegionalIndicator(runes[i]); i-- {
+			count++
+		}
+		return count%2 == 0
+	}
+	return true
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
@@ -0,0 +1,28 @@
+package components
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSplitTextKeepsFormattingAndGraphemes(t *testing.T) {
+	cases := []struct {
+		text  string
+		limit int
+		want  []string
+	}{
+		{"one two three four", 10, []string{"one two", "three four"}},
+		{"First one. Second one here", 16, []string{"First one.", "Second one here"}},
+		{"go *bold words here* end", 12, []string{"go", "*bold words*", "*here* end"}},
+		{"```a b c d e f```", 9, []string{"```a b```", "```c d```", "```e f```"}},
+		{"ab🇮🇳🇩🇪", 3, []string{"ab", "🇮🇳", "🇩🇪"}},
+		{"e\u0301e\u0301e\u0301", 3, []string{"e\u0301", "e\u0301", "e\u0301"}},
+		{"short", 10, []string{"short"}},
+	}
+	for _, c := range cases {
+		got := SplitText(c.text, c.limit)
+		if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", c.want) {
+			t.Errorf("SplitText(%q, %d) = %q, want %q", c.text, c.limit, got, c.want)
+		}
+	}
+}
@@ -1,9 +1,6 @@
 package events
 
 import (
-	"net/http"
-	"strings"
-
 	"github.com/wapikit/wapi.go/internal/request_client"
 	"github.com/wapikit/wapi.go/pkg/components"
 )
@@ -18,8 +15,8 @@ type BaseEvent interface {
 
 type BaseMessageEventInterface interface {
 	BaseEvent
-	Reply() (string, error)
-	React() (string, error)
+	Reply(message components.BaseMessage) (*components.MessageSendResponse, error)
+	React(emoji string) (*components.MessageSendResponse, error)
 }
 
 type BaseSystemEventInterface interface {
@@ -36,6 +33,7 @@ type BusinessPhoneNumber struct {
 }
 
 type BaseMessageEvent struct {
+	eventContext
 	BusinessAccountId string `json:"business_account_id"`
 	requester         request_client.RequestClient
 	MessageId         string              `json:"message_id"`
@@ -55,6 +53,19 @@ type BaseMessageEvent struct {
 	FromUserId       string `json:"from_user_id,omitempty"`
 	FromParentUserId string `json:"from_parent_user_id,omitempty"`
 	Username         string `json:"username,omitempty"`
+	// Referral is the Click-to-WhatsApp ad the customer came from, set on
+	// every message type sent from the ad. Nil otherwise.
+	Referral *AdSource `json:"referral,omitempty"`
+	// targetPreference picks phone or BSUID for Target when the sender exposes
+	// both. Empty means phone. See SetTargetPreference.
+	targetPreference components.TargetPreference
+	// splitLongMessages makes Reply and the other send helpers split texts
+	// and captions over the API limits; see SetSplitLongMessages.
+	splitLongMessages bool
+	// sentMessages resolves RepliedTo and records replies; see SetSentMessages.
+	sentMessages SentMessageStore
+	// sendPacer paces replies per recipient; see SetSendPacer.
+	sendPacer SendPacer
 }
 
 type BaseMessageEventParams struct {
@@ -68,12 +79,17 @@ type BaseMessageEventParams struct {
 	Context           MessageContext // * this context will not be present if in case a message is a reply to another message
 	Requester         request_client.RequestClient
 	// Identity fields (BSUID/username rollout); all optional/additive.
-	WaId             string
-	UserId           string
-	ParentUserId     string
-	FromUserId       string
-	FromParentUserId string
-	Username         string
+	WaId              string
+	UserId            string
+	ParentUserId      string
+	FromUserId        string
+	FromParentUserId  string
+	Username          string
+	TargetPreference  components.TargetPreference
+	Referral          *AdSource
+	SplitLongMessages bool
+	SentMessages      SentMessageStore
+	SendPacer         SendPacer
 }
 
 func NewBaseMessageEvent(params BaseMessageEventParams) BaseMessageEvent {
@@ -93,6 +109,11 @@ func NewBaseMessageEvent(params BaseMessageEventParams) BaseMessageEvent {
 		FromUserId:        params.FromUserId,
 		FromParentUserId:  params.FromParentUserId,
 		Username:          params.Username,
+		Referral:          params.Referral,
+		targetPreference:  params.TargetPreference,
+		splitLongMessages: params.SplitLongMessages,
+		sentMessages:      params.SentMessages,
+		sendPacer:         params.SendPacer,
 	}
 }
 
@@ -100,36 +121,16 @@ func (bme BaseMessageEvent) GetEventType() string {
 	return "message"
 }
 
-// Reply to the message
-func (baseMessageEvent *BaseMessageEvent) Reply(Message components.BaseMessage) (string, error) {
-	body, err := Message.ToJson(components.ApiCompatibleJsonConverterConfigs{
-		SendToPhoneNumber: baseMessageEvent.From,
-		ReplyToMessageId:  baseMessageEvent.MessageId,
-	})
-
-	if err != nil {
-		return "", err
-	}
-
-	apiRequest := baseMessageEvent.requester.NewApiRequest(strings.Join([]string{baseMessageEvent.PhoneNumber.Id, "messages"}, "/"), http.MethodPost)
-	apiRequest.SetBody(string(body))
-	apiRequest.Execute()
-
-	return "", nil
-
+// Category returns EventCategoryMessage for every inbound message event.
+func (bme BaseMessageEvent) Category() EventCategory {
+	return EventCategoryMessage
 }
 
-// React to the message
-func (baseMessageEvent *BaseMessageEvent) React(emoji string) (string, error) {
-	reactionMessage, err := components.NewReactionMessage(components.ReactionMessageParams{
-		Emoji:     emoji,
-		MessageId: baseMessageEvent.MessageId,
-	})
-	if err != nil {
-		return "", err
-	}
-	baseMessageEvent.Reply(reactionMessage)
-	return "", nil
+// SetRequester binds the requester used by Reply and React. The requester is
+// never serialized, so events rebuilt from their JSON form must be rebound
+// before they can reply.
+func (baseMessageEvent *BaseMessageEvent) SetRequester(requester request_client.RequestClient) {
+	baseMessageEvent.requester = requester
 }
 
 // BaseMediaMessageEvent represents a base media message event which contains media information.
@@ -141,6 +142,7 @@ type BaseMediaMessageEvent struct {
 }
 
 type BaseSystemEvent struct {
+	eventContext
 	Timestamp string `json:"timestamp"`
 }
 
@@ -148,7 +150,13 @@ func (bme BaseSystemEvent) GetEventType() string {
 	return "system"
 }
 
+// Category returns EventCategorySystem. Status events override it.
+func (bme BaseSystemEvent) Category() EventCategory {
+	return EventCategorySystem
+}
+
 type BaseBusinessAccountEvent struct {
+	eventContext
 	BusinessAccountId string `json:"business_account_id"`
 	Timestamp         string `json:"timestamp"`
 }
@@ -156,3 +164,8 @@ type BaseBusinessAccountEvent struct {
 func (bme BaseBusinessAccountEvent) GetEventType() string {
 	return "business_account"
 }
+
+// Category returns EventCategoryBusinessAccount for every business account event.
+func (bme BaseBusinessAccountEvent) Category() EventCategory {
+	return EventCategoryBusinessAccount
+}
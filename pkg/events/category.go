@@ -0,0 +1,35 @@
+package events
+
+// EventCategory groups event types by the base event they embed, so a single
+// subscription can cover every inbound message, every status update, etc.
+type EventCategory string
+
+const (
+	// EventCategoryMessage covers every inbound user message (BaseMessageEvent).
+	EventCategoryMessage EventCategory = "message"
+	// EventCategoryStatus covers outbound message status updates (sent,
+	// delivered, read, failed, undelivered).
+	EventCategoryStatus EventCategory = "status"
+	// EventCategorySystem covers the remaining BaseSystemEvent events (ready,
+	// number changes, user id updates).
+	EventCategorySystem EventCategory = "system"
+	// EventCategoryBusinessAccount covers BaseBusinessAccountEvent events
+	// (templates, phone numbers, account updates, ...).
+	EventCategoryBusinessAccount EventCategory = "business_account"
+)
+
+// CategorizedEvent is implemented by every event through its embedded base
+// event. Status events override the system category of BaseSystemEvent.
+type CategorizedEvent interface {
+	BaseEvent
+	Category() EventCategory
+}
+
+// CategoryOf returns the category of the given event, or an empty category
+// when the event does not embed one of the base events.
+func CategoryOf(event BaseEvent) EventCategory {
+	if categorized, ok := event.(CategorizedEvent); ok {
+		return categorized.Category()
+	}
+	return ""
+}
@@ -0,0 +1,83 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/wapikit/wapi.go/internal/request_client"
+)
+
+// EventEnvelopeVersion is the envelope version written by Marshal. Unmarshal
+// rejects envelopes from a newer version it cannot know the layout of.
+const EventEnvelopeVersion = 1
+
+// EventEnvelope is the serialized form of an event. Type is the canonical
+// event type and selects the struct Payload is decoded into. Metadata keeps the
+// trace of the event context across queues and storage.
+type EventEnvelope struct {
+	Type     EventType       `json:"type"`
+	Version  int             `json:"version"`
+	Payload  json.RawMessage `json:"payload"`
+	Metadata *EventMetadata  `json:"metadata,omitempty"`
+}
+
+// Marshal encodes any event of this package into a versioned envelope, e.g.
+// to put it on a queue or store it. The requester is not part of the encoding;
+// see Bind.
+func Marshal(event BaseEvent) ([]byte, error) {
+	eventType, ok := EventTypeOf(event)
+	if !ok {
+		return nil, fmt.Errorf("cannot marshal unregistered event %T", event)
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	envelope := EventEnvelope{
+		Type:    eventType,
+		Version: EventEnvelopeVersion,
+		Payload: payload,
+	}
+	if metadata, ok := EventMetadataFrom(ContextOf(event)); ok {
+		envelope.Metadata = &metadata
+	}
+	return json.Marshal(envelope)
+}
+
+// Unmarshal decodes an envelope written by Marshal into the same event struct,
+// as a pointer or a value exactly as the webhook publishes it, with a fresh
+// event context carrying the original metadata. Message events need Bind
+// before they can reply or react again.
+func Unmarshal(data []byte) (BaseEvent, error) {
+	var envelope EventEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, err
+	}
+	if envelope.Version < 1 || envelope.Version > EventEnvelopeVersion {
+		return nil, fmt.Errorf("unsupported event envelope version %d", envelope.Version)
+	}
+	event, err := NewEvent(envelope.Type)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(envelope.Payload, event); err != nil {
+		return nil, fmt.Errorf("decoding %s event: %w", envelope.Type, err)
+	}
+	if envelope.Metadata != nil {
+		WithContext(event, WithEventMetadata(context.Background(), *envelope.Metadata))
+	}
+	return ValueOf(event), nil
+}
+
+// Bind sets the requester a decoded event uses to reply and react. It reports
+// false for events that do not send anything.
+func Bind(event BaseEvent, requester request_client.RequestClient) bool {
+	bindable, ok := event.(interface {
+		SetRequester(request_client.RequestClient)
+	})
+	if ok {
+		bindable.SetRequester(requester)
+	}
+	return ok
+}
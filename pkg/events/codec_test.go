@@ -0,0 +1,102 @@
+package events
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wapikit/wapi.go/pkg/components"
+)
+
+func TestMarshalRoundTripsEveryEventType(t *testing.T) {
+	for _, registered := range eventRegistry {
+		event, err := NewEvent(registered.eventType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		event = ValueOf(event)
+		data, err := Marshal(event)
+		if err != nil {
+			t.Fatalf("%s: marshal: %v", registered.eventType, err)
+		}
+		decoded, err := Unmarshal(data)
+		if err != nil {
+			t.Fatalf("%s: unmarshal: %v", registered.eventType, err)
+		}
+		if reflect.TypeOf(decoded) != reflect.TypeOf(event) {
+			t.Fatalf("%s: decoded %T, want %T", registered.eventType, decoded, event)
+		}
+	}
+}
+
+func TestUnmarshalKeepsMessageFields(t *testing.T) {
+	event := NewTextMessageEvent(NewBaseMessageEvent(BaseMessageEventParams{
+		MessageId:   "wamid.1",
+		From:        "911",
+		PhoneNumber: BusinessPhoneNumber{Id: "123"},
+	}), "hello")
+	data, err := Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := decoded.(*TextMessageEvent)
+	if text.Text != "hello" || text.From != "911" || text.PhoneNumber.Id != "123" {
+		t.Fatalf("unexpected decoded event %+v", text)
+	}
+}
+
+func TestMarshalLeavesOutReplySettings(t *testing.T) {
+	event := NewTextMessageEvent(NewBaseMessageEvent(BaseMessageEventParams{
+		MessageId:         "wamid.1",
+		From:              "911",
+		TargetPreference:  components.TargetPreferenceBSUID,
+		SplitLongMessages: true,
+	}), "hello")
+	data, err := Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "target_preference") || strings.Contains(string(data), "split_long_messages") {
+		t.Fatalf("reply settings serialized: %s", data)
+	}
+}
+
+func TestUnmarshalRejectsUnknownVersion(t *testing.T) {
+	if _, err := Unmarshal([]byte(`{"type":"text_message","version":99,"payload":{}}`)); err == nil {
+		t.Fatal("expected an error for a newer envelope version")
+	}
+}
+
+func TestUnmarshalRestoresEventMetadata(t *testing.T) {
+	metadata := EventMetadata{TraceId: "trace", EntryId: "waba", ChangeField: "messages"}
+	event := SecurityEvent{}
+	event.SetEventContext(WithEventMetadata(context.Background(), metadata))
+	data, err := Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := EventMetadataFrom(ContextOf(decoded)); got != metadata {
+		t.Fatalf("metadata=%+v, want %+v", got, metadata)
+	}
+}
+
+func TestEveryEventCarriesAContext(t *testing.T) {
+	for _, registered := range eventRegistry {
+		event, _ := NewEvent(registered.eventType)
+		if !WithContext(event, context.Background()) {
+			t.Fatalf("%s cannot carry a context", registered.eventType)
+		}
+		if _, ok := ValueOf(event).(ContextualEvent); !ok {
+			t.Fatalf("%s does not expose its context", registered.eventType)
+		}
+	}
+}
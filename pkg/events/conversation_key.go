@@ -0,0 +1,63 @@
+package events
+
+// ConversationKeyOf returns the user an event belongs to: the sender of an
+// inbound message, the recipient of a status update, or the contact of an
+// identity change. The phone number wins over the BSUID so a message and the
+// statuses of its reply share a key. Events that are not about a single user
+// return an empty key.
+func ConversationKeyOf(event BaseEvent) string {
+	switch e := event.(type) {
+	case interface{ messageSender() string }:
+		return e.messageSender()
+	case *MessageSentEvent:
+		return firstNonEmpty(e.SentTo, e.RecipientUserId)
+	case *MessageDeliveredEvent:
+		return firstNonEmpty(e.SentTo, e.RecipientUserId)
+	case *MessageReadEvent:
+		return firstNonEmpty(e.SentTo, e.RecipientUserId)
+	case *MessageFailedEvent:
+		return e.SentTo
+	case *MessageUndeliveredEvent:
+		return e.SentTo
+	case *MessageStatusChangedEvent:
+		return e.Recipient
+	case *TemplateFallbackSentEvent:
+		return e.Recipient
+	case *QueuedMessageReleasedEvent:
+		return e.Recipient
+	case CustomerNumberChangedEvent:
+		return e.OldWaId
+	case *CustomerNumberChangedEvent:
+		return e.OldWaId
+	case *UserIdUpdateEvent:
+		return firstNonEmpty(e.WaId, e.OldUserId, e.NewUserId)
+	}
+	return ""
+}
+
+// messageSender is promoted to every inbound message event.
+func (bme BaseMessageEvent) messageSender() string {
+	return firstNonEmpty(bme.From, bme.WaId, bme.FromUserId, bme.UserId)
+}
+
+// MessageEventOf returns the BaseMessageEvent embedded by an inbound message
+// event, or false for every other event.
+func MessageEventOf(event BaseEvent) (*BaseMessageEvent, bool) {
+	if message, ok := event.(interface{ baseMessageEvent() *BaseMessageEvent }); ok {
+		return message.baseMessageEvent(), true
+	}
+	return nil, false
+}
+
+func (bme *BaseMessageEvent) baseMessageEvent() *BaseMessageEvent {
+	return bme
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
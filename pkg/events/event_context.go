@@ -0,0 +1,95 @@
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// EventMetadata identifies where an event came from. The webhook attaches it
+// to the context of every event it publishes.
+type EventMetadata struct {
+	TraceId     string `json:"trace_id"`               // TraceId is generated per webhook delivery and shared by all its events.
+	EntryId     string `json:"entry_id,omitempty"`     // EntryId is the Meta entry id (the WhatsApp business account id).
+	ChangeField string `json:"change_field,omitempty"` // ChangeField is the webhook field of the change, e.g. "messages".
+	ChangeIndex int    `json:"change_index"`           // ChangeIndex is the position of the change within its entry.
+}
+
+type eventMetadataKey struct{}
+
+// NewTraceId returns a random 16 byte hex trace id.
+func NewTraceId() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// WithEventMetadata returns a child of parent carrying the metadata.
+func WithEventMetadata(parent context.Context, metadata EventMetadata) context.Context {
+	return context.WithValue(parent, eventMetadataKey{}, metadata)
+}
+
+// EventMetadataFrom returns the metadata attached by WithEventMetadata.
+func EventMetadataFrom(ctx context.Context) (EventMetadata, bool) {
+	if ctx == nil {
+		return EventMetadata{}, false
+	}
+	metadata, ok := ctx.Value(eventMetadataKey{}).(EventMetadata)
+	return metadata, ok
+}
+
+// TraceIdFrom returns the trace id of the event context, or an empty string.
+func TraceIdFrom(ctx context.Context) string {
+	metadata, _ := EventMetadataFrom(ctx)
+	return metadata.TraceId
+}
+
+// ContextualEvent is implemented by every event through its embedded base
+// event.
+type ContextualEvent interface {
+	BaseEvent
+	EventContext() context.Context
+}
+
+// ContextOf returns the context of an event, or context.Background() for
+// events that have none (e.g. built by hand or decoded without metadata).
+func ContextOf(event BaseEvent) context.Context {
+	if contextual, ok := event.(ContextualEvent); ok {
+		return contextual.EventContext()
+	}
+	return context.Background()
+}
+
+// WithContext sets the context of an event held by pointer. It reports false
+// for events held by value, whose context has to be set before publishing.
+func WithContext(event BaseEvent, ctx context.Context) bool {
+	settable, ok := event.(interface {
+		SetEventContext(context.Context)
+	})
+	if ok {
+		settable.SetEventContext(ctx)
+	}
+	return ok
+}
+
+// eventContext is embedded by the base events. It is never serialized; Marshal
+// carries the metadata instead.
+type eventContext struct {
+	ctx context.Context
+}
+
+// EventContext returns the context the event was published with: a child of
+// the webhook request context, without its cancellation, carrying the
+// EventMetadata. Reply and React send their requests with it.
+func (e eventContext) EventContext() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
+}
+
+// SetEventContext replaces the event context, e.g. to add a deadline or a
+// logger before handing the event on.
+func (e *eventContext) SetEventContext(ctx context.Context) {
+	e.ctx = ctx
+}
@@ -0,0 +1,36 @@
+package events
+
+// EventQueueOverflowAction describes what the event bus did with an event
+// that found its subscriber queue full.
+type EventQueueOverflowAction string
+
+const (
+	EventQueueOverflowActionDroppedNewest EventQueueOverflowAction = "dropped_newest"
+	EventQueueOverflowActionDroppedOldest EventQueueOverflowAction = "dropped_oldest"
+	EventQueueOverflowActionBlockTimeout  EventQueueOverflowAction = "block_timeout"
+	EventQueueOverflowActionSpilled       EventQueueOverflowAction = "spilled"
+)
+
+// EventQueueOverflowEvent is published when a subscriber queue is full and
+// the configured overflow policy had to act. Spilled events are not lost;
+// every other action means the event never reached that subscriber.
+type EventQueueOverflowEvent struct {
+	BaseSystemEvent `json:",inline"`
+	Queue           string                   `json:"queue"`      // Queue is the event type or subscription the full queue belongs to.
+	EventType       EventType                `json:"event_type"` // EventType is the type of the event that overflowed.
+	Policy          string                   `json:"policy"`
+	Action          EventQueueOverflowAction `json:"action"`
+	QueueSize       int                      `json:"queue_size"`
+}
+
+// NewEventQueueOverflowEvent creates a new instance of EventQueueOverflowEvent.
+func NewEventQueueOverflowEvent(baseSystemEvent BaseSystemEvent, queue string, eventType EventType, policy string, action EventQueueOverflowAction, queueSize int) *EventQueueOverflowEvent {
+	return &EventQueueOverflowEvent{
+		BaseSystemEvent: baseSystemEvent,
+		Queue:           queue,
+		EventType:       eventType,
+		Policy:          policy,
+		Action:          action,
+		QueueSize:       queueSize,
+	}
+}
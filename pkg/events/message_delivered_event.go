@@ -3,9 +3,16 @@ package events
 // MessageDeliveredEvent represents an event related to an undelivered message.
 type MessageDeliveredEvent struct {
 	BaseSystemEvent `json:",inline"`
+	StatusDetails   `json:",inline"`
 	MessageId       string `json:"messageId"`
 	SentTo          string `json:"sentTo"`
 
+	// ErrorCode and ErrorMessage repeat the first of Errors, as on
+	// MessageFailedEvent. Zero when the status carries no error. There is
+	// no FailReason: the error did not fail the message (see StatusDetails).
+	ErrorCode    int    `json:"errorCode,omitempty"`
+	ErrorMessage string `json:"errorMessage,omitempty"`
+
 	// Pricing carries Meta's `messages.statuses[].pricing` block when
 	// present. For CBP-priced sends Meta typically populates this on
 	// `delivered` rather than `sent`. Nil otherwise.
@@ -25,3 +32,8 @@ func NewMessageDeliveredEvent(baseSystemEvent BaseSystemEvent, messageId, sendTo
 		SentTo:          sendTo,
 	}
 }
+
+// Category places delivery receipts in the status category.
+func (e MessageDeliveredEvent) Category() EventCategory {
+	return EventCategoryStatus
+}
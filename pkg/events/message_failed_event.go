@@ -2,6 +2,7 @@ package events
 
 type MessageFailedEvent struct {
 	BaseSystemEvent `json:",inline"`
+	StatusDetails   `json:",inline"`
 	MessageId       string `json:"messageId"`
 	SentTo          string `json:"sentTo"`
 	FailReason      string `json:"failReason"`
@@ -20,3 +21,8 @@ func NewMessageFailedEvent(baseSystemEvent BaseSystemEvent, messageId, sendTo, f
 	}
 
 }
+
+// Category places failed sends in the status category.
+func (e MessageFailedEvent) Category() EventCategory {
+	return EventCategoryStatus
+}
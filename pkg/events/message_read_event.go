@@ -3,9 +3,16 @@ package events
 // MessageReadEvent represents an event indicating that a message has been read.
 type MessageReadEvent struct {
 	BaseSystemEvent `json:",inline"`
+	StatusDetails   `json:",inline"`
 	MessageId       string `json:"messageId"`
 	SentTo          string `json:"sentTo"`
 
+	// ErrorCode and ErrorMessage repeat the first of Errors, as on
+	// MessageFailedEvent. Zero when the status carries no error. There is
+	// no FailReason: the error did not fail the message (see StatusDetails).
+	ErrorCode    int    `json:"errorCode,omitempty"`
+	ErrorMessage string `json:"errorMessage,omitempty"`
+
 	// Pricing carries Meta's `messages.statuses[].pricing` block when
 	// present. Most pricing payloads arrive earlier (on `sent` or
 	// `delivered`); included here for parity since Meta sometimes
@@ -26,3 +33,8 @@ func NewMessageReadEvent(baseSystemEvent BaseSystemEvent, messageId, sendTo stri
 		SentTo:          sendTo,
 	}
 }
+
+// Category places read receipts in the status category.
+func (e MessageReadEvent) Category() EventCategory {
+	return EventCategoryStatus
+}
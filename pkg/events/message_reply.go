@@ -0,0 +1,159 @@
+package events
+
+import (
+	"fmt"
+
+	"github.com/wapikit/wapi.go/internal/message_dispatch"
+	"github.com/wapikit/wapi.go/pkg/components"
+)
+
+// MediaReplyParams describes the media of ReplyMedia. Exactly one of Id (an
+// uploaded media id) and Link is required. Caption is not supported by audio
+// and sticker replies; FileName is required for documents.
+type MediaReplyParams struct {
+	Id       string
+	Link     string
+	Caption  string
+	FileName string
+}
+
+// Target returns who replies to this message go to: the sender's phone number,
+// BSUID or parent BSUID, chosen by components.ChooseTarget with the event's
+// target preference when both a phone number and a BSUID are known.
+func (baseMessageEvent *BaseMessageEvent) Target() components.MessageTarget {
+	target, _ := components.ChooseTarget(
+		baseMessageEvent.targetPreference,
+		firstNonEmpty(baseMessageEvent.From, baseMessageEvent.WaId),
+		firstNonEmpty(baseMessageEvent.FromUserId, baseMessageEvent.UserId),
+		firstNonEmpty(baseMessageEvent.FromParentUserId, baseMessageEvent.ParentUserId),
+	)
+	return target
+}
+
+// SetTargetPreference sets whether Target picks the phone number or the BSUID
+// when the sender has both. Like the requester it is never serialized.
+func (baseMessageEvent *BaseMessageEvent) SetTargetPreference(preference components.TargetPreference) {
+	baseMessageEvent.targetPreference = preference
+}
+
+// SetSplitLongMessages makes Reply and the other send helpers split texts and
+// captions over the API limits into a sequence of messages with
+// components.SplitMessage. Like the requester it is never serialized.
+func (baseMessageEvent *BaseMessageEvent) SetSplitLongMessages(split bool) {
+	baseMessageEvent.splitLongMessages = split
+}
+
+func (baseMessageEvent *BaseMessageEvent) send(message components.BaseMessage, replyTo string, tags map[string]string) (*components.MessageSendResponse, error) {
+	target := baseMessageEvent.Target()
+	if target.Value == "" {
+		return nil, fmt.Errorf("message %s has no sender to reply to", baseMessageEvent.MessageId)
+	}
+	sendOne := func(message components.BaseMessage, configs components.ApiCompatibleJsonConverterConfigs) (*components.MessageSendResponse, error) {
+		body, err := message.ToJson(configs)
+		if err != nil {
+			return nil, fmt.Errorf("error converting message to json: %v", err)
+		}
+		if pacer := baseMessageEvent.sendPacer; pacer != nil {
+			release, err := pacer.Acquire(baseMessageEvent.EventContext(), baseMessageEvent.PhoneNumber.Id, target.Value)
+			if err != nil {
+				return nil, err
+			}
+			defer release()
+		}
+		response, err := message_dispatch.SendBody(
+			baseMessageEvent.EventContext(),
+			baseMessageEvent.requester,
+			baseMessageEvent.PhoneNumber.Id,
+			body,
+			"messages",
+		)
+		if err == nil {
+			baseMessageEvent.recordSent(target.Value, body, response, tags)
+		}
+		return response, err
+	}
+	if baseMessageEvent.splitLongMessages {
+		if parts := components.SplitMessage(message); len(parts) > 1 {
+			return message_dispatch.SendSequence(parts, target.Configs(replyTo), sendOne)
+		}
+	}
+	return sendOne(message, target.Configs(replyTo))
+}
+
+// Reply sends the message as a reply to this message, quoting it. The error is
+// the typed Graph API error for non-2xx responses, with the parsed response
+// returned alongside it.
+func (baseMessageEvent *BaseMessageEvent) Reply(message components.BaseMessage) (*components.MessageSendResponse, error) {
+	return baseMessageEvent.send(message, baseMessageEvent.MessageId, nil)
+}
+
+// ReplyWithTags replies like Reply and records the tags with the sent message,
+// so RepliedTo of a later reply to it returns them.
+func (baseMessageEvent *BaseMessageEvent) ReplyWithTags(message components.BaseMessage, tags map[string]string) (*components.MessageSendResponse, error) {
+	return baseMessageEvent.send(message, baseMessageEvent.MessageId, tags)
+}
+
+// React reacts to this message with the emoji. An empty emoji removes a
+// previous reaction.
+func (baseMessageEvent *BaseMessageEvent) React(emoji string) (*components.MessageSendResponse, error) {
+	reactionMessage, err := components.NewReactionMessage(components.ReactionMessageParams{
+		Emoji:     emoji,
+		MessageId: baseMessageEvent.MessageId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return baseMessageEvent.send(reactionMessage, "", nil)
+}
+
+// ReplyText replies with a plain text message.
+func (baseMessageEvent *BaseMessageEvent) ReplyText(text string) (*components.MessageSendResponse, error) {
+	textMessage, err := components.NewTextMessage(components.TextMessageConfigs{Text: text})
+	if err != nil {
+		return nil, err
+	}
+	return baseMessageEvent.Reply(textMessage)
+}
+
+// ReplyMedia replies with an image, video, audio, document or sticker.
+func (baseMessageEvent *BaseMessageEvent) ReplyMedia(mediaType components.MessageType, params MediaReplyParams) (*components.MessageSendResponse, error) {
+	message, err := newMediaMessage(mediaType, params)
+	if err != nil {
+		return nil, err
+	}
+	return baseMessageEvent.Reply(message)
+}
+
+func newMediaMessage(mediaType components.MessageType, params MediaReplyParams) (components.BaseMessage, error) {
+	if params.Caption != "" && (mediaType == components.MessageTypeAudio || mediaType == components.MessageTypeSticker) {
+		return nil, fmt.Errorf("%s messages do not support a caption", mediaType)
+	}
+	switch mediaType {
+	case components.MessageTypeImage:
+		return components.NewImageMessage(components.ImageMessageConfigs{Id: params.Id, Link: params.Link, Caption: params.Caption})
+	case components.MessageTypeVideo:
+		return components.NewVideoMessage(components.VideoMessageConfigs{Id: params.Id, Link: params.Link, Caption: params.Caption})
+	case components.MessageTypeAudio:
+		return components.NewAudioMessage(components.AudioMessageConfigs{Id: params.Id, Link: params.Link})
+	case components.MessageTypeSticker:
+		return components.NewStickerMessage(&components.StickerMessageConfigs{Id: params.Id, Link: params.Link})
+	case components.MessageTypeDocument:
+		configs := components.DocumentMessageConfigs{Id: params.Id, Link: params.Link, FileName: params.FileName}
+		if params.Caption != "" {
+			configs.Caption = &params.Caption
+		}
+		return components.NewDocumentMessage(configs)
+	}
+	return nil, fmt.Errorf("%s is not a media message type", mediaType)
+}
+
+// MarkRead marks this message as read.
+func (baseMessageEvent *BaseMessageEvent) MarkRead() error {
+	return message_dispatch.MarkRead(baseMessageEvent.EventContext(), baseMessageEvent.requester, baseMessageEvent.PhoneNumber.Id, baseMessageEvent.MessageId, false)
+}
+
+// MarkReadWithTyping marks this message as read and shows the typing
+// indicator until the reply is sent (or for at most 25 seconds).
+func (baseMessageEvent *BaseMessageEvent) MarkReadWithTyping() error {
+	return message_dispatch.MarkRead(baseMessageEvent.EventContext(), baseMessageEvent.requester, baseMessageEvent.PhoneNumber.Id, baseMessageEvent.MessageId, true)
+}
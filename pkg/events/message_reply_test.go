@@ -0,0 +1,66 @@
+package events
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/wapikit/wapi.go/pkg/components"
+)
+
+func TestNewMediaMessageBuildsEachMediaType(t *testing.T) {
+	for _, mediaType := range []components.MessageType{
+		components.MessageTypeImage,
+		components.MessageTypeVideo,
+		components.MessageTypeAudio,
+		components.MessageTypeSticker,
+		components.MessageTypeDocument,
+	} {
+		message, err := newMediaMessage(mediaType, MediaReplyParams{Id: "media-1", FileName: "file.pdf"})
+		if err != nil {
+			t.Fatalf("%s: %v", mediaType, err)
+		}
+		body, err := message.ToJson(components.NewPhoneTarget("911").Configs("wamid.1"))
+		if err != nil {
+			t.Fatalf("%s: %v", mediaType, err)
+		}
+		var payload map[string]any
+		json.Unmarshal(body, &payload)
+		if payload["type"] != string(mediaType) || payload["to"] != "911" {
+			t.Fatalf("%s: unexpected payload %s", mediaType, body)
+		}
+	}
+}
+
+func TestNewMediaMessageRejectsInvalidParams(t *testing.T) {
+	if _, err := newMediaMessage(components.MessageTypeAudio, MediaReplyParams{Id: "media-1", Caption: "hi"}); err == nil {
+		t.Fatal("expected an error for an audio caption")
+	}
+	if _, err := newMediaMessage(components.MessageTypeText, MediaReplyParams{Id: "media-1"}); err == nil {
+		t.Fatal("expected an error for a non-media type")
+	}
+}
+
+func TestTargetPrefersPhoneUnlessConfigured(t *testing.T) {
+	cases := []struct {
+		event BaseMessageEvent
+		want  components.MessageTarget
+	}{
+		{BaseMessageEvent{From: "911", FromUserId: "IN.1"}, components.NewPhoneTarget("911")},
+		{BaseMessageEvent{FromUserId: "IN.1"}, components.NewBSUIDTarget("IN.1")},
+		{BaseMessageEvent{From: "911", FromUserId: "IN.1", targetPreference: components.TargetPreferenceBSUID}, components.NewBSUIDTarget("IN.1")},
+		{BaseMessageEvent{From: "911", targetPreference: components.TargetPreferenceBSUID}, components.NewPhoneTarget("911")},
+		{BaseMessageEvent{FromParentUserId: "IN.P"}, components.NewParentBSUIDTarget("IN.P")},
+	}
+	for i, c := range cases {
+		if got := c.event.Target(); got != c.want {
+			t.Fatalf("case %d: got %+v, want %+v", i, got, c.want)
+		}
+	}
+}
+
+func TestReplyWithoutTargetFails(t *testing.T) {
+	event := BaseMessageEvent{MessageId: "wamid.1"}
+	if _, err := event.ReplyText("hi"); err == nil {
+		t.Fatal("expected an error without a target")
+	}
+}
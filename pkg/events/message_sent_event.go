@@ -3,9 +3,16 @@ package events
 // MessageSentEvent represents an event indicating that a message has been sent.
 type MessageSentEvent struct {
 	BaseSystemEvent `json:",inline"`
+	StatusDetails   `json:",inline"`
 	MessageId       string `json:"messageId"`
 	SentTo          string `json:"sentTo"`
 
+	// ErrorCode and ErrorMessage repeat the first of Errors, as on
+	// MessageFailedEvent. Zero when the status carries no error. There is
+	// no FailReason: the error did not fail the message (see StatusDetails).
+	ErrorCode    int    `json:"errorCode,omitempty"`
+	ErrorMessage string `json:"errorMessage,omitempty"`
+
 	// Pricing carries Meta's `messages.statuses[].pricing` block when
 	// present. Nil for status events Meta didn't tag with a pricing
 	// payload. Consumers that bill on the upstream charge should check
@@ -26,3 +33,9 @@ func NewMessageSentEvent(baseSystemEvent BaseSystemEvent, messageId, sendTo stri
 		SentTo:          sendTo,
 	}
 }
+
+// Category places sent receipts in the status category rather than the
+// system category of the embedded BaseSystemEvent.
+func (e MessageSentEvent) Category() EventCategory {
+	return EventCategoryStatus
+}
@@ -0,0 +1,45 @@
+package events
+
+// DeliveryStatus is the delivery state of an outbound message.
+type DeliveryStatus string
+
+const (
+	// DeliveryStatusAccepted is the state of a message the API accepted and
+	// no status update has arrived for yet.
+	DeliveryStatusAccepted  DeliveryStatus = "accepted"
+	DeliveryStatusSent      DeliveryStatus = "sent"
+	DeliveryStatusDelivered DeliveryStatus = "delivered"
+	DeliveryStatusRead      DeliveryStatus = "read"
+	// DeliveryStatusFailed covers failed and undelivered statuses. It is
+	// final: later statuses of the message are ignored.
+	DeliveryStatusFailed DeliveryStatus = "failed"
+)
+
+// MessageStatusChangedEvent is published by the delivery tracker when an
+// outbound message moves forward, e.g. from sent to delivered. Duplicate and
+// out-of-order status updates publish nothing.
+type MessageStatusChangedEvent struct {
+	BaseSystemEvent `json:",inline"`
+	MessageId       string         `json:"messageId"`
+	Recipient       string         `json:"recipient,omitempty"`
+	PreviousStatus  DeliveryStatus `json:"previousStatus,omitempty"` // PreviousStatus is empty for the first status seen.
+	Status          DeliveryStatus `json:"status"`
+	// Errors are the errors of the status that made the message fail.
+	Errors []StatusError `json:"errors,omitempty"`
+}
+
+// NewMessageStatusChangedEvent creates a new instance of MessageStatusChangedEvent.
+func NewMessageStatusChangedEvent(baseSystemEvent BaseSystemEvent, messageId, recipient string, previousStatus, status DeliveryStatus) *MessageStatusChangedEvent {
+	return &MessageStatusChangedEvent{
+		BaseSystemEvent: baseSystemEvent,
+		MessageId:       messageId,
+		Recipient:       recipient,
+		PreviousStatus:  previousStatus,
+		Status:          status,
+	}
+}
+
+// Category places status changes in the status category.
+func (e MessageStatusChangedEvent) Category() EventCategory {
+	return EventCategoryStatus
+}
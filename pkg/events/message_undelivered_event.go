@@ -3,6 +3,7 @@ package events
 // MessageUndeliveredEvent represents an event related to an undelivered message.
 type MessageUndeliveredEvent struct {
 	BaseSystemEvent `json:",inline"`
+	StatusDetails   `json:",inline"`
 	MessageId       string `json:"messageId"`
 	SentTo          string `json:"sentTo"`
 	Reason          string `json:"reason"`
@@ -21,3 +22,8 @@ func NewMessageUndeliveredEvent(baseSystemEvent BaseSystemEvent, messageId, send
 		ErrorMessage:    errorMessage,
 	}
 }
+
+// Category places undelivered notices in the status category.
+func (e MessageUndeliveredEvent) Category() EventCategory {
+	return EventCategoryStatus
+}
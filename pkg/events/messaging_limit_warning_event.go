@@ -0,0 +1,25 @@
+package events
+
+// MessagingLimitWarningEvent is published by a messaging limit tracker when
+// the business-initiated sends of a phone number cross one of its warning
+// thresholds: Used unique recipients in the rolling window out of Limit.
+type MessagingLimitWarningEvent struct {
+	BaseSystemEvent `json:",inline"`
+	PhoneNumberId   string  `json:"phoneNumberId"`
+	Tier            string  `json:"tier"` // Tier is the messaging limit tier, e.g. TIER_1K.
+	Limit           int     `json:"limit"`
+	Used            int     `json:"used"`
+	Threshold       float64 `json:"threshold"` // Threshold is the crossed fraction of Limit, e.g. 0.8.
+}
+
+// NewMessagingLimitWarningEvent creates a new instance of MessagingLimitWarningEvent.
+func NewMessagingLimitWarningEvent(baseSystemEvent BaseSystemEvent, phoneNumberId, tier string, limit, used int, threshold float64) *MessagingLimitWarningEvent {
+	return &MessagingLimitWarningEvent{
+		BaseSystemEvent: baseSystemEvent,
+		PhoneNumberId:   phoneNumberId,
+		Tier:            tier,
+		Limit:           limit,
+		Used:            used,
+		Threshold:       threshold,
+	}
+}
@@ -1,9 +1,10 @@
 package events
 
-// ProductInquiryEvent represents an event related to a product inquiry.
+// ProductInquiryEvent is published instead of a TextMessageEvent when the
+// customer replies with text to a single- or multi-product message.
 type ProductInquiryEvent struct {
 	BaseMessageEvent `json:",inline"`
-	ProductId        string `json:"productId"`
+	ProductId        string `json:"productId"` // ProductId is the product retailer id (SKU) of the referred product.
 	CatalogId        string `json:"catalogId"`
 	Text             string `json:"text"`
 }
@@ -0,0 +1,50 @@
+package events
+
+// TemplateFallbackSentEvent is published by a MessageManager with a
+// reengagement policy when a free-form send was refused because the customer
+// service window is closed, and the fallback template was sent instead. The
+// original message waits until the user replies, see QueuedMessageReleasedEvent.
+type TemplateFallbackSentEvent struct {
+	BaseSystemEvent   `json:",inline"`
+	PhoneNumberId     string `json:"phoneNumberId"`
+	Recipient         string `json:"recipient"`
+	FallbackMessageId string `json:"fallbackMessageId,omitempty"` // FallbackMessageId is empty when the fallback send failed too.
+	// ErrorCode is the Graph API error code that triggered the fallback, or 0
+	// when the manager's own service window check stopped the send.
+	ErrorCode int `json:"errorCode,omitempty"`
+	// Error is set when the fallback template could not be sent. The
+	// original message is queued either way.
+	Error string `json:"error,omitempty"`
+}
+
+// NewTemplateFallbackSentEvent creates a new instance of TemplateFallbackSentEvent.
+func NewTemplateFallbackSentEvent(baseSystemEvent BaseSystemEvent, phoneNumberId, recipient, fallbackMessageId string, errorCode int) *TemplateFallbackSentEvent {
+	return &TemplateFallbackSentEvent{
+		BaseSystemEvent:   baseSystemEvent,
+		PhoneNumberId:     phoneNumberId,
+		Recipient:         recipient,
+		FallbackMessageId: fallbackMessageId,
+		ErrorCode:         errorCode,
+	}
+}
+
+// QueuedMessageReleasedEvent is published when a message queued behind a
+// fallback template is sent after the user replied and reopened the window.
+type QueuedMessageReleasedEvent struct {
+	BaseSystemEvent `json:",inline"`
+	PhoneNumberId   string `json:"phoneNumberId"`
+	Recipient       string `json:"recipient"`
+	MessageId       string `json:"messageId,omitempty"`
+	// Error is set when the send failed for good; the message is dropped.
+	Error string `json:"error,omitempty"`
+}
+
+// NewQueuedMessageReleasedEvent creates a new instance of QueuedMessageReleasedEvent.
+func NewQueuedMessageReleasedEvent(baseSystemEvent BaseSystemEvent, phoneNumberId, recipient, messageId string) *QueuedMessageReleasedEvent {
+	return &QueuedMessageReleasedEvent{
+		BaseSystemEvent: baseSystemEvent,
+		PhoneNumberId:   phoneNumberId,
+		Recipient:       recipient,
+		MessageId:       messageId,
+	}
+}
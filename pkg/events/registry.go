@@ -0,0 +1,127 @@
+package events
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// registeredEvent ties a concrete event struct to its canonical event type.
+// byValue marks events the webhook publishes as struct values rather than
+// pointers, so decoding hands handlers the same shape they already assert on.
+type registeredEvent struct {
+	eventType EventType
+	goType    reflect.Type
+	byValue   bool
+}
+
+func registerEvent[T any](eventType EventType, byValue bool) registeredEvent {
+	return registeredEvent{
+		eventType: eventType,
+		goType:    reflect.TypeOf((*T)(nil)).Elem(),
+		byValue:   byValue,
+	}
+}
+
+// eventRegistry lists every concrete event. The canonical type is the type an
+// event is about, which is not always the type it is published under (several
+// business account events share AccountAlertsEventType on the bus).
+var eventRegistry = []registeredEvent{
+	registerEvent[TextMessageEvent](TextMessageEventType, false),
+	registerEvent[AudioMessageEvent](AudioMessageEventType, false),
+	registerEvent[VideoMessageEvent](VideoMessageEventType, false),
+	registerEvent[ImageMessageEvent](ImageMessageEventType, false),
+	registerEvent[ContactsMessageEvent](ContactMessageEventType, false),
+	registerEvent[DocumentMessageEvent](DocumentMessageEventType, false),
+	registerEvent[LocationMessageEvent](LocationMessageEventType, false),
+	registerEvent[ReactionMessageEvent](ReactionMessageEventType, false),
+	registerEvent[ListInteractionEvent](ListInteractionMessageEventType, false),
+	registerEvent[QuickReplyButtonInteractionEvent](QuickReplyMessageEventType, false),
+	registerEvent[ReplyButtonInteractionEvent](ReplyButtonInteractionEventType, false),
+	registerEvent[StickerMessageEvent](StickerMessageEventType, false),
+	registerEvent[AdInteractionEvent](AdInteractionEventType, false),
+	registerEvent[OrderEvent](OrderReceivedEventType, false),
+	registerEvent[ProductInquiryEvent](ProductInquiryEventType, false),
+	registerEvent[CustomerIdentityChangedEvent](CustomerIdentityChangedEventType, false),
+	registerEvent[CustomerNumberChangedEvent](CustomerNumberChangedEventType, true),
+	registerEvent[MessageDeliveredEvent](MessageDeliveredEventType, false),
+	registerEvent[MessageFailedEvent](MessageFailedEventType, false),
+	registerEvent[MessageReadEvent](MessageReadEventType, false),
+	registerEvent[MessageSentEvent](MessageSentEventType, false),
+	registerEvent[MessageUndeliveredEvent](MessageUndeliveredEventType, false),
+	registerEvent[ReadyEvent](ReadyEventType, false),
+	registerEvent[UserIdUpdateEvent](UserIdUpdateEventType, false),
+	registerEvent[EventQueueOverflowEvent](EventQueueOverflowEventType, false),
+	registerEvent[MessageStatusChangedEvent](MessageStatusChangedEventType, false),
+	registerEvent[TemplateFallbackSentEvent](TemplateFallbackSentEventType, false),
+	registerEvent[QueuedMessageReleasedEvent](QueuedMessageReleasedEventType, false),
+	registerEvent[MessagingLimitWarningEvent](MessagingLimitWarningEventType, false),
+	registerEvent[MessageTemplateStatusUpdateEvent](MessageTemplateStatusUpdateEventType, false),
+	registerEvent[MessageTemplateQualityUpdateEvent](MessageTemplateQualityUpdateEventType, false),
+	registerEvent[PhoneNumberNameUpdateEvent](PhoneNumberNameUpdateEventType, false),
+	registerEvent[PhoneNumberQualityUpdateEvent](PhoneNumberQualityUpdateEventType, false),
+	registerEvent[SecurityEvent](SecurityEventType, true),
+	registerEvent[AccountUpdateEvent](AccountUpdateEventType, false),
+	registerEvent[AccountReviewUpdateEvent](AccountReviewUpdateEventType, false),
+	registerEvent[AccountAlertEvent](AccountAlertsEventType, false),
+	registerEvent[BusinessCapabilityUpdateEvent](BusinessCapabilityUpdateEventType, false),
+	registerEvent[UserPreferencesEvent](UserPreferencesEventType, false),
+	registerEvent[MessageTemplateComponentsUpdateEvent](MessageTemplateComponentsUpdateEventType, false),
+	registerEvent[PaymentConfigurationUpdateEvent](PaymentConfigurationUpdateEventType, false),
+	registerEvent[BusinessUsernameUpdateEvent](BusinessUsernameUpdateEventType, false),
+	registerEvent[SmbAppStateSyncEvent](SmbAppStateSyncEventType, false),
+	registerEvent[SmbMessageEchoesEvent](SmbMessageEchoesEventType, false),
+	registerEvent[TemplateCategoryUpdateEvent](TemplateCategoryUpdateEventType, false),
+	registerEvent[HistoryEvent](HistoryEventType, false),
+}
+
+var (
+	eventsByType   = map[EventType]registeredEvent{}
+	eventsByGoType = map[reflect.Type]registeredEvent{}
+)
+
+func init() {
+	for _, registered := range eventRegistry {
+		eventsByType[registered.eventType] = registered
+		eventsByGoType[registered.goType] = registered
+	}
+}
+
+// EventTypeOf returns the canonical event type of an event value, whether it
+// is held as a pointer or a struct value. It reports false for event types
+// that are not part of this package.
+func EventTypeOf(event BaseEvent) (EventType, bool) {
+	if event == nil {
+		return "", false
+	}
+	goType := reflect.TypeOf(event)
+	if goType.Kind() == reflect.Pointer {
+		goType = goType.Elem()
+	}
+	registered, ok := eventsByGoType[goType]
+	return registered.eventType, ok
+}
+
+// NewEvent returns a pointer to an empty event of the given canonical type,
+// ready to be decoded into. Use ValueOf afterwards to get the shape the
+// webhook publishes it in.
+func NewEvent(eventType EventType) (BaseEvent, error) {
+	registered, ok := eventsByType[eventType]
+	if !ok {
+		return nil, fmt.Errorf("unknown event type: %s", eventType)
+	}
+	return reflect.New(registered.goType).Interface().(BaseEvent), nil
+}
+
+// ValueOf converts an event returned by NewEvent into the shape the webhook
+// publishes it in, dereferencing the events that are published by value.
+func ValueOf(event BaseEvent) BaseEvent {
+	eventType, ok := EventTypeOf(event)
+	if !ok || !eventsByType[eventType].byValue {
+		return event
+	}
+	value := reflect.ValueOf(event)
+	if value.Kind() != reflect.Pointer {
+		return event
+	}
+	return value.Elem().Interface().(BaseEvent)
+}
@@ -0,0 +1,97 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/wapikit/wapi.go/pkg/components"
+)
+
+var (
+	// ErrNotAReply is returned by RepliedTo for a message that does not reply
+	// to another message.
+	ErrNotAReply = errors.New("message is not a reply")
+	// ErrNoSentMessageStore is returned by RepliedTo when the event has no
+	// store of sent messages to look the original up in.
+	ErrNoSentMessageStore = errors.New("no sent message store configured")
+	// ErrSentMessageNotFound is returned by SentMessageStore.LookupSentMessage
+	// for a message it has not recorded, e.g. one sent before it was set up.
+	ErrSentMessageNotFound = errors.New("sent message not found")
+)
+
+// SentMessage is an outbound message as recorded when it was sent.
+type SentMessage struct {
+	MessageId     string          `json:"message_id"`
+	PhoneNumberId string          `json:"phone_number_id,omitempty"`
+	Recipient     string          `json:"recipient"`
+	Type          string          `json:"type"`    // Type is the API message type, e.g. text, interactive or template.
+	Payload       json.RawMessage `json:"payload"` // Payload is the API body of the message.
+	SentAt        time.Time       `json:"sent_at"`
+	// Tags are the metadata attached at send time, e.g. the menu a button
+	// message belongs to.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// SentMessageStore records outbound messages so replies can be resolved to
+// them. manager.Transcript implements it.
+type SentMessageStore interface {
+	RecordSentMessage(message SentMessage) error
+	// LookupSentMessage returns the message or an error wrapping
+	// ErrSentMessageNotFound.
+	LookupSentMessage(messageId string) (SentMessage, error)
+}
+
+// SetSentMessages sets the store RepliedTo looks messages up in and replies
+// sent from this event are recorded in.
+func (baseMessageEvent *BaseMessageEvent) SetSentMessages(store SentMessageStore) {
+	baseMessageEvent.sentMessages = store
+}
+
+// RepliedTo returns the outbound message this message replies to, e.g. the
+// button message whose button was tapped. It returns ErrNotAReply for
+// messages without a reply context, ErrNoSentMessageStore when no store is
+// set, and an error wrapping ErrSentMessageNotFound for messages the store
+// has not recorded.
+func (baseMessageEvent *BaseMessageEvent) RepliedTo() (*SentMessage, error) {
+	repliedTo := baseMessageEvent.Context.RepliedToMessageId
+	if repliedTo == "" {
+		return nil, ErrNotAReply
+	}
+	if baseMessageEvent.sentMessages == nil {
+		return nil, ErrNoSentMessageStore
+	}
+	sent, err := baseMessageEvent.sentMessages.LookupSentMessage(repliedTo)
+	if err != nil {
+		return nil, err
+	}
+	return &sent, nil
+}
+
+// recordSent records the messages of a successful send in the event's store,
+// if any. Store errors do not fail the send.
+func (baseMessageEvent *BaseMessageEvent) recordSent(recipient string, body []byte, response *components.MessageSendResponse, tags map[string]string) {
+	if baseMessageEvent.sentMessages == nil || response == nil {
+		return
+	}
+	var payload struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal(body, &payload)
+	for _, message := range response.Messages {
+		err := baseMessageEvent.sentMessages.RecordSentMessage(SentMessage{
+			MessageId:     message.ID,
+			PhoneNumberId: baseMessageEvent.PhoneNumber.Id,
+			Recipient:     recipient,
+			Type:          payload.Type,
+			Payload:       body,
+			SentAt:        time.Now(),
+			Tags:          maps.Clone(tags),
+		})
+		if err != nil {
+			fmt.Println("Error recording sent message:", err)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package events
+
+import "context"
+
+// SendPacer paces sends per pair of phone number id and recipient so a user
+// is not messaged faster than the API allows. manager.PairPacer implements it.
+type SendPacer interface {
+	// Acquire blocks until a send to the recipient may go out and returns the
+	// release to call once it is done.
+	Acquire(ctx context.Context, phoneNumberId, recipient string) (func(), error)
+}
+
+// SetSendPacer sets the pacer the replies sent from this event wait for.
+func (baseMessageEvent *BaseMessageEvent) SetSendPacer(pacer SendPacer) {
+	baseMessageEvent.sendPacer = pacer
+}
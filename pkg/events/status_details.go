@@ -0,0 +1,49 @@
+package events
+
+import "time"
+
+// StatusError is one entry of Meta's `messages.statuses[].errors` list.
+type StatusError struct {
+	Code    int    `json:"code"`
+	Title   string `json:"title"`
+	Message string `json:"message,omitempty"`
+	Details string `json:"details,omitempty"` // Details is error_data.details, Meta's explanation of the error.
+	Href    string `json:"href,omitempty"`
+}
+
+// StatusConversation is the conversation a status update is billed under.
+// Meta sends it for conversation-based pricing only.
+type StatusConversation struct {
+	Id string `json:"id"`
+	// OriginType is what opened the conversation: "marketing", "utility",
+	// "authentication", "service", "referral_conversion", ...
+	OriginType          string `json:"originType,omitempty"`
+	ExpirationTimestamp string `json:"expirationTimestamp,omitempty"`
+}
+
+// ExpirationTime returns ExpirationTimestamp, when the conversation closes.
+func (c StatusConversation) ExpirationTime() (time.Time, error) {
+	return ParseTimestamp(c.ExpirationTimestamp)
+}
+
+// StatusDetails is embedded by every status event and carries the parts of a
+// status update that are not specific to one status.
+//
+// Only failed and undelivered events name the title of the first error as
+// the reason the message did not arrive, in FailReason and Reason. On sent,
+// delivered and read events an error is non-fatal; its title is in Errors.
+type StatusDetails struct {
+	// Errors is the full errors list of the status, in Meta's order. Failed
+	// and undelivered statuses always have one; other statuses may carry
+	// non-fatal errors.
+	Errors       []StatusError       `json:"errors,omitempty"`
+	Conversation *StatusConversation `json:"conversation,omitempty"`
+}
+
+// FirstError returns the first entry of Errors, or nil.
+func (d StatusDetails) FirstError() *StatusError {
+	if len(d.Errors) == 0 {
+		return nil
+	}
+	return &d.Errors[0]
+}
@@ -1,6 +1,7 @@
 package events
 
-// TextMessageEvent represents an event for a text message.
+// TextMessageEvent represents an event for a text message. Text replies to a
+// product message are published as a ProductInquiryEvent instead.
 type TextMessageEvent struct {
 	BaseMessageEvent `json:",inline"`
 	Text             string `json:"text"`
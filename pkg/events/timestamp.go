@@ -0,0 +1,87 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ErrInvalidTimestamp is wrapped by the errors of the time accessors when a
+// timestamp is empty or in no format Meta uses.
+var ErrInvalidTimestamp = errors.New("invalid timestamp")
+
+// ParseTimestamp parses a timestamp as Meta sends them: Unix seconds for
+// messages, statuses and entries, and RFC 3339 for a few system payloads such
+// as the identity change creation time.
+func ParseTimestamp(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, fmt.Errorf("%w: empty", ErrInvalidTimestamp)
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed, nil
+	}
+	return time.Time{}, fmt.Errorf("%w: %q", ErrInvalidTimestamp, value)
+}
+
+// unixTime converts the Unix seconds of the int64 timestamp fields.
+func unixTime(seconds int64) (time.Time, error) {
+	if seconds <= 0 {
+		return time.Time{}, fmt.Errorf("%w: %d", ErrInvalidTimestamp, seconds)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// Time returns Timestamp, the time the user sent the message.
+func (bme BaseMessageEvent) Time() (time.Time, error) {
+	return ParseTimestamp(bme.Timestamp)
+}
+
+// Time returns Timestamp, the time of the status or system change.
+func (bme BaseSystemEvent) Time() (time.Time, error) {
+	return ParseTimestamp(bme.Timestamp)
+}
+
+// Time returns Timestamp, the time of the webhook entry. Template status,
+// quality and category updates carry no time of their own and use this one.
+func (bme BaseBusinessAccountEvent) Time() (time.Time, error) {
+	return ParseTimestamp(bme.Timestamp)
+}
+
+// Time returns Timestamp, the time the synchronized message was sent.
+func (m HistoryMessage) Time() (time.Time, error) {
+	return ParseTimestamp(m.Timestamp)
+}
+
+// Time returns Timestamp, the time the echoed message was sent.
+func (m MessageEcho) Time() (time.Time, error) {
+	return ParseTimestamp(m.Timestamp)
+}
+
+// Time returns Timestamp, the time of the contact change.
+func (item StateSyncItem) Time() (time.Time, error) {
+	return ParseTimestamp(item.Timestamp)
+}
+
+// Time returns Timestamp, the time the user changed the preference.
+func (p UserPreference) Time() (time.Time, error) {
+	return unixTime(p.Timestamp)
+}
+
+// CreationTime returns CreationTimestamp, the time the new identity was created.
+func (e CustomerIdentityChangedEvent) CreationTime() (time.Time, error) {
+	return ParseTimestamp(e.CreationTimestamp)
+}
+
+// CreatedTime returns CreatedTimestamp.
+func (e PaymentConfigurationUpdateEvent) CreatedTime() (time.Time, error) {
+	return unixTime(e.CreatedTimestamp)
+}
+
+// UpdatedTime returns UpdatedTimestamp.
+func (e PaymentConfigurationUpdateEvent) UpdatedTime() (time.Time, error) {
+	return unixTime(e.UpdatedTimestamp)
+}
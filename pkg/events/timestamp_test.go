@@ -0,0 +1,33 @@
+package events
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	got, err := ParseTimestamp("1700000000")
+	if err != nil || !got.Equal(time.Unix(1700000000, 0)) {
+		t.Fatalf("unix seconds: got %v, %v", got, err)
+	}
+	got, err = ParseTimestamp("2023-11-14T22:13:20Z")
+	if err != nil || !got.Equal(time.Unix(1700000000, 0)) {
+		t.Fatalf("rfc 3339: got %v, %v", got, err)
+	}
+	for _, value := range []string{"", "yesterday"} {
+		if _, err := ParseTimestamp(value); !errors.Is(err, ErrInvalidTimestamp) {
+			t.Fatalf("%q: expected ErrInvalidTimestamp, got %v", value, err)
+		}
+	}
+}
+
+func TestEventTimeAccessors(t *testing.T) {
+	event := NewTextMessageEvent(NewBaseMessageEvent(BaseMessageEventParams{Timestamp: "1700000000"}), "hi")
+	if got, err := event.Time(); err != nil || got.Unix() != 1700000000 {
+		t.Fatalf("message event: got %v, %v", got, err)
+	}
+	if _, err := (UserPreference{}).Time(); !errors.Is(err, ErrInvalidTimestamp) {
+		t.Fatalf("expected ErrInvalidTimestamp for a missing preference time, got %v", err)
+	}
+}
@@ -48,4 +48,9 @@ const (
 	SmbMessageEchoesEventType                EventType = "smb_message_echoes"
 	TemplateCategoryUpdateEventType          EventType = "template_category_update"
 	HistoryEventType                         EventType = "history"
+	EventQueueOverflowEventType              EventType = "event_queue_overflow"
+	MessageStatusChangedEventType            EventType = "message_status_changed"
+	TemplateFallbackSentEventType            EventType = "template_fallback_sent"
+	QueuedMessageReleasedEventType           EventType = "queued_message_released"
+	MessagingLimitWarningEventType           EventType = "messaging_limit_warning"
 )
@@ -12,6 +12,9 @@ type UserPreference struct {
 	Category  string `json:"category"` // e.g., "marketing_messages"
 	Value     string `json:"value"`    // Preference value
 	Timestamp int64  `json:"timestamp"`
+	// BSUID identity fields, present when Meta includes them.
+	UserId       string `json:"user_id,omitempty"`
+	ParentUserId string `json:"parent_user_id,omitempty"`
 }
 
 // NewUserPreferencesEvent creates a new UserPreferencesEvent instance
@@ -7,6 +7,7 @@ import (
 
 	"github.com/wapikit/wapi.go/internal/request_client"
 	"github.com/wapikit/wapi.go/manager"
+	"github.com/wapikit/wapi.go/pkg/events"
 )
 
 // MessagingClient represents a WhatsApp client.
@@ -69,3 +70,9 @@ func (client *MessagingClient) Deregister() (RegisterResponse, error) {
 	json.Unmarshal([]byte(response), &registerResponse)
 	return registerResponse, nil
 }
+
+// Bind makes an event decoded with events.Unmarshal able to reply and react
+// again through this client. It reports false for events that never send.
+func (client *MessagingClient) Bind(event events.BaseEvent) bool {
+	return events.Bind(event, *client.Requester)
+}